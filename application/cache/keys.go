@@ -3,9 +3,11 @@ package cache
 import "fmt"
 
 var (
-	Prefix       = "xxx"             //业务前缀
-	UserInfo     = "user:info:%d"    //用户数据     user:info:{用户ID}
-	TokenInfo    = "user:token:%s"   //token数据   user:token:{token值}
+	Prefix        = "xxx"                //业务前缀
+	UserInfo      = "user:info:%d"       //用户数据     user:info:{用户ID}
+	TokenInfo     = "user:token:%s"      //token数据   user:token:{token值}
+	OauthState    = "oauth:state:%s"     //第三方登录  oauth:state:{state值}
+	ApiClientPlan = "api_client:plan:%s" //调用方套餐  api_client:plan:{app_key}
 )
 
 //FormatKey 格式化key，拼接业务前缀以及的参数
@@ -17,5 +19,5 @@ func FormatKey(key string, params ...interface{}) string {
 	if len(Prefix) > 0 {
 		key = Prefix + ":" + key
 	}
-	return fmt.Sprintf(key, params)
+	return fmt.Sprintf(key, params...)
 }
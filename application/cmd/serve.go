@@ -2,10 +2,14 @@ package cmd
 
 import (
 	"fmt"
-	"github.com/gin-gonic/gin"
-	"github.com/spf13/cobra"
 	"gin-api/bootstrap"
 	"gin-api/pkg/app"
+	"gin-api/pkg/config"
+	"gin-api/pkg/console"
+	"gin-api/pkg/server"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cobra"
+	"time"
 )
 
 // CmdServe represents the available web sub-command.
@@ -16,6 +20,26 @@ var CmdServe = &cobra.Command{
 	Args:  cobra.NoArgs,
 }
 
+// serveFlags 存储 serve 命令的选项，未设置的选项使用 config/server.go 中的配置
+var serveFlags struct {
+	tlsCert      string
+	tlsKey       string
+	clientCA     string
+	clientAuth   string
+	redirectPort string
+	h2c          bool
+}
+
+func init() {
+	flags := CmdServe.Flags()
+	flags.StringVar(&serveFlags.tlsCert, "tls-cert", "", "TLS certificate file, enables https and HTTP/2")
+	flags.StringVar(&serveFlags.tlsKey, "tls-key", "", "TLS private key file")
+	flags.StringVar(&serveFlags.clientCA, "client-ca", "", "CA file used to verify client certificates (mutual TLS)")
+	flags.StringVar(&serveFlags.clientAuth, "client-auth", "", "client certificate policy: verify_if_given or require")
+	flags.StringVar(&serveFlags.redirectPort, "redirect-port", "", "plain http port that redirects to https")
+	flags.BoolVar(&serveFlags.h2c, "h2c", false, "serve HTTP/2 without TLS (h2c)")
+}
+
 func runWeb(cmd *cobra.Command, args []string) {
 	// 设置 gin 的运行模式，支持 debug, release, test
 	// release 会屏蔽调试信息，官方建议生产环境中使用
@@ -24,5 +48,35 @@ func runWeb(cmd *cobra.Command, args []string) {
 	//gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
 	bootstrap.SetupRoute(router)
-	router.Run(fmt.Sprintf(":%d", app.HttpPort()))
+
+	opt := server.Options{
+		Addr:           fmt.Sprintf(":%d", app.HttpPort()),
+		CertFile:       flagOrConfig(cmd, "tls-cert", serveFlags.tlsCert, "server.tls_cert"),
+		KeyFile:        flagOrConfig(cmd, "tls-key", serveFlags.tlsKey, "server.tls_key"),
+		ClientCAFile:   flagOrConfig(cmd, "client-ca", serveFlags.clientCA, "server.client_ca"),
+		ClientAuth:     flagOrConfig(cmd, "client-auth", serveFlags.clientAuth, "server.client_auth"),
+		H2C:            config.GetBool("server.h2c"),
+		ReloadInterval: time.Duration(config.GetInt("server.reload_interval", 10)) * time.Second,
+	}
+	if cmd.Flags().Changed("h2c") {
+		opt.H2C = serveFlags.h2c
+	}
+	if port := flagOrConfig(cmd, "redirect-port", serveFlags.redirectPort, "server.redirect_port"); port != "" {
+		opt.RedirectAddr = ":" + port
+	}
+	if (opt.CertFile == "") != (opt.KeyFile == "") {
+		console.Exit("--tls-cert and --tls-key must be provided together")
+	}
+
+	if err := server.Run(router, opt); err != nil {
+		console.Exit(fmt.Sprintf("Failed to start server: %s", err.Error()))
+	}
+}
+
+// flagOrConfig 命令行选项优先，未设置时读取配置
+func flagOrConfig(cmd *cobra.Command, flag string, value string, configPath string) string {
+	if cmd.Flags().Changed(flag) {
+		return value
+	}
+	return config.GetString(configPath)
 }
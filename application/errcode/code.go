@@ -7,9 +7,12 @@ const (
 	Fail            = 400
 	Unauthorized    = 401
 	Sign            = 402
+	Forbidden       = 403
 	No              = 404
+	TooLarge        = 413
 	TooManyRequests = 429
 	Fatal           = 500
+	Unavailable     = 503
 )
 
 var textMap = map[int]string{
@@ -17,16 +20,23 @@ var textMap = map[int]string{
 	Fail:            "失败",
 	Unauthorized:    "认证失败",
 	Sign:            "签名失败",
+	Forbidden:       "禁止访问",
 	No:              "路由不存在",
+	TooLarge:        "请求体过大",
 	TooManyRequests: "请求太频繁",
 	Fatal:           "系统异常",
+	Unavailable:     "系统繁忙, 请稍后重试",
 }
 
 var httpMap = map[int]int{
 	Success:         http.StatusOK,
 	Fail:            http.StatusOK,
+	Forbidden:       http.StatusForbidden,
 	No:              http.StatusOK,
+	TooLarge:        http.StatusRequestEntityTooLarge,
+	TooManyRequests: http.StatusTooManyRequests,
 	Fatal:           http.StatusInternalServerError,
+	Unavailable:     http.StatusServiceUnavailable,
 }
 
 //CodeText 获取错误码描述
@@ -0,0 +1,112 @@
+// Package controller 存放路由对应的处理函数
+package controller
+
+import (
+	appcache "gin-api/application/cache"
+	"gin-api/application/errcode"
+	"gin-api/application/http/model"
+	"gin-api/pkg/cache"
+	"gin-api/pkg/config"
+	"gin-api/pkg/jwt"
+	"gin-api/pkg/oauth"
+	"gin-api/pkg/response"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"time"
+)
+
+//oauthSession 跳转授权前暂存的登录会话, 回调时根据 state 取回
+type oauthSession struct {
+	Provider string `json:"provider"`
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+}
+
+//OauthRedirect 生成 state/code_verifier/nonce 并跳转到第三方授权页
+func OauthRedirect(ctx *gin.Context) {
+	provider, err := oauth.Get(ctx.Param("provider"))
+	if err != nil {
+		response.Json(ctx, errcode.Fail, err.Error(), nil)
+		return
+	}
+
+	state := oauth.GenerateState()
+	session := oauthSession{
+		Provider: provider.Name,
+		Verifier: oauth.GenerateVerifier(),
+		Nonce:    oauth.GenerateNonce(),
+	}
+
+	authUrl, err := provider.AuthCodeURL(ctx.Request.Context(), state, session.Nonce, session.Verifier)
+	if err != nil {
+		response.Json(ctx, errcode.Fail, err.Error(), nil)
+		return
+	}
+
+	ttl := time.Duration(config.GetInt("oauth.state_ttl", 600)) * time.Second
+	if err := cache.Ctx(ctx.Request.Context()).Set(appcache.FormatKey(appcache.OauthState, state), session, ttl); err != nil {
+		response.Json(ctx, errcode.Fatal, err.Error(), nil)
+		return
+	}
+	ctx.Redirect(http.StatusFound, authUrl)
+}
+
+//OauthCallback 校验 state 后使用授权码换取令牌, 将外部身份映射为本地用户并签发 jwt
+func OauthCallback(ctx *gin.Context) {
+	if e := ctx.Query("error"); e != "" {
+		response.Json(ctx, errcode.Unauthorized, e+": "+ctx.Query("error_description"), nil)
+		return
+	}
+
+	state, code := ctx.Query("state"), ctx.Query("code")
+	if state == "" || code == "" {
+		response.Json(ctx, errcode.Fail, "缺少 state 或 code", nil)
+		return
+	}
+
+	//state 只能使用一次, 读取并删除是原子操作, 并发的回调中只有一个能取到
+	session := oauthSession{}
+	ok, err := cache.Ctx(ctx.Request.Context()).Pull(appcache.FormatKey(appcache.OauthState, state), &session)
+	if err != nil {
+		response.Json(ctx, errcode.Fatal, err.Error(), nil)
+		return
+	}
+	if !ok || session.Provider != ctx.Param("provider") {
+		response.Json(ctx, errcode.Unauthorized, "state 无效或已过期", nil)
+		return
+	}
+
+	provider, err := oauth.Get(session.Provider)
+	if err != nil {
+		response.Json(ctx, errcode.Fail, err.Error(), nil)
+		return
+	}
+
+	token, err := provider.Exchange(ctx.Request.Context(), code, session.Verifier)
+	if err != nil {
+		response.Json(ctx, errcode.Unauthorized, err.Error(), nil)
+		return
+	}
+
+	identity, err := provider.Identity(ctx.Request.Context(), token, session.Nonce)
+	if err != nil {
+		response.Json(ctx, errcode.Unauthorized, err.Error(), nil)
+		return
+	}
+
+	user, err := model.BindOauthUser(identity.Provider, identity.Subject, identity.Email, identity.Name, identity.EmailVerified)
+	if err != nil {
+		response.Json(ctx, errcode.Fatal, err.Error(), nil)
+		return
+	}
+
+	ttl := time.Duration(config.GetInt("oauth.token_ttl", 3600)) * time.Second
+	claims := gin.H{
+		"user_id":  user.UserId,
+		"oauth_id": user.ID,
+		"provider": user.Provider,
+		"email":    user.Email,
+		"name":     user.Name,
+	}
+	response.Json(ctx, errcode.Success, "success", jwt.GenerateToken(claims, ttl))
+}
@@ -0,0 +1,404 @@
+package controller
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-api/application/errcode"
+	"gin-api/application/http/model"
+	"gin-api/pkg/cache"
+	"gin-api/pkg/jwt"
+	"gin-api/pkg/oauth"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	fakeClientID     = "gin-api"
+	fakeClientSecret = "secret"
+	fakeRedirectURL  = "http://app.test/api/oauth/fake/callback"
+)
+
+// fakeIdP 进程内的 OIDC 提供方, 实现 discovery、授权、令牌与 JWKS 端点, 令牌端点会校验 PKCE
+type fakeIdP struct {
+	*httptest.Server
+	t   *testing.T
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	//claims 下一次登录签发的 id_token 中的用户信息
+	claims map[string]interface{}
+	//codes 已签发的授权码对应的授权请求
+	codes map[string]url.Values
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp := &fakeIdP{t: t, key: key, codes: make(map[string]url.Values)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+//login 设置下一次登录的用户
+func (idp *fakeIdP) login(claims map[string]interface{}) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.claims = claims
+}
+
+func (idp *fakeIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 idp.URL,
+		"authorization_endpoint": idp.URL + "/authorize",
+		"token_endpoint":         idp.URL + "/token",
+		"jwks_uri":               idp.URL + "/jwks",
+	})
+}
+
+//authorize 用户同意授权, 记录授权请求后带着授权码跳转回 redirect_uri
+func (idp *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != fakeClientID || query.Get("redirect_uri") != fakeRedirectURL {
+		http.Error(w, "invalid client", http.StatusBadRequest)
+		return
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		http.Error(w, "pkce required", http.StatusBadRequest)
+		return
+	}
+
+	code := base64.RawURLEncoding.EncodeToString(randomBytes(idp.t, 16))
+	idp.mu.Lock()
+	idp.codes[code] = query
+	idp.mu.Unlock()
+
+	redirect, _ := url.Parse(fakeRedirectURL)
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+//token 校验客户端凭证与 code_verifier 后签发 id_token, 授权码只能使用一次
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	if id, secret, ok := r.BasicAuth(); !ok || id != fakeClientID || secret != fakeClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+	r.ParseForm()
+
+	idp.mu.Lock()
+	auth, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	claims := idp.claims
+	idp.mu.Unlock()
+
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != fakeRedirectURL {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.Get("code_challenge") {
+		http.Error(w, `{"error":"invalid_grant","error_description":"code_verifier mismatch"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().Unix()
+	payload := map[string]interface{}{
+		"iss":   idp.URL,
+		"aud":   fakeClientID,
+		"iat":   now,
+		"exp":   now + 300,
+		"nonce": auth.Get("nonce"),
+	}
+	for k, v := range claims {
+		payload[k] = v
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access-" + r.PostForm.Get("code"),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idp.sign(payload),
+	})
+}
+
+func (idp *fakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+//sign 使用 RS256 签发 jwt
+func (idp *fakeIdP) sign(payload map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	body, _ := json.Marshal(payload)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, idp.key, crypto.SHA256, digest[:])
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+//setupOauthTest 注册指向 fakeIdP 的提供方, 使用 sqlite 与内存缓存, 返回挂载了登录路由的 gin
+func setupOauthTest(t *testing.T) (*gin.Engine, *fakeIdP) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "oauth.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&model.User{}, &model.OauthUser{}); err != nil {
+		t.Fatal(err)
+	}
+	model.SetDB(db)
+	cache.Init(cache.NewMemory(0, 0))
+
+	idp := newFakeIdP(t)
+	oauth.Register(oauth.NewProvider(oauth.Config{
+		Name:         "fake",
+		ClientID:     fakeClientID,
+		ClientSecret: fakeClientSecret,
+		RedirectURL:  fakeRedirectURL,
+		Issuer:       idp.URL,
+		UsePKCE:      true,
+	}))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/api/oauth/:provider/redirect", OauthRedirect)
+	router.GET("/api/oauth/:provider/callback", OauthCallback)
+	return router, idp
+}
+
+//oauthResponse 登录接口的响应
+type oauthResponse struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+	Data string `json:"data"`
+}
+
+//authorize 访问 redirect 接口, 再像浏览器一样跟随跳转到提供方授权, 返回提供方跳转回来的 callback 地址
+func authorize(t *testing.T, router *gin.Engine) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/oauth/fake/redirect", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("redirect 接口返回 %d: %s", w.Code, w.Body.String())
+	}
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("提供方授权失败: %d", resp.StatusCode)
+	}
+	return resp.Header.Get("Location")
+}
+
+//callback 访问提供方跳转回来的 callback 地址
+func callback(t *testing.T, router *gin.Engine, callbackURL string) oauthResponse {
+	t.Helper()
+	target, err := url.Parse(callbackURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target.RequestURI(), nil))
+
+	var res oauthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("callback 响应解析失败: %v, %s", err, w.Body.String())
+	}
+	return res
+}
+
+//login 完成一次完整的登录, 返回签发的 jwt 中的 user_id
+func login(t *testing.T, router *gin.Engine, idp *fakeIdP, claims map[string]interface{}) uint64 {
+	t.Helper()
+	idp.login(claims)
+	res := callback(t, router, authorize(t, router))
+	if res.Code != errcode.Success {
+		t.Fatalf("登录失败: %+v", res)
+	}
+
+	customClaims, err := jwt.VerifyToken(res.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, _ := customClaims.(map[string]interface{})["user_id"].(float64)
+	if userId == 0 {
+		t.Fatalf("jwt 中没有本地用户 id: %+v", customClaims)
+	}
+	return uint64(userId)
+}
+
+func TestOauthLogin(t *testing.T) {
+	router, idp := setupOauthTest(t)
+	alice := map[string]interface{}{"sub": "alice", "email": "alice@example.com", "email_verified": true, "name": "Alice"}
+
+	userId := login(t, router, idp, alice)
+	var user model.User
+	if err := model.GetDB().Take(&user, userId).Error; err != nil {
+		t.Fatalf("没有创建本地用户: %v", err)
+	}
+	if user.Email != "alice@example.com" || user.Name != "Alice" {
+		t.Fatalf("本地用户信息有误: %+v", user)
+	}
+
+	//再次登录使用同一个本地用户
+	if again := login(t, router, idp, alice); again != userId {
+		t.Fatalf("再次登录的 user_id = %d, 期望 %d", again, userId)
+	}
+
+	//同一个已确认的邮箱的另一个外部身份关联到同一个本地用户
+	other := map[string]interface{}{"sub": "alice-2", "email": "alice@example.com", "email_verified": true, "name": "Alice"}
+	if linked := login(t, router, idp, other); linked != userId {
+		t.Fatalf("已确认邮箱的 user_id = %d, 期望 %d", linked, userId)
+	}
+
+	//未确认的邮箱不能关联到已有用户
+	mallory := map[string]interface{}{"sub": "mallory", "email": "alice@example.com", "email_verified": false, "name": "Mallory"}
+	if stranger := login(t, router, idp, mallory); stranger == userId {
+		t.Fatal("未确认的邮箱关联到了已有用户")
+	}
+
+	var users, bindings int64
+	model.GetDB().Model(&model.User{}).Count(&users)
+	model.GetDB().Model(&model.OauthUser{}).Count(&bindings)
+	if users != 2 || bindings != 3 {
+		t.Fatalf("本地用户 %d 个, 绑定关系 %d 个, 期望 2 个与 3 个", users, bindings)
+	}
+}
+
+func TestOauthLinksLegacyBinding(t *testing.T) {
+	router, idp := setupOauthTest(t)
+
+	//之前的版本创建的绑定关系没有关联本地用户
+	legacy := &model.OauthUser{Provider: "fake", Subject: "bob", Email: "bob@example.com", Name: "Bob"}
+	if err := model.GetDB().Create(legacy).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	userId := login(t, router, idp, map[string]interface{}{"sub": "bob", "email": "bob@example.com", "email_verified": true, "name": "Bob"})
+	var binding model.OauthUser
+	model.GetDB().Take(&binding, legacy.ID)
+	if binding.UserId != userId {
+		t.Fatalf("绑定关系的 user_id = %d, 期望 %d", binding.UserId, userId)
+	}
+}
+
+func TestOauthCallbackRejectsReplayedState(t *testing.T) {
+	router, idp := setupOauthTest(t)
+	idp.login(map[string]interface{}{"sub": "carol", "email": "carol@example.com", "email_verified": true})
+
+	callbackURL := authorize(t, router)
+	if res := callback(t, router, callbackURL); res.Code != errcode.Success {
+		t.Fatalf("登录失败: %+v", res)
+	}
+	//state 与授权码都只能使用一次
+	if res := callback(t, router, callbackURL); res.Code != errcode.Unauthorized {
+		t.Fatalf("重放 callback 返回 %+v, 期望 %d", res, errcode.Unauthorized)
+	}
+}
+
+func TestOauthCallbackConcurrentState(t *testing.T) {
+	router, idp := setupOauthTest(t)
+	idp.login(map[string]interface{}{"sub": "erin"})
+
+	//并发的回调中只有一个能取到 state, 其余在换取令牌之前就被拒绝
+	target, _ := url.Parse(authorize(t, router))
+	results := make([]oauthResponse, 8)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target.RequestURI(), nil))
+			json.Unmarshal(w.Body.Bytes(), &results[i])
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, res := range results {
+		switch {
+		case res.Code == errcode.Success:
+			succeeded++
+		case res.Code != errcode.Unauthorized || res.Msg != "state 无效或已过期":
+			t.Fatalf("并发的回调返回 %+v, 期望 state 无效", res)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d 个并发的回调登录成功, 期望 1 个", succeeded)
+	}
+}
+
+func TestOauthCallbackRejectsWrongVerifier(t *testing.T) {
+	router, idp := setupOauthTest(t)
+	idp.login(map[string]interface{}{"sub": "dave"})
+
+	//拦截授权请求, 换成攻击者自己的 code_challenge, 提供方应拒绝用原 code_verifier 换取令牌
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/oauth/fake/redirect", nil))
+	authURL, _ := url.Parse(w.Header().Get("Location"))
+	query := authURL.Query()
+	query.Set("code_challenge", oauth.ChallengeS256(oauth.GenerateVerifier()))
+	authURL.RawQuery = query.Encode()
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	res := callback(t, router, resp.Header.Get("Location"))
+	if res.Code != errcode.Unauthorized || !strings.Contains(res.Msg, "code_verifier mismatch") {
+		t.Fatalf("错误的 code_verifier 换取了令牌: %+v", res)
+	}
+}
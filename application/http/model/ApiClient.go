@@ -0,0 +1,61 @@
+package model
+
+import (
+	"time"
+)
+
+//ApiClient 接口调用方的凭证
+type ApiClient struct {
+	ID     uint64 `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	Name   string `gorm:"column:name;type:varchar(64);" json:"name"`
+	AppKey string `gorm:"column:app_key;type:varchar(64);uniqueIndex;" json:"app_key"`
+	Secret string `gorm:"column:secret;type:varchar(128);" json:"-"`
+	//OldSecret 轮换前的 secret, 在 OldSecretExpiredAt 之前仍然有效
+	OldSecret          string     `gorm:"column:old_secret;type:varchar(128);" json:"-"`
+	OldSecretExpiredAt *time.Time `gorm:"column:old_secret_expired_at;" json:"old_secret_expired_at"`
+	//Plan 套餐, 对应配置 limiter.plans 中的限流规则
+	Plan string `gorm:"column:plan;type:varchar(32);default:free;" json:"plan"`
+	//Status 1 启用 0 禁用
+	Status int `gorm:"column:status;default:1;" json:"status"`
+	TimestampsField
+}
+
+//ApiClientSecrets 返回 appKey 当前有效的全部 secret, 客户端不存在或被禁用时返回空
+func ApiClientSecrets(appKey string) ([]string, error) {
+	var clients []ApiClient
+	err := GetDB().Where("app_key = ? AND status = ?", appKey, 1).Limit(1).Find(&clients).Error
+	if err != nil || len(clients) == 0 {
+		return nil, err
+	}
+
+	client := clients[0]
+	secrets := []string{client.Secret}
+	if client.OldSecret != "" && client.OldSecretExpiredAt != nil && client.OldSecretExpiredAt.After(time.Now()) {
+		secrets = append(secrets, client.OldSecret)
+	}
+	return secrets, nil
+}
+
+//ApiClientPlan 返回调用方的套餐, 客户端不存在或被禁用时返回空
+func ApiClientPlan(appKey string) (string, error) {
+	var clients []ApiClient
+	err := GetDB().Where("app_key = ? AND status = ?", appKey, 1).Limit(1).Find(&clients).Error
+	if err != nil || len(clients) == 0 {
+		return "", err
+	}
+	return clients[0].Plan, nil
+}
+
+//RotateApiClientSecret 轮换 secret, 旧 secret 在 grace 时间内仍然有效
+func RotateApiClientSecret(appKey, newSecret string, grace time.Duration) error {
+	var client ApiClient
+	if err := GetDB().Where("app_key = ?", appKey).Take(&client).Error; err != nil {
+		return err
+	}
+	expiredAt := time.Now().Add(grace)
+	return GetDB().Model(&client).Updates(map[string]interface{}{
+		"secret":                newSecret,
+		"old_secret":            client.Secret,
+		"old_secret_expired_at": &expiredAt,
+	}).Error
+}
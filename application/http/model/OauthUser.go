@@ -0,0 +1,52 @@
+package model
+
+import "gorm.io/gorm"
+
+//OauthUser 第三方登录身份与本地用户的绑定关系
+type OauthUser struct {
+	ID       uint64 `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	UserId   uint64 `gorm:"column:user_id;index;" json:"user_id"`
+	Provider string `gorm:"column:provider;type:varchar(32);uniqueIndex:uk_provider_subject;" json:"provider"`
+	Subject  string `gorm:"column:subject;type:varchar(191);uniqueIndex:uk_provider_subject;" json:"subject"`
+	Email    string `gorm:"column:email;type:varchar(191);" json:"email"`
+	Name     string `gorm:"column:name;type:varchar(191);" json:"name"`
+	TimestampsField
+}
+
+//BindOauthUser 根据提供方和外部唯一标识查找绑定关系, 不存在则创建, 存在则同步最新的邮箱和昵称;
+//绑定关系与本地用户在同一个事务中创建, 返回的 UserId 总是有效的本地用户 id
+//emailVerified 为提供方是否确认过该邮箱, 只有确认过的邮箱才会关联到已有的同邮箱用户
+func BindOauthUser(provider, subject, email, name string, emailVerified bool) (*OauthUser, error) {
+	user := &OauthUser{}
+	err := GetDB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("provider = ? AND subject = ?", provider, subject).Take(user).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return err
+		}
+		found := err == nil
+
+		//新的身份, 或修复之前没有关联本地用户的绑定关系
+		linked := false
+		if user.UserId == 0 {
+			local, err := findOrCreateUser(tx, email, name, emailVerified)
+			if err != nil {
+				return err
+			}
+			user.UserId, linked = local.ID, true
+		}
+
+		if !found {
+			user.Provider, user.Subject, user.Email, user.Name = provider, subject, email, name
+			return tx.Create(user).Error
+		}
+		if !linked && user.Email == email && user.Name == name {
+			return nil
+		}
+		user.Email, user.Name = email, name
+		return tx.Model(user).Updates(map[string]interface{}{"user_id": user.UserId, "email": email, "name": name}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
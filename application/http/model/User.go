@@ -0,0 +1,32 @@
+package model
+
+import "gorm.io/gorm"
+
+//User 本地用户
+type User struct {
+	ID    uint64 `gorm:"column:id;primaryKey;autoIncrement;" json:"id"`
+	Name  string `gorm:"column:name;type:varchar(191);" json:"name"`
+	Email string `gorm:"column:email;type:varchar(191);index;" json:"email"`
+	TimestampsField
+}
+
+//findOrCreateUser 在事务 tx 中为第三方身份查找本地用户, 找不到则创建;
+//只有提供方确认过的邮箱才用来匹配已有用户, 否则任何人都可以用他人的邮箱注册第三方账号来登录该用户
+func findOrCreateUser(tx *gorm.DB, email, name string, emailVerified bool) (*User, error) {
+	user := &User{}
+	if email != "" && emailVerified {
+		err := tx.Where("email = ?", email).Order("id").Take(user).Error
+		if err == nil {
+			return user, nil
+		}
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+	}
+
+	user = &User{Name: name}
+	if emailVerified {
+		user.Email = email
+	}
+	return user, tx.Create(user).Error
+}
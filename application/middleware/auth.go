@@ -27,12 +27,13 @@ func JwtAuth() gin.HandlerFunc {
 			token = headerToken
 		}
 
-		customClaims, err := jwt.VerifyToken(token)
+		payload, err := jwt.VerifyPayload(token)
 		if err != nil {
 			response.JsonAbort(c, errcode.Unauthorized, err.Error(),nil)
 			return
 		}
-		c.Set("custom_claims", customClaims)
+		c.Set("custom_claims", payload.CustomClaims)
+		c.Set("jwt_payload", payload)
 
 		c.Next()
 	}
@@ -0,0 +1,318 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"gin-api/application/errcode"
+	"gin-api/pkg/cache"
+	"gin-api/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//responseTag 所有缓存的响应都带有该标签, PurgeCache() 不传标签时清空全部响应缓存
+const responseTag = "response"
+
+//cacheOptions CacheResponse 的可选参数
+type cacheOptions struct {
+	headers []string
+	query   []string
+	tags    []string
+	bypass  func(c *gin.Context) bool
+}
+
+//CacheOption CacheResponse 的可选参数
+type CacheOption func(o *cacheOptions)
+
+//WithVaryHeader 按请求头区分缓存, 如 WithVaryHeader("Accept-Language")
+func WithVaryHeader(names ...string) CacheOption {
+	return func(o *cacheOptions) {
+		o.headers = append(o.headers, names...)
+	}
+}
+
+//WithVaryQuery 只按指定的查询参数区分缓存, 缺省按全部查询参数区分
+func WithVaryQuery(names ...string) CacheOption {
+	return func(o *cacheOptions) {
+		o.query = append(o.query, names...)
+	}
+}
+
+//WithTags 为缓存添加标签, 之后可以通过 PurgeCache(tags...) 清除
+func WithTags(tags ...string) CacheOption {
+	return func(o *cacheOptions) {
+		o.tags = append(o.tags, tags...)
+	}
+}
+
+//WithBypass bypass 返回 true 时不读取也不写入缓存, 如:
+//	WithBypass(func(c *gin.Context) bool { return c.Query("fresh") == "1" })
+func WithBypass(bypass func(c *gin.Context) bool) CacheOption {
+	return func(o *cacheOptions) {
+		o.bypass = bypass
+	}
+}
+
+//cachedResponse 缓存的响应
+type cachedResponse struct {
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	ETag         string      `json:"etag"`
+	LastModified int64       `json:"last_modified"`
+}
+
+//CacheResponse 缓存 GET 请求的响应(状态码、接口设置的响应头以及响应内容)ttl 时间, 用法如下:
+//	api.GET("/articles", middleware.CacheResponse(time.Minute, nil, middleware.WithTags("articles")), controller.Articles)
+//	api.GET("/me", middleware.JwtAuth(), middleware.CacheResponse(time.Minute, middleware.KeyBySubject()), controller.Me)
+//缓存按请求路径、查询参数以及 WithVaryHeader 指定的请求头区分(并输出 Vary 响应头), keyFunc 不为 nil 时再按其结果区分(如按用户),
+//此时输出 Cache-Control: private, keyFunc 返回空字符串时不使用缓存。响应带有 ETag 与 Last-Modified, 客户端携带 If-None-Match 或 If-Modified-Since 时可能返回 304。
+//只有 http 状态码为 200 且 errcode 为 Success 的 json 响应才会被缓存, 设置了 Cookie 的响应也不会被缓存
+func CacheResponse(ttl time.Duration, keyFunc KeyFunc, options ...CacheOption) gin.HandlerFunc {
+	o := &cacheOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	tagged := cache.Tags(append([]string{responseTag}, o.tags...)...)
+
+	return func(c *gin.Context) {
+		if c.Request.Method != http.MethodGet || (o.bypass != nil && o.bypass(c)) {
+			c.Header("X-Cache", "BYPASS")
+			c.Next()
+			return
+		}
+		var userKey string
+		if keyFunc != nil {
+			if userKey = keyFunc(c); userKey == "" {
+				c.Header("X-Cache", "BYPASS")
+				c.Next()
+				return
+			}
+		}
+
+		//响应随这些请求头或用户不同而不同, 告知代理与 CDN 不能把一份响应返回给所有人
+		for _, name := range o.headers {
+			c.Writer.Header().Add("Vary", name)
+		}
+		if keyFunc != nil {
+			c.Header("Cache-Control", "private")
+		}
+
+		key := tagged.Key(responseKey(c, o, userKey))
+		store := cache.Ctx(c.Request.Context())
+		entry := &cachedResponse{}
+		ok, err := store.GetAs(key, entry)
+		logger.LogIf("cache-response", err)
+		if ok {
+			c.Header("X-Cache", "HIT")
+			writeCached(c, entry)
+			c.Abort()
+			return
+		}
+
+		c.Header("X-Cache", "MISS")
+		before := c.Writer.Header().Clone()
+		writer := &cacheWriter{ResponseWriter: c.Writer, status: http.StatusOK}
+		c.Writer = writer
+		defer func() {
+			c.Writer = writer.ResponseWriter
+		}()
+
+		c.Next()
+
+		if writer.streaming {
+			return
+		}
+		c.Writer = writer.ResponseWriter
+		if !cacheable(writer) {
+			writer.flush()
+			return
+		}
+
+		entry = &cachedResponse{
+			Status:       writer.status,
+			Header:       changedHeader(before, c.Writer.Header()),
+			Body:         writer.body.Bytes(),
+			LastModified: time.Now().Unix(),
+		}
+		sum := sha1.Sum(entry.Body)
+		entry.ETag = `"` + hex.EncodeToString(sum[:16]) + `"`
+		logger.LogIf("cache-response", store.Set(key, entry, ttl))
+		writeCached(c, entry)
+	}
+}
+
+//PurgeCache 清除带有任意一个标签的响应缓存, 不传标签时清除全部响应缓存
+func PurgeCache(tags ...string) {
+	if len(tags) == 0 {
+		tags = []string{responseTag}
+	}
+	for _, tag := range tags {
+		cache.Tags(tag).Flush()
+	}
+}
+
+//responseKey 由请求路径、查询参数、请求头以及 keyFunc 的结果计算缓存的 key
+func responseKey(c *gin.Context, o *cacheOptions, userKey string) string {
+	query := c.Request.URL.Query()
+	if len(o.query) > 0 {
+		picked := make(map[string][]string, len(o.query))
+		for _, name := range o.query {
+			if values, ok := query[name]; ok {
+				picked[name] = values
+			}
+		}
+		query = picked
+	}
+
+	parts := []string{c.Request.URL.Path, query.Encode()}
+	for _, name := range o.headers {
+		parts = append(parts, name+"="+c.GetHeader(name))
+	}
+	parts = append(parts, userKey)
+	sum := sha1.Sum([]byte(strings.Join(parts, "\n")))
+	return "response:" + hex.EncodeToString(sum[:])
+}
+
+//cacheable 只缓存 http 状态码为 200、errcode 为 Success 且未设置 Cookie 的 json 响应
+func cacheable(w *cacheWriter) bool {
+	if w.status != http.StatusOK || w.Header().Get("Set-Cookie") != "" {
+		return false
+	}
+	var body struct {
+		Code *int `json:"code"`
+	}
+	if err := json.Unmarshal(w.body.Bytes(), &body); err != nil || body.Code == nil {
+		return false
+	}
+	return *body.Code == errcode.Success
+}
+
+//changedHeader 返回接口设置或修改的响应头, 之前的中间件设置的响应头(如跨域、请求 id)每次请求都会重新设置, 不需要缓存
+func changedHeader(before http.Header, after http.Header) http.Header {
+	changed := http.Header{}
+	for name, values := range after {
+		if name == "X-Cache" {
+			continue
+		}
+		if old, ok := before[name]; !ok || strings.Join(old, "\n") != strings.Join(values, "\n") {
+			changed[name] = values
+		}
+	}
+	return changed
+}
+
+//writeCached 输出缓存的响应, 客户端的缓存仍然有效时返回 304
+func writeCached(c *gin.Context, entry *cachedResponse) {
+	header := c.Writer.Header()
+	for name, values := range entry.Header {
+		header[name] = values
+	}
+	lastModified := time.Unix(entry.LastModified, 0).UTC()
+	header.Set("ETag", entry.ETag)
+	header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+
+	if notModified(c.Request, entry.ETag, lastModified) {
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return
+	}
+	c.Data(entry.Status, header.Get("Content-Type"), entry.Body)
+}
+
+//notModified 按 If-None-Match 判断客户端的缓存是否仍然有效, 没有 If-None-Match 时才使用 If-Modified-Since
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !lastModified.After(since)
+}
+
+//cacheWriter 暂存响应, 以便在输出之前判断是否缓存并设置 ETag。
+//接口调用 Flush(如 c.Stream)时改为直接输出, 这类响应不会被缓存
+type cacheWriter struct {
+	gin.ResponseWriter
+	body        bytes.Buffer
+	status      int
+	wroteHeader bool //接口是否已设置状态码, 只设置状态码(如 c.Status(204))或响应内容为空时也视为已输出
+	streaming   bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.streaming {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	w.wroteHeader = true
+}
+
+func (w *cacheWriter) WriteHeaderNow() {
+	if w.streaming {
+		w.ResponseWriter.WriteHeaderNow()
+		return
+	}
+	w.wroteHeader = true
+}
+
+func (w *cacheWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(data)
+	}
+	w.wroteHeader = true
+	return w.body.Write(data)
+}
+
+func (w *cacheWriter) WriteString(s string) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.WriteString(s)
+	}
+	w.wroteHeader = true
+	return w.body.WriteString(s)
+}
+
+func (w *cacheWriter) Status() int {
+	if w.streaming {
+		return w.ResponseWriter.Status()
+	}
+	return w.status
+}
+
+func (w *cacheWriter) Size() int {
+	if w.streaming {
+		return w.ResponseWriter.Size()
+	}
+	return w.body.Len()
+}
+
+func (w *cacheWriter) Written() bool {
+	return w.streaming || w.wroteHeader
+}
+
+func (w *cacheWriter) Flush() {
+	if !w.streaming {
+		w.flush()
+		w.streaming = true
+	}
+	w.ResponseWriter.Flush()
+}
+
+//flush 原样输出暂存的响应
+func (w *cacheWriter) flush() {
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.WriteHeaderNow()
+	w.ResponseWriter.Write(w.body.Bytes())
+}
@@ -0,0 +1,251 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-api/application/errcode"
+	"gin-api/pkg/cache"
+	"gin-api/pkg/response"
+	"github.com/gin-gonic/gin"
+)
+
+//newCacheRouter 使用内存缓存并清空之前的响应缓存, 返回的计数器记录接口实际执行的次数
+func newCacheRouter(t *testing.T) (*gin.Engine, *int) {
+	gin.SetMode(gin.TestMode)
+	cache.Init(cache.NewMemory(0, 0))
+	PurgeCache()
+	calls := new(int)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		*calls++
+		c.Next()
+	})
+	return router, calls
+}
+
+//serve 发起请求, header 为成对的请求头名称与值
+func serve(router *gin.Engine, method, target string, header ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+//expectCache 校验 X-Cache 响应头
+func expectCache(t *testing.T, w *httptest.ResponseRecorder, want string) {
+	t.Helper()
+	if got := w.Header().Get("X-Cache"); got != want {
+		t.Fatalf("X-Cache = %q, 期望 %q, 状态码 %d", got, want, w.Code)
+	}
+}
+
+//counter 返回成功响应的接口, 每次执行的响应内容都不同
+func counter() gin.HandlerFunc {
+	n := 0
+	return func(c *gin.Context) {
+		n++
+		c.Header("X-Handler", "counter")
+		response.Json(c, errcode.Success, "", n)
+	}
+}
+
+func TestCacheResponseHit(t *testing.T) {
+	router, calls := newCacheRouter(t)
+	router.GET("/articles", CacheResponse(time.Minute, nil), counter())
+
+	miss := serve(router, http.MethodGet, "/articles")
+	expectCache(t, miss, "MISS")
+	hit := serve(router, http.MethodGet, "/articles")
+	expectCache(t, hit, "HIT")
+
+	if *calls != 2 || hit.Body.String() != miss.Body.String() {
+		t.Fatalf("命中缓存时响应为 %s, 期望 %s", hit.Body.String(), miss.Body.String())
+	}
+	//接口设置的响应头随缓存一起返回
+	if hit.Header().Get("X-Handler") != "counter" || hit.Header().Get("ETag") == "" || hit.Header().Get("Content-Type") == "" {
+		t.Fatalf("命中缓存时的响应头: %v", hit.Header())
+	}
+}
+
+func TestCacheResponseNotModified(t *testing.T) {
+	router, _ := newCacheRouter(t)
+	router.GET("/articles", CacheResponse(time.Minute, nil), counter())
+
+	first := serve(router, http.MethodGet, "/articles")
+	etag, lastModified := first.Header().Get("ETag"), first.Header().Get("Last-Modified")
+
+	for _, header := range [][]string{
+		{"If-None-Match", etag},
+		{"If-None-Match", `"other", W/` + etag},
+		{"If-Modified-Since", lastModified},
+	} {
+		w := serve(router, http.MethodGet, "/articles", header...)
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("%s: %s 返回 %d %q, 期望 304", header[0], header[1], w.Code, w.Body.String())
+		}
+	}
+
+	//If-None-Match 不匹配时忽略 If-Modified-Since
+	w := serve(router, http.MethodGet, "/articles", "If-None-Match", `"other"`, "If-Modified-Since", lastModified)
+	if w.Code != http.StatusOK || w.Body.String() != first.Body.String() {
+		t.Fatalf("ETag 不匹配时返回 %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestCacheResponseNotCacheable(t *testing.T) {
+	router, calls := newCacheRouter(t)
+	router.GET("/fail", CacheResponse(time.Minute, nil), func(c *gin.Context) {
+		response.Json(c, errcode.Fail, "", nil)
+	})
+	router.GET("/cookie", CacheResponse(time.Minute, nil), func(c *gin.Context) {
+		c.SetCookie("session", "1", 0, "/", "", false, true)
+		response.Json(c, errcode.Success, "", nil)
+	})
+	router.GET("/text", CacheResponse(time.Minute, nil), func(c *gin.Context) {
+		c.String(http.StatusOK, "plain")
+	})
+
+	for _, path := range []string{"/fail", "/cookie", "/text"} {
+		*calls = 0
+		for i := 0; i < 2; i++ {
+			w := serve(router, http.MethodGet, path)
+			expectCache(t, w, "MISS")
+			if w.Code != http.StatusOK || w.Body.Len() == 0 {
+				t.Fatalf("%s 未缓存的响应 %d %q", path, w.Code, w.Body.String())
+			}
+		}
+		if *calls != 2 {
+			t.Fatalf("%s 执行了 %d 次, 期望每次请求都执行", path, *calls)
+		}
+	}
+	if w := serve(router, http.MethodGet, "/cookie"); w.Header().Get("Set-Cookie") == "" {
+		t.Fatal("未缓存的响应丢失了 Set-Cookie")
+	}
+}
+
+func TestCacheResponseBypass(t *testing.T) {
+	router, calls := newCacheRouter(t)
+	cached := CacheResponse(time.Minute, nil, WithBypass(func(c *gin.Context) bool {
+		return c.Query("fresh") == "1"
+	}))
+	router.GET("/articles", cached, counter())
+	router.POST("/articles", cached, counter())
+
+	expectCache(t, serve(router, http.MethodGet, "/articles"), "MISS")
+	expectCache(t, serve(router, http.MethodGet, "/articles?fresh=1"), "BYPASS")
+	expectCache(t, serve(router, http.MethodPost, "/articles"), "BYPASS")
+	expectCache(t, serve(router, http.MethodGet, "/articles"), "HIT")
+	if *calls != 4 {
+		t.Fatalf("执行了 %d 次请求", *calls)
+	}
+}
+
+func TestCacheResponseVary(t *testing.T) {
+	router, _ := newCacheRouter(t)
+	router.GET("/list", CacheResponse(time.Minute, nil, WithVaryQuery("page"), WithVaryHeader("Accept-Language")), counter())
+
+	expectCache(t, serve(router, http.MethodGet, "/list?page=1&t=1", "Accept-Language", "zh"), "MISS")
+	//未指定的查询参数不区分缓存
+	w := serve(router, http.MethodGet, "/list?t=2&page=1", "Accept-Language", "zh")
+	expectCache(t, w, "HIT")
+	if w.Header().Get("Vary") != "Accept-Language" {
+		t.Fatalf("Vary = %q", w.Header().Get("Vary"))
+	}
+	expectCache(t, serve(router, http.MethodGet, "/list?page=2", "Accept-Language", "zh"), "MISS")
+	miss := serve(router, http.MethodGet, "/list?page=1", "Accept-Language", "en")
+	expectCache(t, miss, "MISS")
+	if miss.Header().Get("Vary") != "Accept-Language" {
+		t.Fatalf("Vary = %q", miss.Header().Get("Vary"))
+	}
+}
+
+func TestCacheResponseKeyFunc(t *testing.T) {
+	router, _ := newCacheRouter(t)
+	byUser := func(c *gin.Context) string {
+		return c.GetHeader("X-User")
+	}
+	router.GET("/me", CacheResponse(time.Minute, byUser), counter())
+
+	first := serve(router, http.MethodGet, "/me", "X-User", "1")
+	expectCache(t, first, "MISS")
+	if first.Header().Get("Cache-Control") != "private" {
+		t.Fatalf("按用户缓存的响应 Cache-Control = %q", first.Header().Get("Cache-Control"))
+	}
+	hit := serve(router, http.MethodGet, "/me", "X-User", "1")
+	expectCache(t, hit, "HIT")
+	if hit.Header().Get("Cache-Control") != "private" {
+		t.Fatalf("命中缓存时 Cache-Control = %q", hit.Header().Get("Cache-Control"))
+	}
+	expectCache(t, serve(router, http.MethodGet, "/me", "X-User", "2"), "MISS")
+	//keyFunc 返回空字符串时不使用缓存
+	expectCache(t, serve(router, http.MethodGet, "/me"), "BYPASS")
+}
+
+func TestPurgeCache(t *testing.T) {
+	router, _ := newCacheRouter(t)
+	router.GET("/articles", CacheResponse(time.Minute, nil, WithTags("articles")), counter())
+	router.GET("/users", CacheResponse(time.Minute, nil, WithTags("users")), counter())
+
+	for _, path := range []string{"/articles", "/users"} {
+		serve(router, http.MethodGet, path)
+		expectCache(t, serve(router, http.MethodGet, path), "HIT")
+	}
+
+	PurgeCache("articles")
+	expectCache(t, serve(router, http.MethodGet, "/articles"), "MISS")
+	expectCache(t, serve(router, http.MethodGet, "/users"), "HIT")
+
+	//不传标签时清除全部响应缓存
+	PurgeCache()
+	expectCache(t, serve(router, http.MethodGet, "/articles"), "MISS")
+	expectCache(t, serve(router, http.MethodGet, "/users"), "MISS")
+}
+
+func TestCacheResponseStreaming(t *testing.T) {
+	router, calls := newCacheRouter(t)
+	router.GET("/stream", CacheResponse(time.Minute, nil), func(c *gin.Context) {
+		//与 c.Stream 一样每次输出后调用 Flush
+		for i := 1; i <= 3; i++ {
+			c.SSEvent("message", strconv.Itoa(i))
+			c.Writer.Flush()
+		}
+	})
+
+	for i := 0; i < 2; i++ {
+		w := serve(router, http.MethodGet, "/stream")
+		expectCache(t, w, "MISS")
+		if !w.Flushed || !strings.Contains(w.Body.String(), "data:3") {
+			t.Fatalf("流式响应 flushed=%v %q", w.Flushed, w.Body.String())
+		}
+	}
+	if *calls != 2 {
+		t.Fatalf("流式响应不应被缓存, 执行了 %d 次", *calls)
+	}
+}
+
+func TestCacheResponseWrittenStatus(t *testing.T) {
+	router, _ := newCacheRouter(t)
+	var written bool
+	router.GET("/empty", CacheResponse(time.Minute, nil), func(c *gin.Context) {
+		c.Next()
+		written = c.Writer.Written()
+	}, func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+
+	w := serve(router, http.MethodGet, "/empty")
+	if !written {
+		t.Fatal("只设置了状态码的响应 Written() 返回 false")
+	}
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("状态码 %d, 期望 204", w.Code)
+	}
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"gin-api/application/errcode"
+	"gin-api/pkg/config"
+	"gin-api/pkg/limiter"
+	"gin-api/pkg/response"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//Concurrency 限制同时处理的请求数(舱壁隔离), 名额已满时最多排队 timeout, 超时返回 http 503。
+//注册在 engine 或路由组上即为全局/路由组共享的名额
+func Concurrency(max int, queue int, timeout time.Duration) gin.HandlerFunc {
+	bulkhead := limiter.NewBulkhead(max, queue, timeout)
+	return func(c *gin.Context) {
+		concurrencyCheck(c, bulkhead)
+	}
+}
+
+//ConcurrencyRoute 每个接口各自限制同时处理的请求数, 某个接口的下游变慢时不会占满其他接口的名额
+func ConcurrencyRoute(max int, queue int, timeout time.Duration) gin.HandlerFunc {
+	var bulkheads sync.Map
+	return func(c *gin.Context) {
+		bulkhead, ok := bulkheads.Load(c.FullPath())
+		if !ok {
+			bulkhead, _ = bulkheads.LoadOrStore(c.FullPath(), limiter.NewBulkhead(max, queue, timeout))
+		}
+		concurrencyCheck(c, bulkhead.(*limiter.Bulkhead))
+	}
+}
+
+//Shedding 自适应限流, 根据接口延迟自动调整并发上限, 超出上限的请求直接返回 http 503,
+//缺省参数取自配置 limiter.adaptive
+func Shedding(options ...limiter.AdaptiveOption) gin.HandlerFunc {
+	defaults := []limiter.AdaptiveOption{
+		limiter.WithLimits(
+			config.GetInt("limiter.adaptive.initial_limit", 20),
+			config.GetInt("limiter.adaptive.min_limit", 5),
+			config.GetInt("limiter.adaptive.max_limit", 1000),
+		),
+		limiter.WithTimeout(time.Duration(config.GetInt("limiter.adaptive.timeout", 5000)) * time.Millisecond),
+	}
+	adaptive := limiter.NewAdaptiveLimiter(append(defaults, options...)...)
+	return func(c *gin.Context) {
+		concurrencyCheck(c, adaptive)
+	}
+}
+
+//concurrencyCheck 获取并发名额后继续处理请求, 获取失败时以 http 503 终止请求。
+//http 状态码为 5xx 或 panic 的请求视为失败, panic 在释放名额后继续抛出, 交给 Catch 处理
+func concurrencyCheck(c *gin.Context, lim limiter.ConcurrencyIfac) {
+	release, err := lim.Acquire(c.Request.Context())
+	if err != nil {
+		c.Header("Retry-After", "1")
+		response.JsonAbort(c, errcode.Unavailable, "", nil)
+		return
+	}
+	defer func() {
+		//panic 时 Catch 还没有写入 500, 此时的状态码仍是 200
+		if r := recover(); r != nil {
+			release(false)
+			panic(r)
+		}
+		release(c.Writer.Status() < http.StatusInternalServerError)
+	}()
+	c.Next()
+}
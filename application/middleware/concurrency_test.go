@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gin-api/pkg/limiter"
+	"github.com/gin-gonic/gin"
+)
+
+// recordLimiter 记录每次释放名额时请求是否成功
+type recordLimiter struct {
+	released []bool
+}
+
+func (l *recordLimiter) Acquire(ctx context.Context) (limiter.Release, error) {
+	return func(ok bool) {
+		l.released = append(l.released, ok)
+	}, nil
+}
+
+func TestConcurrencyCheckReleasesOnPanic(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	lim := &recordLimiter{}
+
+	router := gin.New()
+	//与 Catch 一样在外层恢复 panic 并返回 500
+	router.Use(func(c *gin.Context) {
+		defer func() {
+			if recover() != nil {
+				c.AbortWithStatus(http.StatusInternalServerError)
+			}
+		}()
+		c.Next()
+	})
+	router.Use(func(c *gin.Context) {
+		concurrencyCheck(c, lim)
+	})
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/fail", func(c *gin.Context) { c.Status(http.StatusBadGateway) })
+	router.GET("/panic", func(c *gin.Context) { panic("boom") })
+
+	for _, path := range []string{"/ok", "/fail", "/panic"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	want := []bool{true, false, false}
+	if len(lim.released) != len(want) {
+		t.Fatalf("释放了 %d 次名额, 期望 %d 次", len(lim.released), len(want))
+	}
+	for i, ok := range want {
+		if lim.released[i] != ok {
+			t.Fatalf("第 %d 个请求释放名额时 ok = %v, 期望 %v", i+1, lim.released[i], ok)
+		}
+	}
+}
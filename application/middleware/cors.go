@@ -1,32 +1,183 @@
 package middleware
 
 import (
+	"gin-api/pkg/config"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 )
 
+//corsPolicy 一条跨域策略, 对应配置 cors.policies.{name}
+type corsPolicy struct {
+	allowAll         bool
+	origins          map[string]bool
+	wildcards        [][2]string //形如 https://*.example.com 的通配符, 拆分为前缀和后缀
+	allowMethods     string
+	allowHeaders     string
+	echoHeaders      bool
+	exposeHeaders    string
+	allowCredentials bool
+	maxAge           string
+}
+
+var (
+	corsPolicies = make(map[string]*corsPolicy)
+	corsMux      sync.Mutex
+)
+
+//Cors 全局跨域中间件, 根据 cors.groups 按路由前缀选择跨域策略并处理预检请求
 func Cors() gin.HandlerFunc {
+	groups := config.GetStringMapString("cors.groups")
+	prefixes := make([]string, 0, len(groups))
+	for prefix := range groups {
+		prefixes = append(prefixes, prefix)
+	}
+	//最长前缀优先
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
 	return func(c *gin.Context) {
-		method := c.Request.Method
-		origin := c.Request.Header.Get("Origin") //请求头部
-		if origin != "" {
-			//接收客户端发送的origin
-			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-			//服务器支持的所有跨域请求的方法
-			c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE,UPDATE")
-			//允许跨域设置可以返回其他子段，可以自定义字段
-			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Length, X-CSRF-Token, Token,session")
-			// 允许浏览器（客户端）可以解析的头部
-			c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers")
-			//允许客户端传递校验信息比如 cookie
-			//c.Header("Access-Control-Allow-Credentials", "true")
+		name := "default"
+		for _, prefix := range prefixes {
+			if matchPrefix(c.Request.URL.Path, prefix) {
+				name = groups[prefix]
+				break
+			}
 		}
+		loadCorsPolicy(name).handle(c)
+	}
+}
+
+//matchPrefix path 是否属于路由前缀 prefix: 与其相同或在其后以 "/" 分隔, 如 "/api" 匹配 "/api/users" 但不匹配 "/apiv2"
+func matchPrefix(path string, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+//CorsWith 使用指定的跨域策略, 用于给路由组单独挂载策略:
+//	admin.Use(middleware.CorsWith("admin"))
+//注意预检请求通常匹配不到路由组, 因此仍需在 cors.groups 中配置对应前缀
+func CorsWith(name string) gin.HandlerFunc {
+	policy := loadCorsPolicy(name)
+	return func(c *gin.Context) {
+		policy.handle(c)
+	}
+}
+
+//loadCorsPolicy 解析并缓存跨域策略
+func loadCorsPolicy(name string) *corsPolicy {
+	corsMux.Lock()
+	defer corsMux.Unlock()
+
+	if p, ok := corsPolicies[name]; ok {
+		return p
+	}
 
-		//options 请求直接返回
-		if method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
+	prefix := "cors.policies." + name + "."
+	p := &corsPolicy{
+		origins:          make(map[string]bool),
+		allowMethods:     strings.Join(corsList(prefix+"allow_methods"), ", "),
+		allowHeaders:     strings.Join(corsList(prefix+"allow_headers"), ", "),
+		exposeHeaders:    strings.Join(corsList(prefix+"expose_headers"), ", "),
+		allowCredentials: config.GetBool(prefix + "allow_credentials"),
+		maxAge:           strconv.Itoa(config.GetInt(prefix + "max_age")),
+	}
+	p.echoHeaders = p.allowHeaders == "*"
+
+	for _, origin := range corsList(prefix + "allow_origins") {
+		origin = strings.ToLower(origin)
+		switch {
+		case origin == "*":
+			p.allowAll = true
+		case strings.Contains(origin, "*"):
+			parts := strings.SplitN(origin, "*", 2)
+			p.wildcards = append(p.wildcards, [2]string{parts[0], parts[1]})
+		default:
+			p.origins[origin] = true
 		}
+	}
 
+	corsPolicies[name] = p
+	return p
+}
+
+//corsList 读取以逗号分隔的配置项
+func corsList(path string) []string {
+	return splitList(config.GetString(path))
+}
+
+//allowOrigin 检查来源是否被允许
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		if len(origin) > len(w[0])+len(w[1]) && strings.HasPrefix(origin, w[0]) && strings.HasSuffix(origin, w[1]) {
+			return true
+		}
+	}
+	return false
+}
+
+//handle 设置跨域响应头, 预检请求直接返回
+func (p *corsPolicy) handle(c *gin.Context) {
+	origin := c.Request.Header.Get("Origin")
+	if origin == "" {
+		c.Next()
+		return
+	}
+
+	preflight := c.Request.Method == http.MethodOptions && c.Request.Header.Get("Access-Control-Request-Method") != ""
+	header := c.Writer.Header()
+	header.Add("Vary", "Origin")
+
+	if !p.allowOrigin(origin) {
+		if preflight {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
 		c.Next()
+		return
+	}
+
+	//携带凭证时浏览器不接受 "*", 必须回显具体的来源
+	if p.allowAll && !p.allowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.allowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		if p.exposeHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+		}
+		c.Next()
+		return
+	}
+
+	header.Add("Vary", "Access-Control-Request-Method")
+	header.Add("Vary", "Access-Control-Request-Headers")
+	header.Set("Access-Control-Allow-Methods", p.allowMethods)
+	if p.echoHeaders {
+		if requested := c.Request.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+	} else if p.allowHeaders != "" {
+		header.Set("Access-Control-Allow-Headers", p.allowHeaders)
+	}
+	if p.maxAge != "0" {
+		header.Set("Access-Control-Max-Age", p.maxAge)
 	}
+	c.AbortWithStatus(http.StatusNoContent)
 }
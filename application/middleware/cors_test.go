@@ -0,0 +1,24 @@
+package middleware
+
+import "testing"
+
+func TestMatchPrefix(t *testing.T) {
+	cases := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{"/api", "/api", true},
+		{"/api/users", "/api", true},
+		{"/apiv2/users", "/api", false},
+		{"/api-docs", "/api", false},
+		{"/api/users", "/api/", true},
+		{"/admin", "/api", false},
+		{"/anything", "/", true},
+	}
+	for _, c := range cases {
+		if got := matchPrefix(c.path, c.prefix); got != c.want {
+			t.Errorf("matchPrefix(%q, %q) = %v, 期望 %v", c.path, c.prefix, got, c.want)
+		}
+	}
+}
@@ -1,19 +1,28 @@
 package middleware
 
 import (
+	"errors"
 	"gin-api/application/errcode"
+	"gin-api/pkg/config"
 	"gin-api/pkg/limiter"
+	"gin-api/pkg/logger"
 	"gin-api/pkg/response"
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"math"
+	"strconv"
 	"strings"
+	"time"
 )
 
 //LimitIp 全局限流器(限制单个用户访问能访问系统几次)
+//format 的写法详见 limiter.ParsePolicy, 格式有误时在注册路由时 panic; 也可以使用 limiter.Named 引用配置中的规则
 func LimitIp(format string, driver ...int) gin.HandlerFunc {
+	limiter.MustParse(format)
+	lim := Limiter(driver...)
 	return func(c *gin.Context) {
 		key := c.ClientIP() + ":" + format
-		if err := Limiter(driver...).Check(key, format); err != nil {
-			response.JsonAbort(c, errcode.TooManyRequests, err.Error(),nil)
+		if !limitCheck(c, lim, key, format) {
 			return
 		}
 		c.Next()
@@ -22,10 +31,11 @@ func LimitIp(format string, driver ...int) gin.HandlerFunc {
 
 //LimitRoute 针对某个接口限流(即所有人限制访问该接口总共几次)
 func LimitRoute(format string, driver ...int) gin.HandlerFunc {
+	limiter.MustParse(format)
+	lim := Limiter(driver...)
 	return func(c *gin.Context) {
 		key    := c.FullPath()
-		if err := Limiter(driver...).Check(key, format); err != nil {
-			response.JsonAbort(c, errcode.TooManyRequests, err.Error(), nil)
+		if !limitCheck(c, lim, key, format) {
 			return
 		}
 		c.Next()
@@ -34,16 +44,94 @@ func LimitRoute(format string, driver ...int) gin.HandlerFunc {
 
 //LimitRouteAndIp 对某个ip访问某接口进行限流(即每人限制访问该接口几次)
 func LimitRouteAndIp(format string, driver ...int) gin.HandlerFunc {
+	limiter.MustParse(format)
+	lim := Limiter(driver...)
 	return func(c *gin.Context) {
 		key    := routeToKeyString(c.FullPath() + c.ClientIP())
-		if err := Limiter(driver...).Check(key, format); err != nil {
-			response.JsonAbort(c, errcode.TooManyRequests, err.Error(), nil)
+		if !limitCheck(c, lim, key, format) {
 			return
 		}
 		c.Next()
 	}
 }
 
+//Limit 按 keyFunc 提取的 key 限流, 如按登录用户限流:
+//	middleware.Limit("100-M", middleware.KeyByClaim("user_id"))
+//keyFunc 返回空字符串(如未登录)时按客户端 ip 限流
+func Limit(format string, keyFunc KeyFunc, driver ...int) gin.HandlerFunc {
+	limiter.MustParse(format)
+	lim := Limiter(driver...)
+	return func(c *gin.Context) {
+		if !limitCheck(c, lim, limitKey(c, keyFunc), format) {
+			return
+		}
+		c.Next()
+	}
+}
+
+//LimitByPlan 按套餐限流, 不同套餐(如免费版、付费版)使用配置 limiter.plans 中各自的规则,
+//planFunc 返回的套餐未配置时使用 free 套餐的规则
+//	middleware.LimitByPlan(middleware.PlanByApiClient(), middleware.KeyByApiKey())
+func LimitByPlan(planFunc PlanFunc, keyFunc KeyFunc, driver ...int) gin.HandlerFunc {
+	plans := make(map[string]string)
+	for plan, format := range config.GetStringMap("limiter.plans") {
+		plans[strings.ToLower(plan)] = cast.ToString(format)
+		limiter.MustParse(plans[strings.ToLower(plan)])
+	}
+	if _, ok := plans["free"]; !ok {
+		panic(errors.New("未配置限流套餐: limiter.plans.free"))
+	}
+	lim := Limiter(driver...)
+
+	return func(c *gin.Context) {
+		format, ok := plans[strings.ToLower(planFunc(c))]
+		if !ok {
+			format = plans["free"]
+		}
+		if !limitCheck(c, lim, limitKey(c, keyFunc), format) {
+			return
+		}
+		c.Next()
+	}
+}
+
+//limitKey 使用 keyFunc 提取 key, 无法识别时退回客户端 ip
+func limitKey(c *gin.Context, keyFunc KeyFunc) string {
+	if key := keyFunc(c); key != "" {
+		return key
+	}
+	return "ip:" + c.ClientIP()
+}
+
+//limitCheck 执行限流检测并输出 X-RateLimit-* 响应头, 超额时以 http 429 终止请求并返回 false。
+//限流器本身出错(如 redis 不可用)时记录日志并放行, 避免限流组件故障导致整个接口不可用。
+func limitCheck(c *gin.Context, lim limiter.LimiterIfac, key string, format string) bool {
+	result, err := lim.Check(key, format)
+	if err != nil {
+		logger.LogIf("limiter", err)
+		return true
+	}
+
+	header := c.Writer.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("X-RateLimit-Reset", ceilSeconds(result.Reset))
+
+	if !result.Allowed {
+		header.Set("Retry-After", ceilSeconds(result.RetryAfter))
+		response.JsonAbort(c, errcode.TooManyRequests, "", gin.H{
+			"retry_after": math.Ceil(result.RetryAfter.Seconds()),
+		})
+		return false
+	}
+	return true
+}
+
+//ceilSeconds 将时长向上取整为秒
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
 // routeToKeyString 辅助方法，将 URL 中的 / 格式为 -
 func routeToKeyString(routeName string) string {
 	routeName = strings.TrimLeft(routeName, "/")
@@ -52,20 +140,16 @@ func routeToKeyString(routeName string) string {
 	return routeName
 }
 
-//Limiter 根据driver类型来实例化对应的限流器,
-//同时对分布式限流器进行健康检查，一旦有问题则切换到单机版。
+//Limiter 根据driver类型来实例化对应的限流器, 每次调用都会创建独立的单机版限流器,
+//因此各个中间件之间的状态互不影响。分布式限流器由后台的健康检查决定是否降级为单机版，
+//降级期间是否按实例数均分配额由配置 limiter.failover.scale_local 决定。
 // driver=1 单机版限流
 // driver=2 分布式限流
 func Limiter(driver ...int) limiter.LimiterIfac  {
+	local := limiter.NewAloneBucket()
 	if len(driver) == 0 || driver[0] == 1 {
-		return  limiter.NewAloneBucket()
+		return local
 	}
-
-	distribute := limiter.NewDistributeBucket()
-	if distribute.HealthCheck() == false {
-		return  limiter.NewAloneBucket()
-	}
-
-	return distribute
+	return limiter.NewFailover(limiter.NewDistributeBucket(), local, limiter.DefaultMonitor(),
+		config.GetBool("limiter.failover.scale_local"))
 }
-
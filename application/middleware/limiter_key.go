@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	appcache "gin-api/application/cache"
+	"gin-api/application/http/model"
+	"gin-api/pkg/cache"
+	"gin-api/pkg/jwt"
+	"gin-api/pkg/logger"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"strings"
+	"time"
+)
+
+//KeyFunc 从请求中提取限流的 key, 返回空字符串表示无法识别(如未登录), 此时按客户端 ip 限流
+type KeyFunc func(c *gin.Context) string
+
+//PlanFunc 返回请求所属的套餐名, 套餐对应的限流规则在配置 limiter.plans 中定义
+type PlanFunc func(c *gin.Context) string
+
+//KeyByIp 按客户端 ip 提取 key
+func KeyByIp() KeyFunc {
+	return func(c *gin.Context) string {
+		return "ip:" + c.ClientIP()
+	}
+}
+
+//KeyByRoute 按路由提取 key, 同一路由的所有请求共享配额
+func KeyByRoute() KeyFunc {
+	return func(c *gin.Context) string {
+		return "route:" + routeToKeyString(c.FullPath())
+	}
+}
+
+//KeyBySubject 按 jwt 的 sub 提取 key, 需在 JwtAuth 之后使用
+func KeyBySubject() KeyFunc {
+	return func(c *gin.Context) string {
+		payload, ok := jwtPayload(c)
+		if !ok || payload.Sub == "" {
+			return ""
+		}
+		return "sub:" + payload.Sub
+	}
+}
+
+//KeyByClaim 按 jwt 自定义数据中的某个字段提取 key, 如 KeyByClaim("user_id"), 需在 JwtAuth 之后使用
+func KeyByClaim(name string) KeyFunc {
+	return func(c *gin.Context) string {
+		value := claimValue(c, name)
+		if value == "" {
+			return ""
+		}
+		return "claim:" + name + ":" + value
+	}
+}
+
+//KeyByApiKey 按 Sign 中间件校验过的 app_key 提取 key, 需在 Sign 之后使用;
+//不读取未经校验的请求头, 否则客户端每次换一个 X-App-Key 就能得到新的配额, 没有 app_key 时按客户端 ip 限流
+func KeyByApiKey() KeyFunc {
+	return func(c *gin.Context) string {
+		appKey := c.GetString("app_key")
+		if appKey == "" {
+			return ""
+		}
+		return "app:" + appKey
+	}
+}
+
+//KeyByParam 按路由参数提取 key, 如 "/shops/:shop_id" 中的 shop_id
+func KeyByParam(name string) KeyFunc {
+	return func(c *gin.Context) string {
+		value := c.Param(name)
+		if value == "" {
+			return ""
+		}
+		return "param:" + name + ":" + value
+	}
+}
+
+//KeyByComposite 组合多个 KeyFunc, 如 KeyByComposite(KeyByRoute(), KeyByClaim("user_id")) 即每个用户在每个接口上的配额,
+//任意一个返回空字符串时整体返回空字符串
+func KeyByComposite(keyFuncs ...KeyFunc) KeyFunc {
+	return func(c *gin.Context) string {
+		keys := make([]string, 0, len(keyFuncs))
+		for _, keyFunc := range keyFuncs {
+			key := keyFunc(c)
+			if key == "" {
+				return ""
+			}
+			keys = append(keys, key)
+		}
+		return strings.Join(keys, "|")
+	}
+}
+
+//PlanByClaim 从 jwt 自定义数据中的某个字段读取套餐名, 如 PlanByClaim("plan")
+func PlanByClaim(name string) PlanFunc {
+	return func(c *gin.Context) string {
+		return claimValue(c, name)
+	}
+}
+
+//PlanByApiClient 从数据表 api_client 中读取调用方的套餐, 结果缓存一分钟, 需在 Sign 之后使用
+func PlanByApiClient() PlanFunc {
+	return func(c *gin.Context) string {
+		appKey := c.GetString("app_key")
+		if appKey == "" {
+			return ""
+		}
+
+		key := appcache.FormatKey(appcache.ApiClientPlan, appKey)
+		var plan string
+		if cache.Has(key) {
+			cache.GetObject(key, &plan)
+			return plan
+		}
+
+		plan, err := model.ApiClientPlan(appKey)
+		if err != nil {
+			logger.LogIf("limiter", err)
+			return ""
+		}
+		cache.Set(key, plan, time.Minute)
+		return plan
+	}
+}
+
+//jwtPayload 获取 JwtAuth 中间件解析出的 PayLoad
+func jwtPayload(c *gin.Context) (*jwt.PayLoad, bool) {
+	value, ok := c.Get("jwt_payload")
+	if !ok {
+		return nil, false
+	}
+	payload, ok := value.(*jwt.PayLoad)
+	return payload, ok
+}
+
+//claimValue 读取 jwt 自定义数据中的某个字段, 不存在时返回空字符串
+func claimValue(c *gin.Context, name string) string {
+	claims, ok := c.Get("custom_claims")
+	if !ok {
+		return ""
+	}
+	m, ok := claims.(map[string]interface{})
+	if !ok || m[name] == nil {
+		return ""
+	}
+	return cast.ToString(m[name])
+}
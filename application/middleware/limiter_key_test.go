@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestKeyByApiKeyIgnoresUnverifiedHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keyFunc := KeyByApiKey()
+	newContext := func() *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		c.Request.RemoteAddr = "10.0.0.1:1234"
+		c.Request.Header.Set("X-App-Key", "forged")
+		return c
+	}
+
+	//没有经过 Sign 校验时不使用请求头, 按客户端 ip 限流
+	c := newContext()
+	if key := limitKey(c, keyFunc); key != "ip:10.0.0.1" {
+		t.Fatalf("未校验的请求 key = %q, 期望按 ip 限流", key)
+	}
+
+	c = newContext()
+	c.Set("app_key", "verified")
+	if key := limitKey(c, keyFunc); key != "app:verified" {
+		t.Fatalf("校验过的请求 key = %q", key)
+	}
+}
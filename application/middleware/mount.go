@@ -1,15 +1,48 @@
 package middleware
 
 import (
+	"gin-api/application/errcode"
 	"gin-api/pkg/app"
+	"gin-api/pkg/config"
+	"gin-api/pkg/response"
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"strings"
 )
 
-//MountApp 上下文信息到App上
+//MountApp 上下文信息到App上, 同时限制请求体的大小: 路由在 security.body_limits 中单独配置时以其为准,
+//否则使用全局的 security.max_body_size; 请求体在此读取, 路由的限制只能在这里生效, 不能由路由中间件调整
 func MountApp() gin.HandlerFunc {
+	routeLimits := make(map[string]int64)
+	for path, size := range config.GetStringMap("security.body_limits") {
+		routeLimits[path] = cast.ToInt64(size)
+	}
+	return mountApp(config.GetInt64("security.max_body_size"), routeLimits)
+}
+
+//mountApp routeLimits 以路由的完整路径(c.FullPath())为 key, 不区分大小写, 值不大于 0 时不限制
+func mountApp(maxBodySize int64, routeLimits map[string]int64) gin.HandlerFunc {
+	limits := make(map[string]int64, len(routeLimits))
+	for path, size := range routeLimits {
+		limits[strings.ToLower(path)] = size
+	}
 	return func(c *gin.Context) {
-		app.MountApp(c)
+		limit := maxBodySize
+		if size, ok := limits[strings.ToLower(c.FullPath())]; ok {
+			limit = size
+		}
+		if limit > 0 && c.Request.ContentLength > limit {
+			response.JsonAbort(c, errcode.TooLarge, "", nil)
+			return
+		}
+		if err := app.MountApp(c, limit); err != nil {
+			code := errcode.Fail
+			if strings.Contains(err.Error(), "request body too large") {
+				code = errcode.TooLarge
+			}
+			response.JsonAbort(c, code, "", nil)
+			return
+		}
 		c.Next()
 	}
 }
-
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gin-api/pkg/app"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMountAppRouteBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	app.New()
+	router := gin.New()
+	router.Use(mountApp(10, map[string]int64{"/Upload/:id": 100}))
+	echo := func(c *gin.Context) {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		c.String(http.StatusOK, "%d", len(body))
+	}
+	router.POST("/upload/:id", echo)
+	router.POST("/other", echo)
+
+	for _, tc := range []struct {
+		path   string
+		size   int
+		chunk  bool
+		status int
+	}{
+		//路由的限制大于全局限制
+		{"/upload/1", 50, false, http.StatusOK},
+		{"/upload/1", 50, true, http.StatusOK},
+		{"/upload/1", 101, false, http.StatusRequestEntityTooLarge},
+		{"/upload/1", 101, true, http.StatusRequestEntityTooLarge},
+		//其他路由仍使用全局限制
+		{"/other", 10, false, http.StatusOK},
+		{"/other", 11, false, http.StatusRequestEntityTooLarge},
+		{"/other", 11, true, http.StatusRequestEntityTooLarge},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader(strings.Repeat("a", tc.size)))
+		if tc.chunk {
+			//未知长度的请求体只能在读取时限制
+			req.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tc.status {
+			t.Fatalf("%s 请求体 %d 字节(chunked=%v): 状态码 %d, 期望 %d", tc.path, tc.size, tc.chunk, w.Code, tc.status)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"gin-api/application/errcode"
+	"gin-api/pkg/config"
+	"gin-api/pkg/logger"
+	"gin-api/pkg/net"
+	"gin-api/pkg/response"
+	"github.com/gin-gonic/gin"
+	stdnet "net"
+	"strconv"
+	"strings"
+)
+
+//RealIp 基于受信任代理(security.trusted_proxies)解析真实客户端 IP 并改写 Request.RemoteAddr,
+//之后 c.ClientIP() 与 net.RemoteIp() 均返回真实 IP, 需作为第一个全局中间件注册,
+//同时需关闭 gin 自身对 X-Forwarded-For 的解析(router.ForwardedByClientIP = false)。
+func RealIp() gin.HandlerFunc {
+	if err := net.SetTrustedProxies(splitList(config.GetString("security.trusted_proxies"))); err != nil {
+		panic(err)
+	}
+	return func(c *gin.Context) {
+		host, port, err := stdnet.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil {
+			host, port = c.Request.RemoteAddr, "0"
+		}
+		//记录直连地址是否为受信任代理, 供后续判断 X-Forwarded-Proto 等请求头是否可信
+		c.Set("trusted_proxy", net.IsTrustedProxy(host))
+		ip := net.RemoteIp(c.Request)
+		c.Request.RemoteAddr = stdnet.JoinHostPort(ip, port)
+		c.Next()
+	}
+}
+
+//SecureHeaders 输出 HSTS/CSP/X-Frame-Options 等安全相关的响应头
+func SecureHeaders() gin.HandlerFunc {
+	prefix := "security.headers."
+	hsts := ""
+	if maxAge := config.GetInt(prefix + "hsts_max_age"); maxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(maxAge)
+		if config.GetBool(prefix + "hsts_include_subdomains") {
+			hsts += "; includeSubDomains"
+		}
+	}
+	headers := map[string]string{
+		"Content-Security-Policy": config.GetString(prefix + "content_security_policy"),
+		"X-Frame-Options":         config.GetString(prefix + "frame_options"),
+		"X-Content-Type-Options":  config.GetString(prefix + "content_type_options"),
+		"Referrer-Policy":         config.GetString(prefix + "referrer_policy"),
+	}
+
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		for name, value := range headers {
+			if value != "" {
+				h.Set(name, value)
+			}
+		}
+		//HSTS 只能通过 https 下发, 否则会被浏览器忽略
+		if hsts != "" && isHttps(c) {
+			h.Set("Strict-Transport-Security", hsts)
+		}
+		c.Next()
+	}
+}
+
+//IpFilter 基于 security.ip_filter.{name} 的黑白名单过滤请求, deny 优先于 allow
+func IpFilter(name string) gin.HandlerFunc {
+	prefix := "security.ip_filter." + name + "."
+	allow, err := net.ParseCIDRs(splitList(config.GetString(prefix + "allow")))
+	if err != nil {
+		panic(err)
+	}
+	deny, err := net.ParseCIDRs(splitList(config.GetString(prefix + "deny")))
+	if err != nil {
+		panic(err)
+	}
+
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		if net.ContainsIp(deny, ip) || (len(allow) > 0 && !net.ContainsIp(allow, ip)) {
+			logger.Log("IpFilter", name+" 拒绝访问: "+ip)
+			response.JsonAbort(c, errcode.Forbidden, "", nil)
+			return
+		}
+		c.Next()
+	}
+}
+
+//ClientCert 要求请求携带通过 CA 校验的客户端证书(双向 TLS), 用于保护内部接口,
+//commonNames 不为空时还要求证书的 CN 在其中
+func ClientCert(commonNames ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tlsState := c.Request.TLS
+		if tlsState == nil || len(tlsState.VerifiedChains) == 0 || len(tlsState.VerifiedChains[0]) == 0 {
+			response.JsonAbort(c, errcode.Forbidden, "缺少有效的客户端证书", nil)
+			return
+		}
+
+		cn := tlsState.VerifiedChains[0][0].Subject.CommonName
+		if len(commonNames) > 0 {
+			allowed := false
+			for _, name := range commonNames {
+				if name == cn {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				response.JsonAbort(c, errcode.Forbidden, "客户端证书不在允许的范围内", nil)
+				return
+			}
+		}
+		c.Set("client_cert_cn", cn)
+		c.Next()
+	}
+}
+
+//isHttps 判断当前请求是否为 https, 只有受信任代理转发的 X-Forwarded-Proto 才会被采纳
+func isHttps(c *gin.Context) bool {
+	if c.Request.TLS != nil {
+		return true
+	}
+	return c.GetBool("trusted_proxy") && strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https")
+}
+
+//splitList 拆分以逗号分隔的配置项
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -2,83 +2,50 @@
 package middleware
 
 import (
-	"errors"
-	"fmt"
 	"gin-api/application/errcode"
-	"gin-api/pkg/hash"
-	"gin-api/pkg/request"
+	"gin-api/application/http/model"
+	"gin-api/pkg/config"
+	"gin-api/pkg/redis"
 	"gin-api/pkg/response"
+	"gin-api/pkg/signature"
 	"github.com/gin-gonic/gin"
-	"sort"
+	"github.com/spf13/cast"
 	"strings"
+	"time"
 )
 
-
-const APP_SECRET = "aPL3UW45zRcAA5shjNdFbBg44o0WRB"
-
+//Sign 校验客户端请求签名, 签名规则见 signature.CanonicalString,
+//客户端可直接使用 net.SignRequest 对请求签名。
 func Sign() gin.HandlerFunc {
+	verifier := &signature.Verifier{
+		Clients: signClientStore(),
+		Nonces:  signature.NewRedisNonceStore(redis.DefaultClient(), config.GetString("app.name")+":sign:nonce:"),
+		Window:  time.Duration(config.GetInt("sign.window", 300)) * time.Second,
+	}
+
 	return func(c *gin.Context) {
-		err := signCheck(c)
+		appKey, err := verifier.Verify(c.Request)
 		if err != nil {
-			response.JsonAbort(c, errcode.Sign, err.Error(),nil)
+			response.JsonAbort(c, errcode.Sign, err.Error(), nil)
+			return
 		}
+		c.Set("app_key", appKey)
 		c.Next()
 	}
 }
 
-func signCheck(c *gin.Context) error {
-    //从请求头中获取token和sign
-	token := c.Request.Header.Get("token")
-	sign  := c.Request.Header.Get("sign")
-
-	//获取全部参数
-	any, err := request.Input(c, "")
-	if err != nil {
-		return err
-	}
-
-	allParams, ok := any.(map[string]interface{})
-
-	if ok == false {
-		return errors.New("params type not is map")
-	}
-
-	//校验参数
-	if len(allParams) <= 0 {
-		return errors.New("params is empty")
+//signClientStore 根据配置返回客户端凭证仓库
+func signClientStore() signature.ClientStore {
+	if config.GetString("sign.store") == "database" {
+		return signature.StoreFunc(model.ApiClientSecrets)
 	}
-	if len(sign) <= 0 {
-		return errors.New("[sign] 缺失")
-	}
-
-	//对key进行 ascii 排序
-	if len(token) > 0 {
-		allParams["token"] = token
-	}
-	keys := []string{}
-	for key := range allParams {
-		//排除要签名的字段
-		if key == "sign" {
-			continue
-		}
-
-		keys = append(keys, key)
-	}
-	sort.Strings(keys)
 
-	//使用 & 拼接字段
-	str := ""
-	for _, key := range keys {
-		//非空参数才参与签名
-		if val, _ := allParams[key]; val != nil {
-			str += fmt.Sprintf("&%s=%v", key, allParams[key])
-		}
+	//viper 会把配置的 key 转为小写, 因此 app_key 统一按小写匹配
+	store := signature.MapStore{}
+	for appKey, secrets := range config.GetStringMap("sign.clients") {
+		store[strings.ToLower(appKey)] = cast.ToStringSlice(secrets)
 	}
-	str = strings.Trim(str, "&") + "&" + APP_SECRET
-
-	if sign != strings.ToUpper(hash.HashBySha1(str)) {
-		return errors.New("[sign] 失败")
-	}
-
-	return nil
+	return signature.StoreFunc(func(appKey string) ([]string, error) {
+		return store.Secrets(strings.ToLower(appKey))
+	})
 }
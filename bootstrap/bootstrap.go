@@ -4,6 +4,7 @@ package bootstrap
 func Setup(){
 	setupDB()
 	setupCache()
+	setupOAuth()
 }
 
 
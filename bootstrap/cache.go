@@ -1,14 +1,46 @@
 package bootstrap
 
-import "fmt"
+import "path/filepath"
+import "time"
+import "gin-api/pkg/app"
 import "gin-api/pkg/config"
 import "gin-api/pkg/cache"
+import "gin-api/pkg/redis"
 
+//setupCache 根据 cache.driver 初始化缓存驱动
 func setupCache()  {
-	address  := fmt.Sprintf("%v:%v", config.GetString("redis.host"), config.GetString("redis.port"))
-	username := config.GetString("redis.username")
-	password := config.GetString("redis.password")
-	dbIndex  := config.GetInt("redis.database")
-	driver   := cache.NewRedis(address, username, password, dbIndex)
-	cache.Init(driver)
-}
\ No newline at end of file
+	switch config.GetString("cache.driver") {
+	case "memory":
+		cache.Init(newMemoryCache())
+	case "file":
+		dir := config.GetString("cache.file.path")
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(app.GetRootPath(), dir)
+		}
+		cache.Init(cache.NewFile(dir))
+	case "tiered":
+		l1TTL   := time.Duration(config.GetInt("cache.tiered.l1_ttl")) * time.Second
+		channel := config.GetString("app.name") + ":" + config.GetString("cache.tiered.channel")
+		cache.Init(cache.NewTiered(newMemoryCache(), newRedisCache(), l1TTL, channel))
+	default:
+		cache.Init(newRedisCache())
+	}
+
+	serializer, err := cache.NewSerializer(config.GetString("cache.serializer"), config.GetInt("cache.compress_threshold"))
+	if err != nil {
+		panic(err)
+	}
+	cache.UseSerializer(serializer)
+}
+
+//newRedisCache 基于 redis 的命名连接 cache 实例化 RedisDriver
+func newRedisCache() *cache.RedisDriver {
+	return cache.NewRedisWithClient(redis.Connection("cache"))
+}
+
+//newMemoryCache 基于 cache.memory 配置实例化 MemoryDriver
+func newMemoryCache() *cache.MemoryDriver {
+	maxItems := config.GetInt("cache.memory.max_items")
+	maxBytes := config.GetInt64("cache.memory.max_size") * 1024 * 1024
+	return cache.NewMemory(maxItems, maxBytes)
+}
@@ -0,0 +1,30 @@
+package bootstrap
+
+import (
+	"gin-api/pkg/config"
+	"gin-api/pkg/oauth"
+	"strings"
+)
+
+//setupOAuth 基于配置注册第三方登录提供方
+func setupOAuth() {
+	for name := range config.GetStringMap("oauth.providers") {
+		prefix := "oauth.providers." + name + "."
+		if !config.GetBool(prefix + "enable") {
+			continue
+		}
+		oauth.Register(oauth.NewProvider(oauth.Config{
+			Name:         name,
+			ClientID:     config.GetString(prefix + "client_id"),
+			ClientSecret: config.GetString(prefix + "client_secret"),
+			RedirectURL:  config.GetString(prefix + "redirect_url"),
+			Scopes:       strings.Fields(config.GetString(prefix + "scopes")),
+			Issuer:       config.GetString(prefix + "issuer"),
+			AuthURL:      config.GetString(prefix + "auth_url"),
+			TokenURL:     config.GetString(prefix + "token_url"),
+			UserInfoURL:  config.GetString(prefix + "userinfo_url"),
+			JwksURL:      config.GetString(prefix + "jwks_url"),
+			UsePKCE:      config.GetBool(prefix + "pkce"),
+		}))
+	}
+}
@@ -3,26 +3,44 @@ package bootstrap
 import (
 	"gin-api/application/middleware"
 	"gin-api/pkg/app"
+	"gin-api/pkg/config"
 	"gin-api/route"
 	"github.com/gin-gonic/gin"
 	"html/template"
+	"time"
 )
 
 func SetupRoute(router *gin.Engine) {
+	//客户端 IP 统一由 middleware.RealIp 基于受信任代理解析, 不使用 gin 默认信任所有代理的行为
+	router.ForwardedByClientIP = false
 	registerMiddleware(router)
 	registerRouter(router)
 }
 
 //registerMiddleware 注册中间件
 func registerMiddleware(router *gin.Engine) {
+	router.Use(middleware.RealIp())
 	router.Use(gin.Logger())
 	router.Use(middleware.MountApp())
 	router.Use(middleware.Catch())
+	registerConcurrency(router)
 	router.Use(middleware.Cors())
+	router.Use(middleware.SecureHeaders())
 	router.Use(middleware.AccessLog())
 	router.Use(middleware.Translations())
 }
 
+//registerConcurrency 按配置注册全局的并发限制与自适应限流, 需在 Catch 之后才能感知到异常请求
+func registerConcurrency(router *gin.Engine) {
+	if max := config.GetInt("limiter.concurrency.max"); max > 0 {
+		timeout := time.Duration(config.GetInt("limiter.concurrency.timeout")) * time.Millisecond
+		router.Use(middleware.Concurrency(max, config.GetInt("limiter.concurrency.queue"), timeout))
+	}
+	if config.GetBool("limiter.adaptive.enable") {
+		router.Use(middleware.Shedding())
+	}
+}
+
 //registerRouter 注册路由
 func registerRouter(router *gin.Engine) {
 	//处理 favicon.ico 导致的两次请求问题
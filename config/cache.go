@@ -1 +1,44 @@
 package config
+
+import "gin-api/pkg/config"
+
+func init() {
+	config.Add("cache", func() map[string]interface{} {
+		return map[string]interface{}{
+
+			// 缓存驱动, 可选：
+			// "redis" —— 所有实例共享, 每次读取都需要访问 redis
+			// "memory" —— 进程内缓存, 实例之间不共享, 适合单机部署或可容忍不一致的数据
+			// "file" —— 文件缓存, 重启后仍然有效
+			// "tiered" —— 进程内缓存(L1) + redis(L2), 通过 redis 发布/订阅使各实例的 L1 失效
+			"driver": config.Env("CACHE_DRIVER", "redis"),
+
+			// cache.Ctx 以及 GetAs、Many 等接口的编码方式, 可选 "json"、"gob"、"msgpack";
+			// cache.Set、cache.GetObject 为兼容已有的缓存始终使用 json
+			"serializer": config.Env("CACHE_SERIALIZER", "json"),
+
+			// 编码后超过该字节数的值使用 gzip 压缩, 0 表示不压缩
+			"compress_threshold": config.Env("CACHE_COMPRESS_THRESHOLD", 0),
+
+			// memory 驱动以及 tiered 驱动的 L1
+			"memory": map[string]interface{}{
+				// 最多缓存的 key 数量, 0 表示不限制
+				"max_items": config.Env("CACHE_MEMORY_MAX_ITEMS", 10000),
+				// 最多占用的内存(key 与 value 的字节数之和), 单位：MB, 0 表示不限制
+				"max_size": config.Env("CACHE_MEMORY_MAX_SIZE", 64),
+			},
+
+			// file 驱动的缓存目录, 相对于项目根目录
+			"file": map[string]interface{}{
+				"path": config.Env("CACHE_FILE_PATH", "runtime/cache"),
+			},
+
+			"tiered": map[string]interface{}{
+				// L1 的最长有效期, 单位：秒, 失效通知丢失时各实例最多读到这么久的旧值
+				"l1_ttl": config.Env("CACHE_L1_TTL", 60),
+				// 失效通知使用的 redis 频道
+				"channel": config.Env("CACHE_CHANNEL", "cache:invalidate"),
+			},
+		}
+	})
+}
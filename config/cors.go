@@ -0,0 +1,56 @@
+package config
+
+import "gin-api/pkg/config"
+
+func init() {
+	config.Add("cors", func() map[string]interface{} {
+		return map[string]interface{}{
+
+			// 路由前缀与跨域策略的对应关系, 按最长前缀匹配("/api" 匹配 "/api/users" 但不匹配 "/apiv2"), 未匹配的路由使用 default 策略
+			"groups": map[string]interface{}{
+				"/api":   "api",
+				"/admin": "admin",
+				"/web":   "web",
+			},
+
+			"policies": map[string]interface{}{
+				"default": map[string]interface{}{
+					// 允许的来源, 支持 "*" 以及 "https://*.example.com" 这样的通配符, 多个以逗号分隔
+					"allow_origins": config.Env("CORS_ALLOW_ORIGINS", "*"),
+					"allow_methods": "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+					// 为 "*" 时原样返回预检请求中的 Access-Control-Request-Headers
+					"allow_headers":  "Authorization, Content-Type, Content-Length, X-CSRF-Token, Token, X-App-Key, X-Timestamp, X-Nonce, X-Signature",
+					"expose_headers": "Content-Length",
+					// 为 true 时不能使用 "*"，会回显请求的 Origin
+					"allow_credentials": config.Env("CORS_ALLOW_CREDENTIALS", false),
+					// 预检结果的缓存时间, 单位：秒
+					"max_age": config.Env("CORS_MAX_AGE", 600),
+				},
+				"api": map[string]interface{}{
+					"allow_origins":     config.Env("CORS_API_ALLOW_ORIGINS", "*"),
+					"allow_methods":     "GET, POST, PUT, PATCH, DELETE, OPTIONS",
+					"allow_headers":     "*",
+					"expose_headers":    "Content-Length",
+					"allow_credentials": false,
+					"max_age":           600,
+				},
+				"admin": map[string]interface{}{
+					"allow_origins":     config.Env("CORS_ADMIN_ALLOW_ORIGINS", ""),
+					"allow_methods":     "GET, POST, PUT, DELETE, OPTIONS",
+					"allow_headers":     "Authorization, Content-Type, X-CSRF-Token",
+					"expose_headers":    "Content-Length",
+					"allow_credentials": true,
+					"max_age":           600,
+				},
+				"web": map[string]interface{}{
+					"allow_origins":     config.Env("CORS_WEB_ALLOW_ORIGINS", ""),
+					"allow_methods":     "GET, POST, OPTIONS",
+					"allow_headers":     "Content-Type, X-CSRF-Token",
+					"expose_headers":    "",
+					"allow_credentials": true,
+					"max_age":           600,
+				},
+			},
+		}
+	})
+}
@@ -0,0 +1,78 @@
+package config
+
+import "gin-api/pkg/config"
+
+func init() {
+	config.Add("limiter", func() map[string]interface{} {
+		return map[string]interface{}{
+
+			// 按名称定义的限流规则, 路由中通过 limiter.Named("api") 引用, 名称不区分大小写且不能包含 "."
+			// 规则写法：
+			// "500-H"              每小时 500 次, 单位支持 S/M/H/D
+			// "100/15m"            每 15 分钟 100 次, 周期支持 ms/s/m/h/d 的组合, 如 "1h30m"
+			// "10-S burst 30"      每秒 10 次, 允许 30 次突发(仅 token 和 gcra 算法)
+			// "10-S,500-H,5000-D"  多档规则同时生效
+			// "fixed:5000-D"       指定限流算法, 可选 token(缺省)、fixed、sliding、sliding_log、gcra
+			"rates": map[string]interface{}{
+				// 所有 /api 接口, 每个 ip 在每个接口上的配额
+				"api": config.Env("LIMIT_API", "500-H"),
+				// token 的获取与刷新
+				"token": config.Env("LIMIT_TOKEN", "2-M"),
+				// 第三方登录
+				"oauth": config.Env("LIMIT_OAUTH", "20-M"),
+				// v1 版本的接口, 所有人共享
+				"v1": config.Env("LIMIT_V1", "2-M"),
+			},
+
+			// 套餐对应的限流规则, 供 middleware.LimitByPlan 使用, 未识别的套餐按 free 处理;
+			// 套餐名可来自 jwt 中的字段(middleware.PlanByClaim)或数据表 api_client 的 plan 字段(middleware.PlanByApiClient)
+			"plans": map[string]interface{}{
+				"free": config.Env("LIMIT_PLAN_FREE", "60-M,1000-D"),
+				"pro":  config.Env("LIMIT_PLAN_PRO", "600-M,100000-D"),
+			},
+
+			// 单机版限流器, 每个限流中间件各自保存状态
+			"local": map[string]interface{}{
+				// 最多保存的 key 数量, 超出后淘汰最久未访问的 key, 防止大量伪造的 ip 占满内存
+				"max_keys": config.Env("LIMIT_LOCAL_MAX_KEYS", 100000),
+				// 分片数量, 分片越多锁竞争越小
+				"shards": config.Env("LIMIT_LOCAL_SHARDS", 16),
+				// key 的最长闲置时间, 单位：秒, 0 表示直到配额恢复才清理
+				"ttl": config.Env("LIMIT_LOCAL_TTL", 0),
+			},
+
+			// 分布式限流的降级: 后台定期检查 redis, 连续失败 fail_threshold 次后降级为单机版,
+			// 之后连续成功 recover_threshold 次才切换回分布式限流
+			"failover": map[string]interface{}{
+				// 检查间隔与单次检查的超时时间, 单位：毫秒
+				"interval": config.Env("LIMIT_FAILOVER_INTERVAL", 1000),
+				"timeout":  config.Env("LIMIT_FAILOVER_TIMEOUT", 500),
+
+				"fail_threshold":    config.Env("LIMIT_FAILOVER_FAIL", 3),
+				"recover_threshold": config.Env("LIMIT_FAILOVER_RECOVER", 5),
+
+				// 降级期间是否把配额按存活的实例数均分, 使所有实例加起来的配额与分布式限流时接近
+				"scale_local": config.Env("LIMIT_FAILOVER_SCALE", true),
+			},
+
+			// 全局并发限制(舱壁隔离), 同时处理的请求数超过 max 时排队等待, max 为 0 则不启用
+			"concurrency": map[string]interface{}{
+				"max": config.Env("LIMIT_CONCURRENCY", 0),
+				// 最多排队的请求数
+				"queue": config.Env("LIMIT_CONCURRENCY_QUEUE", 100),
+				// 排队的超时时间, 单位：毫秒
+				"timeout": config.Env("LIMIT_CONCURRENCY_TIMEOUT", 1000),
+			},
+
+			// 自适应限流, 根据接口延迟动态调整全局的并发上限, 系统过载时直接拒绝超出的请求
+			"adaptive": map[string]interface{}{
+				"enable":        config.Env("LIMIT_ADAPTIVE", false),
+				"initial_limit": config.Env("LIMIT_ADAPTIVE_INITIAL", 20),
+				"min_limit":     config.Env("LIMIT_ADAPTIVE_MIN", 5),
+				"max_limit":     config.Env("LIMIT_ADAPTIVE_MAX", 1000),
+				// 处理时间超过该值的请求视为失败, 单位：毫秒
+				"timeout": config.Env("LIMIT_ADAPTIVE_TIMEOUT", 5000),
+			},
+		}
+	})
+}
@@ -0,0 +1,36 @@
+package config
+
+import "gin-api/pkg/config"
+
+func init() {
+	config.Add("oauth", func() map[string]interface{} {
+		return map[string]interface{}{
+
+			// 登录成功后签发的 jwt 有效期, 单位：秒
+			"token_ttl": config.Env("OAUTH_TOKEN_TTL", 3600),
+
+			// state/code_verifier 的有效期, 单位：秒
+			"state_ttl": config.Env("OAUTH_STATE_TTL", 600),
+
+			// 第三方登录提供方, key 为提供方名称, 对应路由 /api/oauth/{name}/redirect
+			// 配置了 issuer 的提供方会通过 OIDC discovery 自动获取各个地址并校验 id_token
+			"providers": map[string]interface{}{
+				"sso": map[string]interface{}{
+					"enable":        config.Env("OAUTH_SSO_ENABLE", false),
+					"issuer":        config.Env("OAUTH_SSO_ISSUER", ""),
+					"client_id":     config.Env("OAUTH_SSO_CLIENT_ID", ""),
+					"client_secret": config.Env("OAUTH_SSO_CLIENT_SECRET", ""),
+					"redirect_url":  config.Env("OAUTH_SSO_REDIRECT_URL", ""),
+					"scopes":        config.Env("OAUTH_SSO_SCOPES", "openid profile email"),
+					"pkce":          config.Env("OAUTH_SSO_PKCE", true),
+
+					// 非 OIDC 提供方需要手动配置以下地址
+					"auth_url":     config.Env("OAUTH_SSO_AUTH_URL", ""),
+					"token_url":    config.Env("OAUTH_SSO_TOKEN_URL", ""),
+					"userinfo_url": config.Env("OAUTH_SSO_USERINFO_URL", ""),
+					"jwks_url":     config.Env("OAUTH_SSO_JWKS_URL", ""),
+				},
+			},
+		}
+	})
+}
@@ -0,0 +1,41 @@
+package config
+
+import "gin-api/pkg/config"
+
+func init() {
+	config.Add("security", func() map[string]interface{} {
+		return map[string]interface{}{
+
+			// 受信任的代理(IP 或 CIDR，多个以逗号分隔)，只有直连地址属于受信任代理时才会解析 X-Forwarded-For
+			"trusted_proxies": config.Env("TRUSTED_PROXIES", "127.0.0.1, ::1"),
+
+			// 请求体的最大尺寸，单位：字节，0 表示不限制
+			"max_body_size": config.Env("MAX_BODY_SIZE", 8<<20),
+
+			// 单个路由的请求体最大尺寸，覆盖 max_body_size，可以比它更大或更小，
+			// key 为注册路由时的完整路径(含路由组前缀与参数，如 "/api/v1/users/:id/avatar")，不区分大小写
+			"body_limits": map[string]interface{}{
+				// "/api/v1/upload": 64 << 20,
+			},
+
+			// 安全相关的响应头，值为空则不输出
+			"headers": map[string]interface{}{
+				// HSTS 仅在 https 请求中输出，单位：秒
+				"hsts_max_age":            config.Env("HSTS_MAX_AGE", 31536000),
+				"hsts_include_subdomains": true,
+				"content_security_policy": config.Env("CONTENT_SECURITY_POLICY", "default-src 'self'"),
+				"frame_options":           "DENY",
+				"content_type_options":    "nosniff",
+				"referrer_policy":         "strict-origin-when-cross-origin",
+			},
+
+			// IP 黑白名单(IP 或 CIDR，多个以逗号分隔)，deny 优先于 allow，allow 为空表示不限制
+			"ip_filter": map[string]interface{}{
+				"admin": map[string]interface{}{
+					"allow": config.Env("ADMIN_ALLOW_IPS", ""),
+					"deny":  config.Env("ADMIN_DENY_IPS", ""),
+				},
+			},
+		}
+	})
+}
@@ -0,0 +1,30 @@
+package config
+
+import "gin-api/pkg/config"
+
+func init() {
+	config.Add("server", func() map[string]interface{} {
+		return map[string]interface{}{
+
+			// TLS 证书和私钥路径，均不为空时以 https 方式启动并启用 HTTP/2
+			"tls_cert": config.Env("TLS_CERT", ""),
+			"tls_key":  config.Env("TLS_KEY", ""),
+
+			// 客户端 CA 路径，不为空时启用双向 TLS
+			"client_ca": config.Env("TLS_CLIENT_CA", ""),
+			// 客户端证书校验方式，可选：
+			// "verify_if_given" —— 客户端提供了证书才校验，内部接口再通过 middleware.ClientCert 强制要求证书
+			// "require" —— 所有请求都必须提供有效的客户端证书
+			"client_auth": config.Env("TLS_CLIENT_AUTH", "verify_if_given"),
+
+			// 启用 TLS 时额外监听的 http 端口，该端口的请求会跳转到 https，为空则不监听
+			"redirect_port": config.Env("HTTP_REDIRECT_PORT", ""),
+
+			// 未启用 TLS 时是否支持明文 HTTP/2(h2c)
+			"h2c": config.Env("HTTP_H2C", false),
+
+			// 检查证书文件变更的间隔，单位：秒
+			"reload_interval": config.Env("TLS_RELOAD_INTERVAL", 10),
+		}
+	})
+}
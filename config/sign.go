@@ -0,0 +1,24 @@
+package config
+
+import "gin-api/pkg/config"
+
+func init() {
+	config.Add("sign", func() map[string]interface{} {
+		return map[string]interface{}{
+
+			// 允许的客户端与服务端时间误差, 单位：秒
+			"window": config.Env("SIGN_WINDOW", 300),
+
+			// 客户端凭证来源, 可选：
+			// "config" —— 使用下方的 clients 配置
+			// "database" —— 使用数据表 api_client
+			"store": config.Env("SIGN_STORE", "config"),
+
+			// 客户端凭证, key 为 app_key(不区分大小写), value 为当前有效的 secret 列表,
+			// 轮换密钥时把新 secret 放在前面, 待所有客户端切换完成后再删掉旧 secret
+			"clients": map[string]interface{}{
+				// "demo": []string{"new-secret", "old-secret"},
+			},
+		}
+	})
+}
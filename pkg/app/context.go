@@ -5,10 +5,11 @@ import (
 	"gin-api/pkg/config"
 	"github.com/gin-gonic/gin"
 	"io/ioutil"
+	"net/http"
 )
 
-//MountApp 挂载 gin.Context 到 app 上
-func MountApp(c *gin.Context) {
+//MountApp 挂载 gin.Context 到 app 上, 请求体超出 maxBodySize 时返回错误
+func MountApp(c *gin.Context, maxBodySize int64) error {
 	scheme := "http://"
 	if c.Request.TLS != nil {
 		scheme = "https://"
@@ -17,10 +18,27 @@ func MountApp(c *gin.Context) {
 	app.C 		    = c
 	app.host        = scheme   + c.Request.Host
 	app.fullUrl     = app.host + c.Request.RequestURI
+	app.requestBody = nil
+
+	if c.Request.Body == nil {
+		return nil
+	}
+	if maxBodySize > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
+	}
+	//文件上传的请求体可能很大, 不做缓存, 交由后续的处理函数以流的方式读取
+	if c.ContentType() == "multipart/form-data" {
+		return nil
+	}
+
 	//由于 request body 不能读取两次, 为了后续能继续读取 body，因此将body数据回写至 Request.Body
-	body,_          := c.GetRawData()
+	body, err       := c.GetRawData()
+	if err != nil {
+		return err
+	}
 	app.requestBody = body
 	c.Request.Body  = ioutil.NopCloser(bytes.NewBuffer(body))
+	return nil
 }
 
 //GetFullUrl 获取当前请求完整的url
@@ -11,6 +11,8 @@ import (
 
 type Cache struct {
 	Driver CacheInterface
+	//Serializer cache.Ctx 以及 GetAs、Many 等接口使用的编码方式, 缺省为 JSON
+	Serializer Serializer
 }
 
 var once sync.Once
@@ -21,11 +23,18 @@ var cache *Cache
 func Init(driver CacheInterface) {
 	once.Do(func() {
 		cache = &Cache{
-			Driver: driver,
+			Driver:     driver,
+			Serializer: JSONSerializer{},
 		}
 	})
 }
 
+//UseSerializer 设置 cache.Ctx 以及 GetAs、Many 等接口使用的编码方式,
+//Set、Get、GetObject 为兼容已有的缓存始终使用 JSON
+func UseSerializer(s Serializer) {
+	cache.Serializer = s
+}
+
 func Set(key string, obj interface{}, expireTime time.Duration) {
 	b, err := json.Marshal(&obj)
 	logger.LogIf(helpers.CurrentFuncName(), err)
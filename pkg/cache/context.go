@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+//ErrMiss 缓存不存在, 用于和驱动本身的错误(如 redis 不可用)区分开
+var ErrMiss = errors.New("缓存不存在")
+
+// ContextDriver 支持 context 并返回错误的驱动, 内置的 redis、memory、file、tiered 驱动均已实现
+type ContextDriver interface {
+	// GetContext 缓存不存在时返回 ErrMiss
+	GetContext(ctx context.Context, key string) (string, error)
+	SetContext(ctx context.Context, key string, value string, expireTime time.Duration) error
+	HasContext(ctx context.Context, key string) (bool, error)
+	ForgetContext(ctx context.Context, key string) error
+	// FlushContext 只清空当前驱动(如 redis 中 KeyPrefix 开头)的缓存
+	FlushContext(ctx context.Context) error
+
+	// ManyContext 批量读取, 返回的 map 中只包含存在的 key
+	ManyContext(ctx context.Context, keys []string) (map[string]string, error)
+	SetManyContext(ctx context.Context, values map[string]string, expireTime time.Duration) error
+	// AddContext key 不存在时才写入, 返回是否写入
+	AddContext(ctx context.Context, key string, value string, expireTime time.Duration) (bool, error)
+	// PullContext 读取并删除, 缓存不存在时返回 ErrMiss
+	PullContext(ctx context.Context, key string) (string, error)
+}
+
+// ContextCache 返回错误的缓存 API, 调用方可以区分缓存未命中与缓存故障
+type ContextCache struct {
+	ctx        context.Context
+	driver     ContextDriver
+	serializer Serializer
+}
+
+//Ctx 返回绑定了 ctx 的缓存 API, 用法如下:
+//	user := model.User{}
+//	err := cache.Ctx(c.Request.Context()).GetObject("key", &user)
+//	if errors.Is(err, cache.ErrMiss) {
+//		// 未命中
+//	}
+func Ctx(ctx context.Context) *ContextCache {
+	driver, ok := cache.Driver.(ContextDriver)
+	if !ok {
+		driver = legacyDriver{cache.Driver}
+	}
+	return &ContextCache{ctx: ctx, driver: driver, serializer: cache.Serializer}
+}
+
+//Get 读取原始的缓存内容
+func (c *ContextCache) Get(key string) (string, error) {
+	return c.driver.GetContext(c.ctx, key)
+}
+
+//GetObject 读取缓存并解析到 wanted(需传地址), 缓存不存在时返回 ErrMiss
+func (c *ContextCache) GetObject(key string, wanted interface{}) error {
+	value, err := c.driver.GetContext(c.ctx, key)
+	if err != nil {
+		return err
+	}
+	return c.serializer.Unmarshal([]byte(value), wanted)
+}
+
+//GetAs 读取缓存并解析到 wanted(需传地址), 返回缓存是否存在; 只有驱动故障或解析失败时才返回错误
+func (c *ContextCache) GetAs(key string, wanted interface{}) (bool, error) {
+	err := c.GetObject(key, wanted)
+	if err == ErrMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//Set 编码 obj 后写入缓存, expireTime 为 0 时永不过期
+func (c *ContextCache) Set(key string, obj interface{}, expireTime time.Duration) error {
+	b, err := c.serializer.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return c.driver.SetContext(c.ctx, key, string(b), expireTime)
+}
+
+//Has 缓存是否存在
+func (c *ContextCache) Has(key string) (bool, error) {
+	return c.driver.HasContext(c.ctx, key)
+}
+
+//Forget 删除缓存
+func (c *ContextCache) Forget(key string) error {
+	return c.driver.ForgetContext(c.ctx, key)
+}
+
+//Flush 清空当前驱动的缓存
+func (c *ContextCache) Flush() error {
+	return c.driver.FlushContext(c.ctx)
+}
+
+// legacyDriver 将未实现 ContextDriver 的驱动适配为 ContextDriver, 除未命中外不会返回错误
+type legacyDriver struct {
+	CacheInterface
+}
+
+func (l legacyDriver) GetContext(ctx context.Context, key string) (string, error) {
+	if value := l.Get(key); value != "" {
+		return value, nil
+	}
+	return "", ErrMiss
+}
+
+func (l legacyDriver) SetContext(ctx context.Context, key string, value string, expireTime time.Duration) error {
+	l.Set(key, value, expireTime)
+	return nil
+}
+
+func (l legacyDriver) HasContext(ctx context.Context, key string) (bool, error) {
+	return l.Has(key), nil
+}
+
+func (l legacyDriver) ForgetContext(ctx context.Context, key string) error {
+	l.Forget(key)
+	return nil
+}
+
+func (l legacyDriver) FlushContext(ctx context.Context) error {
+	l.Flush()
+	return nil
+}
+
+func (l legacyDriver) ManyContext(ctx context.Context, keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if value := l.Get(key); value != "" {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func (l legacyDriver) SetManyContext(ctx context.Context, values map[string]string, expireTime time.Duration) error {
+	for key, value := range values {
+		l.Set(key, value, expireTime)
+	}
+	return nil
+}
+
+//AddContext 先判断再写入, 不是原子操作
+func (l legacyDriver) AddContext(ctx context.Context, key string, value string, expireTime time.Duration) (bool, error) {
+	if l.Has(key) {
+		return false, nil
+	}
+	l.Set(key, value, expireTime)
+	return true, nil
+}
+
+func (l legacyDriver) PullContext(ctx context.Context, key string) (string, error) {
+	value, _ := l.GetContext(ctx, key)
+	if value == "" {
+		return "", ErrMiss
+	}
+	l.Forget(key)
+	return value, nil
+}
@@ -0,0 +1,238 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileDriver 文件缓存, 实现 cache.CacheInterface。
+// 每个 key 对应一个文件, 文件第一行为过期时间(unix 纳秒, 0 表示永不过期), 之后为缓存的值
+type FileDriver struct {
+	dir string
+	mux sync.Mutex
+}
+
+//NewFile 返回实现了 cache.CacheInterface 的 FileDriver, dir 不存在时自动创建
+func NewFile(dir string) *FileDriver {
+	os.MkdirAll(dir, os.ModePerm)
+	return &FileDriver{dir: dir}
+}
+
+//path 返回 key 对应的文件路径, 按哈希值的前两位分目录, 避免单个目录下文件过多
+func (f *FileDriver) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(f.dir, name[:2], name)
+}
+
+//read 读取未过期的缓存, 过期的文件会被删除
+func (f *FileDriver) read(key string) (string, time.Time, bool) {
+	value, expireAt, err := f.readContext(key)
+	return value, expireAt, err == nil
+}
+
+//readContext 读取未过期的缓存, 缓存不存在或已过期时返回 ErrMiss
+func (f *FileDriver) readContext(key string) (string, time.Time, error) {
+	content, err := ioutil.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return "", time.Time{}, ErrMiss
+	}
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	parts := strings.SplitN(string(content), "\n", 2)
+	if len(parts) != 2 {
+		return "", time.Time{}, ErrMiss
+	}
+	var expireAt time.Time
+	if nano, _ := strconv.ParseInt(parts[0], 10, 64); nano > 0 {
+		expireAt = time.Unix(0, nano)
+		if time.Now().After(expireAt) {
+			os.Remove(f.path(key))
+			return "", time.Time{}, ErrMiss
+		}
+	}
+	return parts[1], expireAt, nil
+}
+
+//write 先写临时文件再重命名, 避免并发读取到写了一半的内容
+func (f *FileDriver) write(key string, value string, expireAt time.Time) error {
+	path := f.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	var nano int64
+	if !expireAt.IsZero() {
+		nano = expireAt.UnixNano()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.WriteString(strconv.FormatInt(nano, 10) + "\n" + value)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (f *FileDriver) Set(key string, value string, expireTime time.Duration) {
+	var expireAt time.Time
+	if expireTime > 0 {
+		expireAt = time.Now().Add(expireTime)
+	}
+	f.write(key, value, expireAt)
+}
+
+func (f *FileDriver) Get(key string) string {
+	value, _, _ := f.read(key)
+	return value
+}
+
+func (f *FileDriver) Has(key string) bool {
+	_, _, ok := f.read(key)
+	return ok
+}
+
+func (f *FileDriver) Forget(key string) {
+	os.Remove(f.path(key))
+}
+
+func (f *FileDriver) Forever(key string, value string) {
+	f.write(key, value, time.Time{})
+}
+
+func (f *FileDriver) Flush() {
+	f.FlushContext(context.Background())
+}
+
+func (f *FileDriver) Increment(parameters ...interface{}) {
+	f.incr(1, parameters...)
+}
+
+func (f *FileDriver) Decrement(parameters ...interface{}) {
+	f.incr(-1, parameters...)
+}
+
+//incr 按 sign 的方向修改数值, 过期时间保持不变; 仅在本进程内加锁, 多进程共用目录时不保证原子性
+func (f *FileDriver) incr(sign int64, parameters ...interface{}) {
+	key, delta, err := incrParameters(parameters...)
+	if err != nil {
+		return
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	value, expireAt, _ := f.read(key)
+	current, _ := strconv.ParseInt(value, 10, 64)
+	f.write(key, strconv.FormatInt(current+sign*delta, 10), expireAt)
+}
+
+func (f *FileDriver) GetContext(ctx context.Context, key string) (string, error) {
+	value, _, err := f.readContext(key)
+	return value, err
+}
+
+func (f *FileDriver) SetContext(ctx context.Context, key string, value string, expireTime time.Duration) error {
+	var expireAt time.Time
+	if expireTime > 0 {
+		expireAt = time.Now().Add(expireTime)
+	}
+	return f.write(key, value, expireAt)
+}
+
+func (f *FileDriver) HasContext(ctx context.Context, key string) (bool, error) {
+	_, _, err := f.readContext(key)
+	if err == ErrMiss {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f *FileDriver) ForgetContext(ctx context.Context, key string) error {
+	err := os.Remove(f.path(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+//FlushContext 删除缓存目录下的所有文件, 目录本身保留
+func (f *FileDriver) FlushContext(ctx context.Context) error {
+	entries, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(f.dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *FileDriver) ManyContext(ctx context.Context, keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		value, _, err := f.readContext(key)
+		if err == ErrMiss {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (f *FileDriver) SetManyContext(ctx context.Context, values map[string]string, expireTime time.Duration) error {
+	for key, value := range values {
+		if err := f.SetContext(ctx, key, value, expireTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//AddContext 仅在本进程内保证原子性
+func (f *FileDriver) AddContext(ctx context.Context, key string, value string, expireTime time.Duration) (bool, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	if _, _, err := f.readContext(key); err != ErrMiss {
+		return false, err
+	}
+	return true, f.SetContext(ctx, key, value, expireTime)
+}
+
+//PullContext 仅在本进程内保证原子性
+func (f *FileDriver) PullContext(ctx context.Context, key string) (string, error) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	value, _, err := f.readContext(key)
+	if err != nil {
+		return "", err
+	}
+	return value, f.ForgetContext(ctx, key)
+}
+
+func (f *FileDriver) IsAlive() error {
+	_, err := os.Stat(f.dir)
+	return err
+}
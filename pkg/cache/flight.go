@@ -0,0 +1,46 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// flightGroup 合并同一进程内对同一个 key 的并发加载, 只有第一个调用者真正执行 fn, 其余等待并共享结果
+type flightGroup struct {
+	mux   sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall 正在进行中的加载
+type flightCall struct {
+	wg    sync.WaitGroup
+	value string
+	err   error
+}
+
+//do 执行 fn, 同一时刻相同 key 的调用只执行一次
+func (g *flightGroup) do(key string, fn func() (string, error)) (string, error) {
+	g.mux.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mux.Unlock()
+		call.wg.Wait()
+		return call.value, call.err
+	}
+	//fn 发生 panic 时, 等待中的调用者会拿到这个错误
+	call := &flightCall{err: errors.New("加载缓存时发生异常")}
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mux.Unlock()
+
+	defer func() {
+		g.mux.Lock()
+		delete(g.calls, key)
+		g.mux.Unlock()
+		call.wg.Done()
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
@@ -20,3 +20,11 @@ type CacheInterface interface {
 	// 当参数有 2 个时，第一个参数为 key ，第二个参数为要减去的值 int64 类型。
 	Decrement(parameters ...interface{})
 }
+
+// Locker 支持分布式锁的驱动, Remember 借助它保证多个实例之间同一时刻只有一个在回源加载,
+// 未实现该接口的驱动(memory、file)只在进程内合并加载
+type Locker interface {
+	// Lock 尝试获取锁, 成功时返回用于释放锁的函数; 锁被其他实例持有时 ok 为 false,
+	// 无法访问锁服务(如 redis 不可用)时返回错误, 以便与锁竞争区分
+	Lock(key string, ttl time.Duration) (unlock func(), ok bool, err error)
+}
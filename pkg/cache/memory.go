@@ -0,0 +1,289 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// MemoryDriver 进程内缓存, 实现 cache.CacheInterface。
+// 容量有限, 超出 maxItems 或 maxBytes 时淘汰最久未访问的 key
+type MemoryDriver struct {
+	mux      sync.Mutex
+	items    map[string]*list.Element
+	lru      *list.List
+	bytes    int64
+	maxItems int
+	maxBytes int64
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// memoryItem 缓存项, expireAt 为零值时永不过期
+type memoryItem struct {
+	key      string
+	value    string
+	expireAt time.Time
+}
+
+//NewMemory 返回实现了 cache.CacheInterface 的 MemoryDriver,
+//maxItems、maxBytes 为 0 时不限制, 后台每分钟清理一次过期的 key
+func NewMemory(maxItems int, maxBytes int64) *MemoryDriver {
+	m := &MemoryDriver{
+		items:    make(map[string]*list.Element),
+		lru:      list.New(),
+		maxItems: maxItems,
+		maxBytes: maxBytes,
+		stop:     make(chan struct{}),
+	}
+	go m.janitor(time.Minute)
+	return m
+}
+
+func (m *MemoryDriver) Set(key string, value string, expireTime time.Duration) {
+	var expireAt time.Time
+	if expireTime > 0 {
+		expireAt = time.Now().Add(expireTime)
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.set(key, value, expireAt)
+}
+
+//set 写入缓存并淘汰超出容量的 key, 调用方需持有锁
+func (m *MemoryDriver) set(key string, value string, expireAt time.Time) {
+	if elem, ok := m.items[key]; ok {
+		item := elem.Value.(*memoryItem)
+		m.bytes += int64(len(value) - len(item.value))
+		item.value, item.expireAt = value, expireAt
+		m.lru.MoveToFront(elem)
+	} else {
+		m.items[key] = m.lru.PushFront(&memoryItem{key: key, value: value, expireAt: expireAt})
+		m.bytes += int64(len(key) + len(value))
+	}
+
+	for m.lru.Len() > 1 && ((m.maxItems > 0 && m.lru.Len() > m.maxItems) || (m.maxBytes > 0 && m.bytes > m.maxBytes)) {
+		m.remove(m.lru.Back())
+	}
+}
+
+//get 读取未过期的缓存项并移到 LRU 的头部, 调用方需持有锁
+func (m *MemoryDriver) get(key string) (*memoryItem, bool) {
+	elem, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := elem.Value.(*memoryItem)
+	if !item.expireAt.IsZero() && time.Now().After(item.expireAt) {
+		m.remove(elem)
+		return nil, false
+	}
+	m.lru.MoveToFront(elem)
+	return item, true
+}
+
+//remove 删除缓存项, 调用方需持有锁
+func (m *MemoryDriver) remove(elem *list.Element) {
+	item := m.lru.Remove(elem).(*memoryItem)
+	delete(m.items, item.key)
+	m.bytes -= int64(len(item.key) + len(item.value))
+}
+
+func (m *MemoryDriver) Get(key string) string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if item, ok := m.get(key); ok {
+		return item.value
+	}
+	return ""
+}
+
+func (m *MemoryDriver) Has(key string) bool {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	_, ok := m.get(key)
+	return ok
+}
+
+func (m *MemoryDriver) Forget(key string) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if elem, ok := m.items[key]; ok {
+		m.remove(elem)
+	}
+}
+
+func (m *MemoryDriver) Forever(key string, value string) {
+	m.Set(key, value, 0)
+}
+
+func (m *MemoryDriver) Flush() {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.items = make(map[string]*list.Element)
+	m.lru.Init()
+	m.bytes = 0
+}
+
+func (m *MemoryDriver) Increment(parameters ...interface{}) {
+	m.incr(1, parameters...)
+}
+
+func (m *MemoryDriver) Decrement(parameters ...interface{}) {
+	m.incr(-1, parameters...)
+}
+
+//incr 按 sign 的方向修改数值, 过期时间保持不变, key 不存在时从 0 开始
+func (m *MemoryDriver) incr(sign int64, parameters ...interface{}) {
+	key, delta, err := incrParameters(parameters...)
+	if err != nil {
+		return
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	var current int64
+	var expireAt time.Time
+	if item, ok := m.get(key); ok {
+		current, _ = strconv.ParseInt(item.value, 10, 64)
+		expireAt = item.expireAt
+	}
+	m.set(key, strconv.FormatInt(current+sign*delta, 10), expireAt)
+}
+
+func (m *MemoryDriver) IsAlive() error {
+	return nil
+}
+
+func (m *MemoryDriver) GetContext(ctx context.Context, key string) (string, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if item, ok := m.get(key); ok {
+		return item.value, nil
+	}
+	return "", ErrMiss
+}
+
+func (m *MemoryDriver) SetContext(ctx context.Context, key string, value string, expireTime time.Duration) error {
+	m.Set(key, value, expireTime)
+	return nil
+}
+
+func (m *MemoryDriver) HasContext(ctx context.Context, key string) (bool, error) {
+	return m.Has(key), nil
+}
+
+func (m *MemoryDriver) ForgetContext(ctx context.Context, key string) error {
+	m.Forget(key)
+	return nil
+}
+
+func (m *MemoryDriver) FlushContext(ctx context.Context) error {
+	m.Flush()
+	return nil
+}
+
+func (m *MemoryDriver) ManyContext(ctx context.Context, keys []string) (map[string]string, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	values := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if item, ok := m.get(key); ok {
+			values[key] = item.value
+		}
+	}
+	return values, nil
+}
+
+func (m *MemoryDriver) SetManyContext(ctx context.Context, values map[string]string, expireTime time.Duration) error {
+	for key, value := range values {
+		m.Set(key, value, expireTime)
+	}
+	return nil
+}
+
+func (m *MemoryDriver) AddContext(ctx context.Context, key string, value string, expireTime time.Duration) (bool, error) {
+	var expireAt time.Time
+	if expireTime > 0 {
+		expireAt = time.Now().Add(expireTime)
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if _, ok := m.get(key); ok {
+		return false, nil
+	}
+	m.set(key, value, expireAt)
+	return true, nil
+}
+
+func (m *MemoryDriver) PullContext(ctx context.Context, key string) (string, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	item, ok := m.get(key)
+	if !ok {
+		return "", ErrMiss
+	}
+	m.remove(m.items[key])
+	return item.value, nil
+}
+
+//Len 当前缓存的 key 数量(可能包含尚未清理的过期 key)
+func (m *MemoryDriver) Len() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.lru.Len()
+}
+
+//janitor 定期清理过期的 key
+func (m *MemoryDriver) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case now := <-ticker.C:
+			m.mux.Lock()
+			for elem := m.lru.Back(); elem != nil; {
+				prev := elem.Prev()
+				item := elem.Value.(*memoryItem)
+				if !item.expireAt.IsZero() && now.After(item.expireAt) {
+					m.remove(elem)
+				}
+				elem = prev
+			}
+			m.mux.Unlock()
+		}
+	}
+}
+
+//Stop 停止后台清理
+func (m *MemoryDriver) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+//incrParameters 解析 Increment/Decrement 的参数: key 以及可选的 int64 增量(缺省为 1)
+func incrParameters(parameters ...interface{}) (string, int64, error) {
+	if len(parameters) == 0 || len(parameters) > 2 {
+		return "", 0, errors.New("参数数量有误")
+	}
+	key, ok := parameters[0].(string)
+	if !ok {
+		return "", 0, errors.New("key 必须为字符串")
+	}
+	if len(parameters) == 1 {
+		return key, 1, nil
+	}
+	delta, ok := parameters[1].(int64)
+	if !ok {
+		return "", 0, errors.New("增量必须为 int64")
+	}
+	return key, delta, nil
+}
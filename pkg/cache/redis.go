@@ -1,11 +1,25 @@
 package cache
 
 import (
+	"context"
 	"gin-api/pkg/config"
+	"gin-api/pkg/lock"
+	"gin-api/pkg/logger"
 	"gin-api/pkg/redis"
+	goredis "github.com/go-redis/redis/v8"
+	"strings"
 	"time"
 )
 
+//pullScript 原子地读取并删除, 兼容不支持 GETDEL 的 redis 6.2 以下版本
+var pullScript = goredis.NewScript(`
+local value = redis.call('GET', KEYS[1])
+if value then
+	redis.call('DEL', KEYS[1])
+end
+return value
+`)
+
 // RedisDriver 实现 cache.CacheInterface
 type RedisDriver struct {
 	RedisClient *redis.RedisClient
@@ -14,8 +28,13 @@ type RedisDriver struct {
 
 //NewRedis 返回实现了 cache.CacheInterface 的 RedisDriver
 func NewRedis(address string, username string, password string, db int) *RedisDriver {
+	return NewRedisWithClient(redis.NewClient(address, username, password, db))
+}
+
+//NewRedisWithClient 基于已有的连接返回 RedisDriver, 如 NewRedisWithClient(redis.Connection("cache"))
+func NewRedisWithClient(client *redis.RedisClient) *RedisDriver {
 	rs             := &RedisDriver{}
-	rs.RedisClient = redis.NewClient(address, username, password, db)
+	rs.RedisClient = client
 	rs.KeyPrefix   = config.GetString("app.name") + ":cache:"
 	return rs
 }
@@ -40,18 +59,216 @@ func (s *RedisDriver) Forever(key string, value string) {
 	s.RedisClient.Set(s.KeyPrefix+key, value, 0)
 }
 
+//Flush 只删除 KeyPrefix 开头的 key, 不会影响同一个 db 中限流、锁等其他数据
 func (s *RedisDriver) Flush() {
-	s.RedisClient.FlushDB()
+	logger.LogIf("cache-flush", s.FlushContext(s.RedisClient.Ctx))
 }
 
 func (s *RedisDriver) Increment(parameters ...interface{}) {
-	s.RedisClient.Increment(parameters...)
+	s.incr(1, parameters...)
 }
 
 func (s *RedisDriver) Decrement(parameters ...interface{}) {
-	s.RedisClient.Decrement(parameters...)
+	s.incr(-1, parameters...)
+}
+
+//incr 按 sign 的方向修改数值, key 同样需要加上 KeyPrefix
+func (s *RedisDriver) incr(sign int64, parameters ...interface{}) {
+	key, delta, err := incrParameters(parameters...)
+	if err == nil {
+		err = s.RedisClient.Client.IncrBy(s.RedisClient.Ctx, s.KeyPrefix+key, sign*delta).Err()
+	}
+	logger.LogIf("cache-incr", err)
 }
 
 func (s *RedisDriver) IsAlive() error {
 	return s.RedisClient.Ping()
 }
+
+//Lock 实现 cache.Locker, 基于 pkg/lock, 释放时校验 token, 避免误删其他实例在锁过期后获取的锁
+func (s *RedisDriver) Lock(key string, ttl time.Duration) (func(), bool, error) {
+	l, err := lock.TryAcquire(s.RedisClient.Ctx, key, ttl, lock.WithClient(s.RedisClient), lock.WithPrefix(s.KeyPrefix+"lock:"))
+	if err == lock.ErrNotAcquired {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return func() {
+		l.Release()
+	}, true, nil
+}
+
+func (s *RedisDriver) GetContext(ctx context.Context, key string) (string, error) {
+	value, err := s.RedisClient.Client.Get(ctx, s.KeyPrefix+key).Result()
+	if err == goredis.Nil {
+		return "", ErrMiss
+	}
+	return value, err
+}
+
+func (s *RedisDriver) SetContext(ctx context.Context, key string, value string, expireTime time.Duration) error {
+	return s.RedisClient.Client.Set(ctx, s.KeyPrefix+key, value, expireTime).Err()
+}
+
+func (s *RedisDriver) HasContext(ctx context.Context, key string) (bool, error) {
+	n, err := s.RedisClient.Client.Exists(ctx, s.KeyPrefix+key).Result()
+	return n > 0, err
+}
+
+func (s *RedisDriver) ForgetContext(ctx context.Context, key string) error {
+	return s.RedisClient.Client.Del(ctx, s.KeyPrefix+key).Err()
+}
+
+//FlushContext 使用 SCAN 分批删除 KeyPrefix 开头的 key, 不会像 FLUSHDB 那样清空整个 db, 也不会像 KEYS 那样阻塞 redis
+func (s *RedisDriver) FlushContext(ctx context.Context) error {
+	return s.deleteMatch(ctx, escapeGlob(s.KeyPrefix)+"*")
+}
+
+//ManyContext 使用一次 MGET 读取, 集群模式下 key 可能分布在不同的槽, 改为 pipeline 逐个 GET
+func (s *RedisDriver) ManyContext(ctx context.Context, keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = s.KeyPrefix + key
+	}
+
+	if _, ok := s.RedisClient.Client.(*goredis.ClusterClient); ok {
+		cmds := make([]*goredis.StringCmd, len(keys))
+		_, err := s.RedisClient.Client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+			for i, key := range prefixed {
+				cmds[i] = pipe.Get(ctx, key)
+			}
+			return nil
+		})
+		if err != nil && err != goredis.Nil {
+			return nil, err
+		}
+		for i, cmd := range cmds {
+			if value, err := cmd.Result(); err == nil {
+				values[keys[i]] = value
+			}
+		}
+		return values, nil
+	}
+
+	result, err := s.RedisClient.Client.MGet(ctx, prefixed...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for i, value := range result {
+		if str, ok := value.(string); ok {
+			values[keys[i]] = str
+		}
+	}
+	return values, nil
+}
+
+//getWithTTL 使用 pipeline 读取多个 key 及其剩余有效期, 供 TieredDriver 回填 L1 时不超过 L2 的有效期;
+//永不过期的 key 有效期为 0, 读取后恰好过期的 key 视为不存在
+func (s *RedisDriver) getWithTTL(ctx context.Context, keys []string) (map[string]string, map[string]time.Duration, error) {
+	values := make(map[string]string, len(keys))
+	ttls := make(map[string]time.Duration, len(keys))
+	if len(keys) == 0 {
+		return values, ttls, nil
+	}
+	gets := make([]*goredis.StringCmd, len(keys))
+	pttls := make([]*goredis.DurationCmd, len(keys))
+	_, err := s.RedisClient.Client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for i, key := range keys {
+			gets[i] = pipe.Get(ctx, s.KeyPrefix+key)
+			pttls[i] = pipe.PTTL(ctx, s.KeyPrefix+key)
+		}
+		return nil
+	})
+	if err != nil && err != goredis.Nil {
+		return nil, nil, err
+	}
+	for i, key := range keys {
+		value, err := gets[i].Result()
+		if err != nil {
+			continue
+		}
+		//PTTL 返回 -1 表示永不过期, -2 表示 key 已不存在
+		ttl := pttls[i].Val()
+		if ttl == -1 {
+			ttl = 0
+		} else if ttl <= 0 {
+			continue
+		}
+		values[key], ttls[key] = value, ttl
+	}
+	return values, ttls, nil
+}
+
+//SetManyContext 使用 pipeline 一次提交
+func (s *RedisDriver) SetManyContext(ctx context.Context, values map[string]string, expireTime time.Duration) error {
+	if len(values) == 0 {
+		return nil
+	}
+	_, err := s.RedisClient.Client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for key, value := range values {
+			pipe.Set(ctx, s.KeyPrefix+key, value, expireTime)
+		}
+		return nil
+	})
+	return err
+}
+
+func (s *RedisDriver) AddContext(ctx context.Context, key string, value string, expireTime time.Duration) (bool, error) {
+	return s.RedisClient.Client.SetNX(ctx, s.KeyPrefix+key, value, expireTime).Result()
+}
+
+func (s *RedisDriver) PullContext(ctx context.Context, key string) (string, error) {
+	value, err := pullScript.Run(ctx, s.RedisClient.Client, []string{s.KeyPrefix + key}).Text()
+	if err == goredis.Nil {
+		return "", ErrMiss
+	}
+	return value, err
+}
+
+//deleteMatch 使用 SCAN 分批删除匹配 pattern 的 key, 集群模式下逐个扫描主节点
+func (s *RedisDriver) deleteMatch(ctx context.Context, pattern string) error {
+	if cluster, ok := s.RedisClient.Client.(*goredis.ClusterClient); ok {
+		return cluster.ForEachMaster(ctx, func(ctx context.Context, master *goredis.Client) error {
+			return scanDelete(ctx, master, pattern)
+		})
+	}
+	return scanDelete(ctx, s.RedisClient.Client, pattern)
+}
+
+//scanDelete 在单个节点上使用 SCAN 分批删除匹配 pattern 的 key,
+//同一批的 key 可能属于集群中不同的槽, 因此通过 pipeline 逐个删除而不是一次 DEL 多个 key
+func scanDelete(ctx context.Context, client goredis.UniversalClient, pattern string) error {
+	var cursor uint64
+	for {
+		keys, next, err := client.Scan(ctx, cursor, pattern, 1000).Result()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			_, err := client.Pipelined(ctx, func(pipe goredis.Pipeliner) error {
+				for _, key := range keys {
+					pipe.Del(ctx, key)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+//escapeGlob 转义 SCAN MATCH 中的通配符
+func escapeGlob(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+	return replacer.Replace(s)
+}
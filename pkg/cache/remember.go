@@ -0,0 +1,235 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gin-api/pkg/logger"
+	"math"
+	"math/rand"
+	"time"
+)
+
+//ErrNotFound 回源时数据不存在, fn 返回该错误(或包装了该错误)时会被短暂缓存, 避免不存在的 key 反复穿透到数据库
+var ErrNotFound = errors.New("数据不存在")
+
+//rememberOptions Remember 的可选参数
+type rememberOptions struct {
+	stale    time.Duration
+	beta     float64
+	negative time.Duration
+	lockTTL  time.Duration
+	lockWait time.Duration
+}
+
+//RememberOption Remember 的可选参数
+type RememberOption func(o *rememberOptions)
+
+//WithStale 过期后的 stale 时间内仍然返回旧值, 同时在后台刷新(stale-while-revalidate)
+func WithStale(stale time.Duration) RememberOption {
+	return func(o *rememberOptions) {
+		o.stale = stale
+	}
+}
+
+//WithEarlyRefresh 开启概率性提前刷新: 越接近过期、回源越慢, 越有可能在过期前由某个请求提前在后台刷新,
+//beta 越大越倾向于提前刷新, 通常取 1
+func WithEarlyRefresh(beta float64) RememberOption {
+	return func(o *rememberOptions) {
+		o.beta = beta
+	}
+}
+
+//WithNegative 设置数据不存在时的缓存时长, 缺省为 1 分钟, 0 表示不缓存
+func WithNegative(ttl time.Duration) RememberOption {
+	return func(o *rememberOptions) {
+		o.negative = ttl
+	}
+}
+
+//WithLock 设置分布式锁的有效期, 以及未抢到锁时等待其他实例写入缓存的最长时间, 缺省为 10 秒和 3 秒
+func WithLock(ttl, wait time.Duration) RememberOption {
+	return func(o *rememberOptions) {
+		o.lockTTL, o.lockWait = ttl, wait
+	}
+}
+
+//envelope Remember 写入缓存的内容, 除了数据本身还记录逻辑上的过期时间和回源耗时。
+//缓存的实际有效期为 ttl + stale, 以便在逻辑过期后仍能返回旧值
+type envelope struct {
+	Value    json.RawMessage `json:"v,omitempty"`
+	ExpireAt int64           `json:"e"` //逻辑过期时间, unix 毫秒, 0 表示永不过期
+	Delta    int64           `json:"d"` //回源耗时, 毫秒
+	NotFound bool            `json:"n,omitempty"`
+}
+
+//fresh 是否尚未逻辑过期
+func (e *envelope) fresh(now int64) bool {
+	return e.ExpireAt == 0 || now < e.ExpireAt
+}
+
+//decode 将数据解析到 wanted
+func (e *envelope) decode(wanted interface{}) error {
+	if e.NotFound {
+		return ErrNotFound
+	}
+	return json.Unmarshal(e.Value, wanted)
+}
+
+//parseEnvelope 解析缓存中的内容, 不是 Remember 写入的内容时返回 false
+func parseEnvelope(raw string) (*envelope, bool) {
+	if raw == "" {
+		return nil, false
+	}
+	e := &envelope{}
+	if err := json.Unmarshal([]byte(raw), e); err != nil || (e.Value == nil && !e.NotFound) {
+		return nil, false
+	}
+	return e, true
+}
+
+//flights 合并进程内对同一个 key 的并发回源
+var flights flightGroup
+
+//Remember 读取缓存, 不存在或已过期时调用 fn 回源并写入缓存, 结果解析到 wanted(需传地址), 用法如下:
+//	user := model.User{}
+//	err := cache.Remember("user:1", time.Hour, &user, func() (interface{}, error) {
+//		return model.FindUser(1)
+//	}, cache.WithStale(time.Minute))
+//同一进程内并发的回源会被合并; 驱动支持分布式锁(redis、tiered)时, 多个实例之间也只有一个在回源。
+//Remember 写入的内容带有过期时间等信息, 只能通过 Remember 读取, 但可以用 Forget 删除
+func Remember(key string, ttl time.Duration, wanted interface{}, fn func() (interface{}, error), options ...RememberOption) error {
+	o := &rememberOptions{
+		negative: time.Minute,
+		lockTTL:  10 * time.Second,
+		lockWait: 3 * time.Second,
+	}
+	for _, option := range options {
+		option(o)
+	}
+
+	if e, ok := parseEnvelope(cache.Driver.Get(key)); ok {
+		now := nowMillis()
+		switch {
+		case e.fresh(now):
+			if o.beta > 0 && e.ExpireAt > 0 && earlyRefresh(e, now, o.beta) {
+				go refresh(key, ttl, fn, o)
+			}
+			return e.decode(wanted)
+		case o.stale > 0 && now < e.ExpireAt+int64(o.stale/time.Millisecond):
+			go refresh(key, ttl, fn, o)
+			return e.decode(wanted)
+		}
+	}
+
+	raw, err := flights.do(key, func() (string, error) {
+		return load(key, ttl, fn, o, true)
+	})
+	if err != nil {
+		return err
+	}
+	e, _ := parseEnvelope(raw)
+	return e.decode(wanted)
+}
+
+//RememberForever 与 Remember 相同, 但缓存永不过期
+func RememberForever(key string, wanted interface{}, fn func() (interface{}, error), options ...RememberOption) error {
+	return Remember(key, 0, wanted, fn, options...)
+}
+
+//earlyRefresh 概率性提前过期(XFetch): now - delta * beta * ln(rand) >= expireAt 时提前刷新
+func earlyRefresh(e *envelope, now int64, beta float64) bool {
+	delta := math.Max(float64(e.Delta), 1)
+	return float64(now)-delta*beta*math.Log(rand.Float64()) >= float64(e.ExpireAt)
+}
+
+//refresh 在后台刷新缓存, 其他实例正在刷新时直接放弃;
+//与前台回源使用不同的合并 key, 前台回源不会加入后台刷新而拿到 errRefreshing
+func refresh(key string, ttl time.Duration, fn func() (interface{}, error), o *rememberOptions) {
+	defer func() {
+		if err := recover(); err != nil {
+			logger.Log("cache-refresh", fmt.Sprintf("%s: %v", key, err))
+		}
+	}()
+	_, err := flights.do(key+"#refresh", func() (string, error) {
+		return load(key, ttl, fn, o, false)
+	})
+	if err != nil && err != errRefreshing && !errors.Is(err, ErrNotFound) {
+		logger.LogIf("cache-refresh", err)
+	}
+}
+
+//errRefreshing 后台刷新时其他实例已持有锁
+var errRefreshing = errors.New("其他实例正在刷新缓存")
+
+//load 回源并写入缓存, 返回写入的内容。
+//驱动支持分布式锁时先抢锁, 抢不到时 wait 为 true 则等待其他实例写入缓存, 超时后自行回源;
+//锁服务不可用时不再等待, 直接回源
+func load(key string, ttl time.Duration, fn func() (interface{}, error), o *rememberOptions, wait bool) (string, error) {
+	if locker, ok := cache.Driver.(Locker); ok {
+		unlock, locked, err := locker.Lock(key, o.lockTTL)
+		switch {
+		case err != nil:
+			logger.LogIf("cache-lock", err)
+		case locked:
+			defer unlock()
+			//抢到锁之前其他实例可能已经写入了新的缓存
+			if raw := cache.Driver.Get(key); isFresh(raw) {
+				return raw, nil
+			}
+		case !wait:
+			return "", errRefreshing
+		default:
+			for deadline := time.Now().Add(o.lockWait); time.Now().Before(deadline); {
+				time.Sleep(50 * time.Millisecond)
+				if raw := cache.Driver.Get(key); isFresh(raw) {
+					return raw, nil
+				}
+			}
+		}
+	}
+
+	start := time.Now()
+	value, err := fn()
+	e := envelope{Delta: int64(time.Since(start) / time.Millisecond)}
+	expire := ttl
+	switch {
+	case errors.Is(err, ErrNotFound):
+		if o.negative <= 0 {
+			return "", err
+		}
+		e.NotFound = true
+		e.ExpireAt = nowMillis() + int64(o.negative/time.Millisecond)
+		expire = o.negative
+	case err != nil:
+		return "", err
+	default:
+		if e.Value, err = json.Marshal(value); err != nil {
+			return "", err
+		}
+		if ttl > 0 {
+			e.ExpireAt = nowMillis() + int64(ttl/time.Millisecond)
+			expire = ttl + o.stale
+		}
+	}
+
+	b, _ := json.Marshal(e)
+	raw := string(b)
+	if expire > 0 {
+		cache.Driver.Set(key, raw, expire)
+	} else {
+		cache.Driver.Forever(key, raw)
+	}
+	return raw, nil
+}
+
+//isFresh 缓存内容是否为 Remember 写入且尚未逻辑过期
+func isFresh(raw string) bool {
+	e, ok := parseEnvelope(raw)
+	return ok && e.fresh(nowMillis())
+}
+
+//nowMillis 当前 unix 毫秒
+func nowMillis() int64 {
+	return time.Now().UnixNano() / 1e6
+}
@@ -0,0 +1,89 @@
+package cache
+
+import (
+	"errors"
+	"gin-api/pkg/app"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//lockDriver 可以模拟锁竞争与锁服务故障的驱动
+type lockDriver struct {
+	*MemoryDriver
+	held  int32         //为 1 时锁被其他实例持有
+	err   error         //不为空时 Lock 返回该错误
+	delay time.Duration //Lock 的耗时
+}
+
+func (d *lockDriver) Lock(key string, ttl time.Duration) (func(), bool, error) {
+	time.Sleep(d.delay)
+	if d.err != nil {
+		return nil, false, d.err
+	}
+	if atomic.LoadInt32(&d.held) == 1 {
+		return nil, false, nil
+	}
+	return func() {}, true, nil
+}
+
+//useTempRoot 测试期间切换到临时目录并以其为项目根目录初始化 app, 日志写入该目录而不是源码目录
+func useTempRoot(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	app.New()
+}
+
+func TestRememberLockError(t *testing.T) {
+	useTempRoot(t)
+	useDriver(t, &lockDriver{MemoryDriver: NewMemory(0, 0), err: errors.New("redis 不可用")})
+
+	//锁服务不可用时直接回源, 而不是当作锁竞争等待 lockWait
+	start := time.Now()
+	var value int
+	err := Remember("lock-error", time.Minute, &value, func() (interface{}, error) {
+		return 1, nil
+	}, WithLock(time.Second, time.Second))
+	if err != nil || value != 1 {
+		t.Fatalf("Remember = %d, %v", value, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("锁服务不可用时等待了 %v", elapsed)
+	}
+}
+
+func TestRememberDuringRefresh(t *testing.T) {
+	driver := &lockDriver{MemoryDriver: NewMemory(0, 0), delay: 50 * time.Millisecond}
+	useDriver(t, driver)
+	fn := func() (interface{}, error) {
+		return 1, nil
+	}
+	options := []RememberOption{WithStale(time.Minute), WithLock(time.Second, 100*time.Millisecond)}
+
+	var value int
+	if err := Remember("refreshing", time.Millisecond, &value, fn, options...); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	//其他实例持有锁, 返回旧值并在后台尝试刷新
+	atomic.StoreInt32(&driver.held, 1)
+	if err := Remember("refreshing", time.Millisecond, &value, fn, options...); err != nil || value != 1 {
+		t.Fatalf("过期后的读取 = %d, %v", value, err)
+	}
+
+	//后台刷新还在抢锁时, 前台回源不能拿到后台刷新的内部错误
+	time.Sleep(10 * time.Millisecond)
+	Forget("refreshing")
+	value = 0
+	if err := Remember("refreshing", time.Millisecond, &value, fn, options...); err != nil || value != 1 {
+		t.Fatalf("后台刷新期间的回源 = %d, %v", value, err)
+	}
+}
@@ -0,0 +1,149 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"reflect"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Serializer 缓存值的编码方式, 用于 cache.Ctx 以及 GetAs、Many 等接口。
+// 实现该接口即可接入其他编码, 如 protobuf
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	// Unmarshal v 需传地址
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONSerializer 默认的编码方式, 可读性好, 与 cache.Set、cache.GetObject 写入的内容兼容
+type JSONSerializer struct{}
+
+func (JSONSerializer) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONSerializer) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// GobSerializer 使用 encoding/gob 编码, 能保留 time.Time、[]byte、int64 等类型的精度,
+// 但只能解析到与写入时结构兼容的类型, 不能解析到 interface{}
+type GobSerializer struct{}
+
+func (GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (GobSerializer) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// MsgpackSerializer 使用 msgpack 编码, 比 JSON 更紧凑, 能保留 time.Time、[]byte 的类型;
+// 结构体字段名取自 codec 或 json 标签, 解析到 interface{} 时 map 的类型为 map[string]interface{}
+type MsgpackSerializer struct{}
+
+//msgpackHandle 设置完成后可以在多个协程中共用
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{WriteExt: true}
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}()
+
+func (MsgpackSerializer) Marshal(v interface{}) ([]byte, error) {
+	var data []byte
+	err := codec.NewEncoderBytes(&data, msgpackHandle).Encode(v)
+	return data, err
+}
+
+func (MsgpackSerializer) Unmarshal(data []byte, v interface{}) error {
+	return codec.NewDecoderBytes(data, msgpackHandle).Decode(v)
+}
+
+//压缩标记, 写在编码结果的第一个字节
+const (
+	plainMark byte = 'p'
+	gzipMark  byte = 'z'
+)
+
+// CompressedSerializer 编码结果超过 Threshold 字节时使用 gzip 压缩, 适合缓存较大的值。
+// 编码结果的第一个字节标记是否压缩, 因此不能读取未经压缩编码写入的缓存
+type CompressedSerializer struct {
+	Serializer Serializer
+	Threshold  int
+}
+
+//NewCompressed 返回在编码结果超过 threshold 字节时压缩的 Serializer
+func NewCompressed(s Serializer, threshold int) *CompressedSerializer {
+	return &CompressedSerializer{Serializer: s, Threshold: threshold}
+}
+
+func (c *CompressedSerializer) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.Serializer.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) <= c.Threshold {
+		return append([]byte{plainMark}, data...), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(gzipMark)
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c *CompressedSerializer) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return errors.New("缓存内容为空")
+	}
+	switch data[0] {
+	case plainMark:
+		return c.Serializer.Unmarshal(data[1:], v)
+	case gzipMark:
+		r, err := gzip.NewReader(bytes.NewReader(data[1:]))
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		raw, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		return c.Serializer.Unmarshal(raw, v)
+	default:
+		return errors.New("缓存内容不是压缩编码写入的")
+	}
+}
+
+//NewSerializer 根据名称返回 Serializer, 可选 json、gob、msgpack, threshold 大于 0 时超过该字节数的值会被压缩
+func NewSerializer(name string, threshold int) (Serializer, error) {
+	var s Serializer
+	switch name {
+	case "", "json":
+		s = JSONSerializer{}
+	case "gob":
+		s = GobSerializer{}
+	case "msgpack":
+		s = MsgpackSerializer{}
+	default:
+		return nil, errors.New("不支持的缓存编码: " + name)
+	}
+	if threshold > 0 {
+		s = NewCompressed(s, threshold)
+	}
+	return s, nil
+}
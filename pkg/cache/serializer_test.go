@@ -0,0 +1,81 @@
+package cache
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type serializerItem struct {
+	ID        uint64            `json:"id"`
+	Name      string            `json:"name"`
+	Tags      []string          `json:"tags"`
+	Attrs     map[string]string `json:"attrs"`
+	Raw       []byte            `json:"raw"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+func TestSerializers(t *testing.T) {
+	item := serializerItem{
+		ID:        1 << 40,
+		Name:      "gin-api",
+		Tags:      []string{"a", "b"},
+		Attrs:     map[string]string{"k": "v"},
+		Raw:       []byte{0, 1, 2},
+		CreatedAt: time.Date(2021, 10, 1, 8, 0, 0, 123456789, time.UTC),
+	}
+
+	for _, name := range []string{"json", "gob", "msgpack"} {
+		for _, threshold := range []int{0, 16} {
+			s, err := NewSerializer(name, threshold)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := s.Marshal(item)
+			if err != nil {
+				t.Fatalf("%v Marshal: %v", name, err)
+			}
+			var got serializerItem
+			if err := s.Unmarshal(data, &got); err != nil {
+				t.Fatalf("%v Unmarshal: %v", name, err)
+			}
+			if !reflect.DeepEqual(got, item) {
+				t.Fatalf("%v(threshold=%d) 解码结果 %+v, 期望 %+v", name, threshold, got, item)
+			}
+		}
+	}
+
+	if _, err := NewSerializer("xml", 0); err == nil {
+		t.Fatal("不支持的编码应返回错误")
+	}
+}
+
+func TestMsgpackSerializer(t *testing.T) {
+	s := MsgpackSerializer{}
+
+	//字段名取自 json 标签, 解析到 interface{} 时得到 map[string]interface{}
+	data, err := s.Marshal(serializerItem{ID: 7, Name: "msgpack"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var generic interface{}
+	if err := s.Unmarshal(data, &generic); err != nil {
+		t.Fatal(err)
+	}
+	m, ok := generic.(map[string]interface{})
+	if !ok {
+		t.Fatalf("解析到 interface{} 得到 %T", generic)
+	}
+	if m["name"] != "msgpack" {
+		t.Fatalf("name = %#v", m["name"])
+	}
+
+	//比 JSON 更紧凑
+	long := map[string]interface{}{"numbers": []int{1000000, 2000000, 3000000}, "text": strings.Repeat("x", 10)}
+	packed, _ := s.Marshal(long)
+	json, _ := JSONSerializer{}.Marshal(long)
+	if len(packed) >= len(json) {
+		t.Fatalf("msgpack %d 字节, json %d 字节", len(packed), len(json))
+	}
+}
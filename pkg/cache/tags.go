@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"gin-api/pkg/helpers"
+	"gin-api/pkg/logger"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TaggedCache 带标签的缓存。
+// 每个标签在缓存中保存一个版本号, 实际的 key 由所有标签当前的版本号和原始 key 组成,
+// Flush 时只需更换版本号, 旧版本的缓存不会再被读到, 之后随过期时间自然淘汰,
+// 因此带标签的缓存应当设置过期时间, 避免旧数据长期占用空间
+type TaggedCache struct {
+	names []string
+}
+
+//Tags 返回带标签的缓存, 用法如下:
+//	cache.Tags("users").Set("user:1", user, time.Hour)
+//	cache.Tags("users", "posts").Flush()
+func Tags(names ...string) *TaggedCache {
+	names = append([]string(nil), names...)
+	sort.Strings(names)
+	return &TaggedCache{names: names}
+}
+
+//versionKey 标签的版本号在缓存中的 key
+func versionKey(name string) string {
+	return "tag:" + name + ":version"
+}
+
+//namespace 由所有标签的版本号计算命名空间, 标签还没有版本号时生成一个
+func (t *TaggedCache) namespace() string {
+	versions := make([]string, 0, len(t.names))
+	for _, name := range t.names {
+		version := cache.Driver.Get(versionKey(name))
+		if version == "" {
+			version = t.init(name)
+		}
+		versions = append(versions, version)
+	}
+	sum := sha1.Sum([]byte(strings.Join(versions, "|")))
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+//init 标签还没有版本号时原子地写入一个(redis 为 SET NX), 并发初始化时以先写入的为准,
+//否则各个实例各自生成的版本号相互覆盖, 先写入的缓存会被当作已失效
+func (t *TaggedCache) init(name string) string {
+	driver, ok := cache.Driver.(ContextDriver)
+	if !ok {
+		driver = legacyDriver{cache.Driver}
+	}
+
+	ctx := context.Background()
+	version := helpers.StrUuid(16)
+	added, err := driver.AddContext(ctx, versionKey(name), version, 0)
+	if err != nil || added {
+		logger.LogIf(helpers.CurrentFuncName(), err)
+		return version
+	}
+	//其他实例先写入了版本号, 读取它; 读取前又被删除时使用本次生成的版本号, 只会少命中一次缓存
+	current, err := driver.GetContext(ctx, versionKey(name))
+	if err != nil {
+		if err != ErrMiss {
+			logger.LogIf(helpers.CurrentFuncName(), err)
+		}
+		return version
+	}
+	return current
+}
+
+//reset 为标签生成新的版本号
+func (t *TaggedCache) reset(name string) string {
+	version := helpers.StrUuid(16)
+	cache.Driver.Forever(versionKey(name), version)
+	return version
+}
+
+//Key 返回原始 key 在当前标签版本下实际使用的 key
+func (t *TaggedCache) Key(key string) string {
+	return "tagged:" + t.namespace() + ":" + key
+}
+
+func (t *TaggedCache) Set(key string, obj interface{}, expireTime time.Duration) {
+	b, err := json.Marshal(&obj)
+	logger.LogIf(helpers.CurrentFuncName(), err)
+	cache.Driver.Set(t.Key(key), string(b), expireTime)
+}
+
+func (t *TaggedCache) Get(key string) interface{} {
+	stringValue := cache.Driver.Get(t.Key(key))
+	var wanted interface{}
+	err := json.Unmarshal([]byte(stringValue), &wanted)
+	logger.LogIf(helpers.CurrentFuncName(), err)
+	return wanted
+}
+
+//GetObject 应该传地址
+func (t *TaggedCache) GetObject(key string, wanted interface{}) {
+	val := cache.Driver.Get(t.Key(key))
+	if len(val) > 0 {
+		err := json.Unmarshal([]byte(val), &wanted)
+		logger.LogIf(helpers.CurrentFuncName(), err)
+	}
+}
+
+func (t *TaggedCache) Has(key string) bool {
+	return cache.Driver.Has(t.Key(key))
+}
+
+func (t *TaggedCache) Forget(key string) {
+	cache.Driver.Forget(t.Key(key))
+}
+
+//Remember 与 cache.Remember 相同, 缓存随标签一起失效
+func (t *TaggedCache) Remember(key string, ttl time.Duration, wanted interface{}, fn func() (interface{}, error), options ...RememberOption) error {
+	return Remember(t.Key(key), ttl, wanted, fn, options...)
+}
+
+//Flush 使所有标签下的缓存失效, 带有其中任意一个标签的缓存都不会再被读到
+func (t *TaggedCache) Flush() {
+	for _, name := range t.names {
+		t.reset(name)
+	}
+}
@@ -0,0 +1,68 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// slowDriver 读取较慢的驱动, 放大 "读取版本号为空后写入" 之间的并发窗口
+type slowDriver struct {
+	*MemoryDriver
+}
+
+func (d slowDriver) Get(key string) string {
+	value := d.MemoryDriver.Get(key)
+	time.Sleep(time.Millisecond)
+	return value
+}
+
+//useDriver 在测试期间替换全局的缓存驱动
+func useDriver(t *testing.T, driver CacheInterface) {
+	Init(driver)
+	previous := cache
+	cache = &Cache{Driver: driver, Serializer: JSONSerializer{}}
+	t.Cleanup(func() { cache = previous })
+}
+
+func TestTagsConcurrentInit(t *testing.T) {
+	useDriver(t, slowDriver{NewMemory(0, 0)})
+
+	//多个协程同时为还没有版本号的标签初始化, 应得到同一个版本号
+	name := "concurrent:" + time.Now().String()
+	keys := make([]string, 50)
+	var wg sync.WaitGroup
+	for i := range keys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			keys[i] = Tags(name).Key("user:1")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, key := range keys {
+		if key != keys[0] {
+			t.Fatalf("并发初始化得到了不同的 key: %v, %v", keys[0], key)
+		}
+	}
+	if key := Tags(name).Key("user:1"); key != keys[0] {
+		t.Fatalf("初始化后 key 变为 %v, 期望 %v", key, keys[0])
+	}
+}
+
+func TestTagsFlush(t *testing.T) {
+	useDriver(t, NewMemory(0, 0))
+	name := "flush:" + time.Now().String()
+
+	Tags(name).Set("user:1", "alice", time.Minute)
+	Tags(name, "other").Set("user:2", "bob", time.Minute)
+	if got := Tags(name).Get("user:1"); got != "alice" {
+		t.Fatalf("Get = %v", got)
+	}
+
+	Tags(name).Flush()
+	if Tags(name).Has("user:1") || Tags(name, "other").Has("user:2") {
+		t.Fatal("Flush 后带有该标签的缓存仍然可以读到")
+	}
+}
@@ -0,0 +1,268 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"gin-api/pkg/helpers"
+	"gin-api/pkg/logger"
+	"sync"
+	"time"
+)
+
+// TieredDriver 两级缓存, 实现 cache.CacheInterface。
+// L1 为进程内的 MemoryDriver, L2 为 RedisDriver; 读取时先查 L1, 未命中再查 L2 并回填 L1。
+// 写入或删除时通过 redis 的发布/订阅通知其他实例删除各自 L1 中的副本,
+// 同时 L1 的有效期不超过 l1TTL, 即使通知丢失, 各实例读到旧值的时间也是有限的
+type TieredDriver struct {
+	L1      *MemoryDriver
+	L2      *RedisDriver
+	l1TTL   time.Duration
+	channel string
+	id      string
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// invalidation 失效通知
+type invalidation struct {
+	//From 发送通知的实例, 实例会忽略自己发出的通知
+	From string `json:"from"`
+	//Op 为 "forget" 时删除 Key, 为 "flush" 时清空 L1
+	Op  string `json:"op"`
+	Key string `json:"key,omitempty"`
+}
+
+//NewTiered 返回实现了 cache.CacheInterface 的 TieredDriver, 并开始订阅失效通知
+func NewTiered(l1 *MemoryDriver, l2 *RedisDriver, l1TTL time.Duration, channel string) *TieredDriver {
+	t := &TieredDriver{
+		L1:      l1,
+		L2:      l2,
+		l1TTL:   l1TTL,
+		channel: channel,
+		id:      helpers.StrUuid(16),
+		stop:    make(chan struct{}),
+	}
+	go t.subscribe()
+	return t
+}
+
+//subscribe 订阅失效通知, 连接断开后由 go-redis 自动重连并重新订阅
+func (t *TieredDriver) subscribe() {
+	rds := t.L2.RedisClient
+	pubsub := rds.Client.Subscribe(rds.Ctx, t.channel)
+	defer pubsub.Close()
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			var inv invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil || inv.From == t.id {
+				continue
+			}
+			if inv.Op == "flush" {
+				t.L1.Flush()
+			} else {
+				t.L1.Forget(inv.Key)
+			}
+		}
+	}
+}
+
+//publish 通知其他实例
+func (t *TieredDriver) publish(op string, key string) {
+	payload, _ := json.Marshal(invalidation{From: t.id, Op: op, Key: key})
+	rds := t.L2.RedisClient
+	err := rds.Client.Publish(rds.Ctx, t.channel, payload).Err()
+	logger.LogIf("cache-publish", err)
+}
+
+//l1Expire L1 的有效期取 expireTime 与 l1TTL 中较短的一个
+func (t *TieredDriver) l1Expire(expireTime time.Duration) time.Duration {
+	if expireTime <= 0 || expireTime > t.l1TTL {
+		return t.l1TTL
+	}
+	return expireTime
+}
+
+func (t *TieredDriver) Set(key string, value string, expireTime time.Duration) {
+	t.L2.Set(key, value, expireTime)
+	t.L1.Set(key, value, t.l1Expire(expireTime))
+	t.publish("forget", key)
+}
+
+func (t *TieredDriver) Get(key string) string {
+	if value := t.L1.Get(key); value != "" {
+		return value
+	}
+	value, err := t.GetContext(t.L2.RedisClient.Ctx, key)
+	if err != nil && err != ErrMiss {
+		logger.LogIf("cache-get", err)
+	}
+	return value
+}
+
+func (t *TieredDriver) Has(key string) bool {
+	return t.L1.Has(key) || t.L2.Has(key)
+}
+
+func (t *TieredDriver) Forget(key string) {
+	t.L2.Forget(key)
+	t.L1.Forget(key)
+	t.publish("forget", key)
+}
+
+func (t *TieredDriver) Forever(key string, value string) {
+	t.Set(key, value, 0)
+}
+
+func (t *TieredDriver) Flush() {
+	t.L2.Flush()
+	t.L1.Flush()
+	t.publish("flush", "")
+}
+
+func (t *TieredDriver) Increment(parameters ...interface{}) {
+	t.L2.Increment(parameters...)
+	t.invalidate(parameters...)
+}
+
+func (t *TieredDriver) Decrement(parameters ...interface{}) {
+	t.L2.Decrement(parameters...)
+	t.invalidate(parameters...)
+}
+
+//invalidate 计数类操作只在 L2 上进行, 之后删除所有实例 L1 中的副本
+func (t *TieredDriver) invalidate(parameters ...interface{}) {
+	if key, _, err := incrParameters(parameters...); err == nil {
+		t.L1.Forget(key)
+		t.publish("forget", key)
+	}
+}
+
+//Lock 实现 cache.Locker, 使用 L2 的分布式锁
+func (t *TieredDriver) Lock(key string, ttl time.Duration) (func(), bool, error) {
+	return t.L2.Lock(key, ttl)
+}
+
+func (t *TieredDriver) IsAlive() error {
+	return t.L2.IsAlive()
+}
+
+func (t *TieredDriver) GetContext(ctx context.Context, key string) (string, error) {
+	if value, err := t.L1.GetContext(ctx, key); err == nil {
+		return value, nil
+	}
+	values, err := t.ManyContext(ctx, []string{key})
+	if err != nil {
+		return "", err
+	}
+	value, ok := values[key]
+	if !ok {
+		return "", ErrMiss
+	}
+	return value, nil
+}
+
+func (t *TieredDriver) SetContext(ctx context.Context, key string, value string, expireTime time.Duration) error {
+	if err := t.L2.SetContext(ctx, key, value, expireTime); err != nil {
+		return err
+	}
+	t.L1.Set(key, value, t.l1Expire(expireTime))
+	t.publish("forget", key)
+	return nil
+}
+
+func (t *TieredDriver) HasContext(ctx context.Context, key string) (bool, error) {
+	if t.L1.Has(key) {
+		return true, nil
+	}
+	return t.L2.HasContext(ctx, key)
+}
+
+func (t *TieredDriver) ForgetContext(ctx context.Context, key string) error {
+	t.L1.Forget(key)
+	if err := t.L2.ForgetContext(ctx, key); err != nil {
+		return err
+	}
+	t.publish("forget", key)
+	return nil
+}
+
+func (t *TieredDriver) FlushContext(ctx context.Context) error {
+	t.L1.Flush()
+	if err := t.L2.FlushContext(ctx); err != nil {
+		return err
+	}
+	t.publish("flush", "")
+	return nil
+}
+
+func (t *TieredDriver) ManyContext(ctx context.Context, keys []string) (map[string]string, error) {
+	values, _ := t.L1.ManyContext(ctx, keys)
+	missing := make([]string, 0, len(keys)-len(values))
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+
+	//回填 L1 时取 L2 剩余的有效期与 l1TTL 中较短的一个, L2 过期后 L1 不会继续返回旧值
+	fetched, ttls, err := t.L2.getWithTTL(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range fetched {
+		values[key] = value
+		t.L1.Set(key, value, t.l1Expire(ttls[key]))
+	}
+	return values, nil
+}
+
+func (t *TieredDriver) SetManyContext(ctx context.Context, values map[string]string, expireTime time.Duration) error {
+	if err := t.L2.SetManyContext(ctx, values, expireTime); err != nil {
+		return err
+	}
+	for key, value := range values {
+		t.L1.Set(key, value, t.l1Expire(expireTime))
+		t.publish("forget", key)
+	}
+	return nil
+}
+
+func (t *TieredDriver) AddContext(ctx context.Context, key string, value string, expireTime time.Duration) (bool, error) {
+	ok, err := t.L2.AddContext(ctx, key, value, expireTime)
+	if err != nil || !ok {
+		return false, err
+	}
+	t.L1.Set(key, value, t.l1Expire(expireTime))
+	t.publish("forget", key)
+	return true, nil
+}
+
+func (t *TieredDriver) PullContext(ctx context.Context, key string) (string, error) {
+	t.L1.Forget(key)
+	value, err := t.L2.PullContext(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	t.publish("forget", key)
+	return value, nil
+}
+
+//Stop 停止订阅与 L1 的后台清理
+func (t *TieredDriver) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		t.L1.Stop()
+	})
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"context"
+	"gin-api/pkg/redis"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+//newTestTiered 基于 miniredis 创建两级缓存, L1 的有效期为 1 小时
+func newTestTiered(t *testing.T) (*TieredDriver, *miniredis.Miniredis) {
+	s := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+	l2 := NewRedisWithClient(&redis.RedisClient{Client: client, Ctx: context.Background()})
+	tiered := NewTiered(NewMemory(0, 0), l2, time.Hour, "cache:invalidate")
+	t.Cleanup(func() {
+		tiered.Stop()
+		client.Close()
+	})
+	return tiered, s
+}
+
+func TestTieredBackfillRespectsL2TTL(t *testing.T) {
+	tiered, s := newTestTiered(t)
+	ctx := context.Background()
+
+	//其他实例写入 L2, 剩余有效期远小于 l1TTL
+	for _, key := range []string{"get", "context", "many"} {
+		tiered.L2.Set(key, "v", 100*time.Millisecond)
+	}
+	tiered.L2.Set("forever", "v", 0)
+
+	if value := tiered.Get("get"); value != "v" {
+		t.Fatalf("Get = %q", value)
+	}
+	if value, err := tiered.GetContext(ctx, "context"); err != nil || value != "v" {
+		t.Fatalf("GetContext = %q, %v", value, err)
+	}
+	values, err := tiered.ManyContext(ctx, []string{"many", "forever", "missing"})
+	if err != nil || len(values) != 2 || values["many"] != "v" || values["forever"] != "v" {
+		t.Fatalf("ManyContext = %v, %v", values, err)
+	}
+
+	//L2 过期后, 回填的 L1 也应过期
+	time.Sleep(150 * time.Millisecond)
+	s.FastForward(150 * time.Millisecond)
+	if value := tiered.Get("get"); value != "" {
+		t.Fatalf("L2 过期后 Get = %q", value)
+	}
+	if _, err := tiered.GetContext(ctx, "context"); err != ErrMiss {
+		t.Fatalf("L2 过期后 GetContext 的错误为 %v, 期望 ErrMiss", err)
+	}
+	values, err = tiered.ManyContext(ctx, []string{"many", "forever"})
+	if err != nil || len(values) != 1 || values["forever"] != "v" {
+		t.Fatalf("L2 过期后 ManyContext = %v, %v", values, err)
+	}
+	//永不过期的 key 在 L1 中按 l1TTL 缓存
+	if value := tiered.L1.Get("forever"); value != "v" {
+		t.Fatalf("L1 中的 forever = %q", value)
+	}
+}
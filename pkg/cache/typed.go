@@ -0,0 +1,103 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"time"
+)
+
+//Many 批量读取缓存并解析到 wanted, wanted 为 map[string]T 或其地址, 不存在的 key 不会出现在 wanted 中, 用法如下:
+//	users := map[string]model.User{}
+//	err := cache.Ctx(ctx).Many([]string{"user:1", "user:2"}, users)
+//redis 驱动使用一次 MGET 读取
+func (c *ContextCache) Many(keys []string, wanted interface{}) error {
+	m := reflect.ValueOf(wanted)
+	if m.Kind() == reflect.Ptr {
+		if m.IsNil() {
+			return errors.New("wanted 不能为 nil")
+		}
+		m = m.Elem()
+		if m.Kind() == reflect.Map && m.IsNil() {
+			m.Set(reflect.MakeMap(m.Type()))
+		}
+	}
+	if m.Kind() != reflect.Map || m.Type().Key().Kind() != reflect.String || m.IsNil() {
+		return errors.New("wanted 必须为已初始化的 map[string]T 或其地址")
+	}
+
+	values, err := c.driver.ManyContext(c.ctx, keys)
+	if err != nil {
+		return err
+	}
+	for key, value := range values {
+		elem := reflect.New(m.Type().Elem())
+		if err := c.serializer.Unmarshal([]byte(value), elem.Interface()); err != nil {
+			return err
+		}
+		m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), elem.Elem())
+	}
+	return nil
+}
+
+//SetMany 批量写入缓存, redis 驱动使用 pipeline 一次提交
+func (c *ContextCache) SetMany(values map[string]interface{}, expireTime time.Duration) error {
+	encoded := make(map[string]string, len(values))
+	for key, obj := range values {
+		b, err := c.serializer.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		encoded[key] = string(b)
+	}
+	return c.driver.SetManyContext(c.ctx, encoded, expireTime)
+}
+
+//Add 缓存不存在时才写入, 返回是否写入; redis 驱动下为原子操作(SET NX)
+func (c *ContextCache) Add(key string, obj interface{}, expireTime time.Duration) (bool, error) {
+	b, err := c.serializer.Marshal(obj)
+	if err != nil {
+		return false, err
+	}
+	return c.driver.AddContext(c.ctx, key, string(b), expireTime)
+}
+
+//Pull 读取缓存并删除, 结果解析到 wanted(需传地址), 返回缓存是否存在
+func (c *ContextCache) Pull(key string, wanted interface{}) (bool, error) {
+	value, err := c.driver.PullContext(c.ctx, key)
+	if err == ErrMiss {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, c.serializer.Unmarshal([]byte(value), wanted)
+}
+
+//GetAs 读取缓存并解析到 wanted(需传地址), 返回缓存是否存在, 用法如下:
+//	user := model.User{}
+//	ok, err := cache.GetAs("user:1", &user)
+//与 GetObject 不同, 驱动故障和解析失败都会返回错误
+func GetAs(key string, wanted interface{}) (bool, error) {
+	return Ctx(context.Background()).GetAs(key, wanted)
+}
+
+//Many 批量读取缓存, wanted 为 map[string]T 或其地址
+func Many(keys []string, wanted interface{}) error {
+	return Ctx(context.Background()).Many(keys, wanted)
+}
+
+//SetMany 批量写入缓存
+func SetMany(values map[string]interface{}, expireTime time.Duration) error {
+	return Ctx(context.Background()).SetMany(values, expireTime)
+}
+
+//Add 缓存不存在时才写入, 返回是否写入
+func Add(key string, obj interface{}, expireTime time.Duration) (bool, error) {
+	return Ctx(context.Background()).Add(key, obj, expireTime)
+}
+
+//Pull 读取缓存并删除, 返回缓存是否存在
+func Pull(key string, wanted interface{}) (bool, error) {
+	return Ctx(context.Background()).Pull(key, wanted)
+}
@@ -140,3 +140,8 @@ func GetStringMapString(path string) map[string]string {
 func GetStringSlice(path string)[]string {
 	return viper.GetStringSlice(path)
 }
+
+//GetStringMap 获取 map 类型的配置信息
+func GetStringMap(path string) map[string]interface{} {
+	return viper.GetStringMap(path)
+}
@@ -72,6 +72,15 @@ func GenerateToken(customClaims interface{}, expire time.Duration) string {
 
 //VerifyToken 用来验证token是否合法, 如果合法,则返回用户自定义数据,否则返回error
 func VerifyToken(token string) (customClaims interface{}, err error)  {
+	payload, err := VerifyPayload(token)
+	if err != nil {
+		return nil, err
+	}
+	return payload.CustomClaims,nil
+}
+
+//VerifyPayload 与 VerifyToken 相同, 但返回完整的 PayLoad, 便于读取 sub、jti 等标准字段
+func VerifyPayload(token string) (*PayLoad, error) {
 	payload, header, err := parseToken(token)
 	if err != nil {
 		return nil, err
@@ -82,7 +91,7 @@ func VerifyToken(token string) (customClaims interface{}, err error)  {
 		return nil, fmt.Errorf("token 无效")
 	}
 
-	return payload.CustomClaims,nil
+	return payload, nil
 }
 
 //RefreshToken 用来刷新token
@@ -0,0 +1,139 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+//AdaptiveLimiter 自适应并发限制, 根据观测到的延迟动态调整并发上限, 超过上限的请求直接拒绝(降级)。
+//调整方式参考 TCP Vegas: 以观测到的最小延迟作为无负载延迟, 估算排队中的请求数
+//	queue = limit * (1 - minRtt / rtt)
+//排队数较少时加性增加上限, 较多时减小上限; 请求失败或超时则按比例收缩(AIMD 中的乘性减少)
+type AdaptiveLimiter struct {
+	mux        sync.Mutex
+	limit      float64
+	minLimit   float64
+	maxLimit   float64
+	inflight   int
+	minRtt     time.Duration
+	samples    int
+	probeEvery int
+	timeout    time.Duration
+	backoff    float64
+}
+
+//AdaptiveOption 自适应限流的可选参数
+type AdaptiveOption func(a *AdaptiveLimiter)
+
+//WithLimits 设置初始、最小与最大的并发上限, 缺省为 20、5、1000
+func WithLimits(initial, min, max int) AdaptiveOption {
+	return func(a *AdaptiveLimiter) {
+		a.limit, a.minLimit, a.maxLimit = float64(initial), float64(min), float64(max)
+	}
+}
+
+//WithTimeout 设置请求超时时间, 处理时间超过 timeout 的请求视为失败, 缺省为 5 秒
+func WithTimeout(timeout time.Duration) AdaptiveOption {
+	return func(a *AdaptiveLimiter) {
+		a.timeout = timeout
+	}
+}
+
+//WithBackoff 设置请求失败时并发上限的收缩比例, 缺省为 0.9
+func WithBackoff(ratio float64) AdaptiveOption {
+	return func(a *AdaptiveLimiter) {
+		a.backoff = ratio
+	}
+}
+
+//NewAdaptiveLimiter 实例化自适应并发限制
+func NewAdaptiveLimiter(options ...AdaptiveOption) *AdaptiveLimiter {
+	a := &AdaptiveLimiter{
+		limit:      20,
+		minLimit:   5,
+		maxLimit:   1000,
+		probeEvery: 1000,
+		timeout:    5 * time.Second,
+		backoff:    0.9,
+	}
+	for _, option := range options {
+		option(a)
+	}
+	a.limit = math.Min(math.Max(a.limit, a.minLimit), a.maxLimit)
+	return a
+}
+
+//Acquire 获取一个并发名额, 正在处理的请求数达到当前上限时返回 ErrOverloaded
+func (a *AdaptiveLimiter) Acquire(ctx context.Context) (Release, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	a.mux.Lock()
+	if a.inflight >= int(a.limit) {
+		a.mux.Unlock()
+		return nil, ErrOverloaded
+	}
+	a.inflight++
+	inflight := a.inflight
+	a.mux.Unlock()
+
+	start := time.Now()
+	return func(ok bool) {
+		a.sample(time.Since(start), inflight, ok)
+	}, nil
+}
+
+//sample 根据单个请求的处理结果调整并发上限
+func (a *AdaptiveLimiter) sample(rtt time.Duration, inflight int, ok bool) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	a.inflight--
+
+	if !ok || rtt > a.timeout {
+		a.setLimit(a.limit * a.backoff)
+		return
+	}
+
+	//定期用当前延迟重置无负载延迟, 避免下游恢复或变慢后一直沿用过时的最小值
+	a.samples++
+	if a.minRtt == 0 || rtt < a.minRtt || a.samples%a.probeEvery == 0 {
+		a.minRtt = rtt
+	}
+	if rtt <= 0 {
+		return
+	}
+
+	queue := a.limit * (1 - float64(a.minRtt)/float64(rtt))
+	step := math.Max(1, math.Log10(a.limit))
+	switch {
+	case queue <= 3*step:
+		//请求量不足以用满上限时, 上限无法被验证, 不再继续增加
+		if float64(inflight)*2 >= a.limit {
+			a.setLimit(a.limit + step)
+		}
+	case queue > 6*step:
+		a.setLimit(a.limit - step)
+	}
+}
+
+//setLimit 在最小与最大值之间设置并发上限
+func (a *AdaptiveLimiter) setLimit(limit float64) {
+	a.limit = math.Min(math.Max(limit, a.minLimit), a.maxLimit)
+}
+
+//Limit 当前的并发上限
+func (a *AdaptiveLimiter) Limit() int {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return int(a.limit)
+}
+
+//InFlight 正在处理的请求数
+func (a *AdaptiveLimiter) InFlight() int {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.inflight
+}
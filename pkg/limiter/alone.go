@@ -1,53 +1,131 @@
 package limiter
 
 import (
-	"errors"
-	"golang.org/x/time/rate"
+	"container/list"
+	"gin-api/pkg/config"
+	"hash/fnv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// 创建一个自定义visitor结构体，包含每个访问者的限流器和最后一次访问时间。
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+//AloneBucket 单机版限流器, 状态按 key 分片存放, 每个分片是一个容量有限的 LRU,
+//超出容量时淘汰最久未访问的 key, 防止大量伪造 ip 的请求撑爆内存
+type AloneBucket struct {
+	shards   []*aloneShard
+	shardNum int
+	maxKeys  int
+	ttl      time.Duration
+	interval time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	evictions  uint64
+	rejections uint64
 }
 
-//AloneBucket 单机版限流器
-type AloneBucket struct {
-	visitors map[string]*visitor
-	mux      sync.Mutex
+//aloneShard 单个分片
+type aloneShard struct {
+	mux     sync.Mutex
+	states  map[string]*list.Element
+	lru     *list.List
+	maxKeys int
 }
 
-var (
-	alone    *AloneBucket
-	once     sync.Once
-)
+//aloneEntry LRU 中的元素
+type aloneEntry struct {
+	key      string
+	state    *localState
+	accessAt time.Time
+}
 
-//NewAloneBucket 实例化单机版限流器
-func NewAloneBucket() *AloneBucket {
-	once.Do(func() {
-		alone = &AloneBucket{
-			visitors: make(map[string]*visitor),
-		}
-		//回收内存
-		go gc()
-	})
-	return alone
+//Stats 单机版限流器的统计数据
+type Stats struct {
+	//Keys 当前保存的 key 数量(多档规则每档各算一个)
+	Keys int
+	//Evictions 因超出容量被淘汰的 key 数量
+	Evictions uint64
+	//Rejections 被拒绝的请求数
+	Rejections uint64
 }
 
-//gc 将长时间没有访问的对象删除
-func gc() {
-	ticker := time.NewTicker(time.Minute)
-	for range ticker.C {
-		alone.mux.Lock()
-		for key, v := range alone.visitors {
-			if time.Since(v.lastSeen) > 3 * time.Minute {
-				delete(alone.visitors, key)
-			}
+//AloneOption 单机版限流器的可选参数
+type AloneOption func(a *AloneBucket)
+
+//WithMaxKeys 设置最多保存的 key 数量, 缺省取配置 limiter.local.max_keys
+func WithMaxKeys(n int) AloneOption {
+	return func(a *AloneBucket) {
+		a.maxKeys = n
+	}
+}
+
+//WithShards 设置分片数量, 分片越多锁竞争越小, 缺省取配置 limiter.local.shards
+func WithShards(n int) AloneOption {
+	return func(a *AloneBucket) {
+		a.shardNum = n
+	}
+}
+
+//WithTTL 设置 key 的最长闲置时间, 超过后即使配额尚未恢复也会被清理, 0 表示直到配额恢复(或窗口结束)才清理
+func WithTTL(ttl time.Duration) AloneOption {
+	return func(a *AloneBucket) {
+		a.ttl = ttl
+	}
+}
+
+//WithJanitor 设置清理过期 key 的间隔, 缺省为一分钟
+func WithJanitor(interval time.Duration) AloneOption {
+	return func(a *AloneBucket) {
+		a.interval = interval
+	}
+}
+
+//instances 尚未停止的单机版限流器, 用于汇总统计数据
+var instances sync.Map
+
+//NewAloneBucket 实例化单机版限流器, 每个实例的状态相互独立, 不再使用时应调用 Stop 停止后台清理
+func NewAloneBucket(options ...AloneOption) *AloneBucket {
+	a := &AloneBucket{
+		shardNum: config.GetInt("limiter.local.shards", 16),
+		maxKeys:  config.GetInt("limiter.local.max_keys", 100000),
+		ttl:      time.Duration(config.GetInt("limiter.local.ttl", 0)) * time.Second,
+		interval: time.Minute,
+		stop:     make(chan struct{}),
+	}
+	for _, option := range options {
+		option(a)
+	}
+	if a.shardNum <= 0 {
+		a.shardNum = 1
+	}
+	if a.interval <= 0 {
+		a.interval = time.Minute
+	}
+
+	//容量平均分配到各个分片
+	perShard := a.maxKeys / a.shardNum
+	if perShard <= 0 {
+		perShard = 1
+	}
+	a.shards = make([]*aloneShard, a.shardNum)
+	for i := range a.shards {
+		a.shards[i] = &aloneShard{
+			states:  make(map[string]*list.Element),
+			lru:     list.New(),
+			maxKeys: perShard,
 		}
-		alone.mux.Unlock()
 	}
+
+	instances.Store(a, struct{}{})
+	go a.janitor()
+	return a
+}
+
+//shard 按 key 选择分片, 同一个 key 的多档规则落在同一分片, 可以在一次加锁内完成检测
+func (a *AloneBucket) shard(key string) *aloneShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return a.shards[h.Sum32()%uint32(len(a.shards))]
 }
 
 //Check 检测请求是否超额
@@ -57,30 +135,104 @@ func gc() {
 //	10 reqs/minute: "10-M"
 //	1000 reqs/hour: "1000-H"
 //	2000 reqs/day: "2000-D"
+//	100 reqs/15 minutes: "100/15m"
+// 在 format 前加上算法前缀可选择其他限流算法, 如 "fixed:500-H";
+// 多档规则以逗号分隔, 如 "10-S,500-H", 详见 ParsePolicy
+func (a *AloneBucket) Check(key string, format string) (*Result, error) {
+	s := a.shard(key)
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	now := time.Now()
+	result, err := checkPolicy(format, func(rule Rule) (*Result, error) {
+		return s.get(key+":"+rule.String(), now, a).state.check(rule, now), nil
+	})
+	if err == nil && !result.Allowed {
+		atomic.AddUint64(&a.rejections, 1)
+	}
+	return result, err
+}
 
-func (a *AloneBucket) Check(key string, format string) error {
-	a.mux.Lock()
-	defer a.mux.Unlock()
+//get 取出 key 的状态并移到 LRU 的头部, 不存在时新建, 超出容量时淘汰最久未访问的 key, 调用方需持有锁
+func (s *aloneShard) get(key string, now time.Time, a *AloneBucket) *aloneEntry {
+	if elem, ok := s.states[key]; ok {
+		s.lru.MoveToFront(elem)
+		entry := elem.Value.(*aloneEntry)
+		entry.accessAt = now
+		return entry
+	}
 
-	limit,everyDuration,err := ParseFormat(format)
-	if err != nil {
-		return err
+	entry := &aloneEntry{key: key, state: &localState{}, accessAt: now}
+	s.states[key] = s.lru.PushFront(entry)
+	for s.lru.Len() > s.maxKeys {
+		s.remove(s.lru.Back())
+		atomic.AddUint64(&a.evictions, 1)
 	}
+	return entry
+}
 
-	var limiter *rate.Limiter
+//remove 删除 LRU 中的元素, 调用方需持有锁
+func (s *aloneShard) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.states, elem.Value.(*aloneEntry).key)
+}
 
-	v, exists := a.visitors[key]
-	if !exists {
-		limiter = rate.NewLimiter(rate.Every(everyDuration), limit)
-		// 在创建新访问者时, 记录当前时间
-		a.visitors[key] = &visitor{limiter, time.Now()}
-	} else {
-		v.lastSeen = time.Now()
-		limiter = v.limiter
+//janitor 定期清理过期(令牌桶已填满、窗口已结束或闲置超过 ttl)的 key, 每次只锁一个分片
+func (a *AloneBucket) janitor() {
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case now := <-ticker.C:
+			for _, s := range a.shards {
+				s.mux.Lock()
+				for elem := s.lru.Back(); elem != nil; {
+					prev := elem.Prev()
+					entry := elem.Value.(*aloneEntry)
+					if now.After(entry.state.expireAt) || (a.ttl > 0 && now.Sub(entry.accessAt) > a.ttl) {
+						s.remove(elem)
+					}
+					elem = prev
+				}
+				s.mux.Unlock()
+			}
+		}
 	}
+}
+
+//Stop 停止后台清理, 停止后仍可继续使用, 但过期的 key 只会在超出容量时被淘汰
+func (a *AloneBucket) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+		instances.Delete(a)
+	})
+}
 
-	if limiter.Allow() == false {
-		return errors.New("访问太频繁")
+//Stats 返回统计数据
+func (a *AloneBucket) Stats() Stats {
+	stats := Stats{
+		Evictions:  atomic.LoadUint64(&a.evictions),
+		Rejections: atomic.LoadUint64(&a.rejections),
+	}
+	for _, s := range a.shards {
+		s.mux.Lock()
+		stats.Keys += s.lru.Len()
+		s.mux.Unlock()
 	}
-	return nil
+	return stats
+}
+
+//AloneStats 汇总所有未停止的单机版限流器的统计数据
+func AloneStats() Stats {
+	var total Stats
+	instances.Range(func(key, _ interface{}) bool {
+		stats := key.(*AloneBucket).Stats()
+		total.Keys += stats.Keys
+		total.Evictions += stats.Evictions
+		total.Rejections += stats.Rejections
+		return true
+	})
+	return total
 }
@@ -0,0 +1,129 @@
+package limiter
+
+import (
+	"math"
+	"time"
+)
+
+//localState 单机版限流器在单个 key 上的状态
+type localState struct {
+	tokens   float64 //剩余令牌数(令牌桶)
+	last     int64   //上次放入令牌的时间, 毫秒(令牌桶)
+	window   int64   //当前窗口的序号
+	curr     int     //当前窗口的计数
+	prev     int     //上一个窗口的计数(滑动窗口计数)
+	log      []int64 //窗口内每个请求的时间, 毫秒(滑动窗口日志)
+	tat      float64 //理论到达时间, 毫秒(GCRA)
+	expireAt time.Time
+}
+
+//check 根据规则中的算法检测请求是否超额, 调用方需持有锁
+func (s *localState) check(rule Rule, t time.Time) *Result {
+	now := t.UnixNano() / 1e6
+	period := int64(rule.Period / time.Millisecond)
+
+	var allowed bool
+	var remaining int
+	var wait int64
+	switch rule.Algorithm {
+	case FixedWindow:
+		allowed, remaining, wait = s.fixed(now+int64(windowOffset()/time.Millisecond), period, rule.Limit)
+	case SlidingWindow:
+		allowed, remaining, wait = s.sliding(now, period, rule.Limit)
+	case SlidingLog:
+		allowed, remaining, wait = s.slidingLog(now, period, rule.Limit)
+	case GCRA:
+		allowed, remaining, wait = s.gcra(now, period, rule.Limit, rule.Capacity())
+	default:
+		allowed, remaining, wait = s.tokenBucket(now, period, rule.Limit, rule.Capacity())
+	}
+
+	//状态至少保留到窗口结束(或令牌桶填满), 再多保留一个周期用于滑动窗口的估算
+	s.expireAt = t.Add(time.Duration(wait)*time.Millisecond + rule.Period)
+	return newResult(rule.Capacity(), allowed, remaining, wait)
+}
+
+//tokenBucket 令牌桶, 速率为 limit/period, 容量为 capacity
+func (s *localState) tokenBucket(now, period int64, limit, capacity int) (bool, int, int64) {
+	rate := float64(limit) / float64(period)
+	if s.last == 0 {
+		s.tokens, s.last = float64(capacity), now
+	}
+	s.tokens = math.Min(float64(capacity), s.tokens+float64(now-s.last)*rate)
+	s.last = now
+
+	if s.tokens < 1 {
+		return false, 0, int64(math.Ceil((1 - s.tokens) / rate))
+	}
+	s.tokens--
+	return true, int(s.tokens), int64(math.Ceil((float64(capacity) - s.tokens) / rate))
+}
+
+//fixed 固定窗口, now 已按时区偏移
+func (s *localState) fixed(now, period int64, limit int) (bool, int, int64) {
+	window := now / period
+	reset := (window+1)*period - now
+	if window != s.window {
+		s.window, s.curr = window, 0
+	}
+	if s.curr >= limit {
+		return false, 0, reset
+	}
+	s.curr++
+	return true, limit - s.curr, reset
+}
+
+//sliding 滑动窗口计数: 估算值 = 上个窗口计数 * 上个窗口在滑动窗口中的占比 + 当前窗口计数
+func (s *localState) sliding(now, period int64, limit int) (bool, int, int64) {
+	window := now / period
+	switch {
+	case window == s.window+1:
+		s.prev, s.curr = s.curr, 0
+	case window != s.window:
+		s.prev, s.curr = 0, 0
+	}
+	s.window = window
+
+	elapsed := now - window*period
+	estimated := float64(s.prev)*float64(period-elapsed)/float64(period) + float64(s.curr)
+	if estimated+1 > float64(limit) {
+		wait := period - elapsed
+		if s.prev > 0 && s.curr+1 <= limit {
+			wait = int64(math.Ceil(float64(period)*(1-float64(limit-s.curr-1)/float64(s.prev)))) - elapsed
+		}
+		if wait < 1 {
+			wait = 1
+		}
+		return false, 0, wait
+	}
+	s.curr++
+	return true, int(math.Max(0, math.Floor(float64(limit)-estimated-1))), period - elapsed
+}
+
+//slidingLog 滑动窗口日志: 丢弃窗口外的记录后判断窗口内的请求数
+func (s *localState) slidingLog(now, period int64, limit int) (bool, int, int64) {
+	i := 0
+	for i < len(s.log) && s.log[i] <= now-period {
+		i++
+	}
+	s.log = s.log[i:]
+	if len(s.log) >= limit {
+		return false, 0, s.log[0] + period - now
+	}
+	s.log = append(s.log, now)
+	return true, limit - len(s.log), period
+}
+
+//gcra 每个请求将理论到达时间(TAT)推后一个发送间隔, TAT 超出当前时间的部分不能大于容忍度,
+//容忍度由允许的突发请求数 burst 决定
+func (s *localState) gcra(now, period int64, limit, burst int) (bool, int, int64) {
+	interval := float64(period) / float64(limit)
+	tolerance := interval * float64(burst-1)
+	tat := math.Max(s.tat, float64(now))
+	if tat-float64(now) > tolerance {
+		return false, 0, int64(math.Ceil(tat - float64(now) - tolerance))
+	}
+	s.tat = tat + interval
+	remaining := int(math.Floor((tolerance - (s.tat - float64(now)) + interval) / interval))
+	return true, remaining, int64(math.Ceil(s.tat - float64(now)))
+}
@@ -0,0 +1,89 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	//ErrConcurrencyFull 并发数已满且无法排队(或排队超时)
+	ErrConcurrencyFull = errors.New("并发请求数已满")
+	//ErrOverloaded 自适应限流判断系统已过载
+	ErrOverloaded = errors.New("系统繁忙")
+)
+
+//Release 释放并发名额, ok 为 false 表示请求失败(如 5xx), 自适应限流会据此收缩并发上限
+type Release func(ok bool)
+
+//ConcurrencyIfac 并发数限制器, 与按频率限流的 LimiterIfac 互补:
+//频率限流控制单位时间的请求数, 并发限制控制同一时刻正在处理的请求数, 防止下游变慢时协程堆积
+type ConcurrencyIfac interface {
+	//Acquire 获取一个并发名额, 成功时必须在请求处理完后调用返回的 Release
+	Acquire(ctx context.Context) (Release, error)
+}
+
+//Bulkhead 舱壁隔离, 同时处理的请求数达到 max 后, 新请求最多排队 timeout, 排队的请求数不超过 queue
+type Bulkhead struct {
+	slots   chan struct{}
+	queue   int64
+	waiting int64
+	timeout time.Duration
+}
+
+//NewBulkhead 实例化舱壁, queue 为 0 或 timeout 为 0 时不排队, 名额已满直接拒绝
+func NewBulkhead(max int, queue int, timeout time.Duration) *Bulkhead {
+	if max <= 0 {
+		max = 1
+	}
+	return &Bulkhead{
+		slots:   make(chan struct{}, max),
+		queue:   int64(queue),
+		timeout: timeout,
+	}
+}
+
+//Acquire 获取一个并发名额
+func (b *Bulkhead) Acquire(ctx context.Context) (Release, error) {
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	default:
+	}
+
+	if b.timeout <= 0 {
+		return nil, ErrConcurrencyFull
+	}
+	if atomic.AddInt64(&b.waiting, 1) > b.queue {
+		atomic.AddInt64(&b.waiting, -1)
+		return nil, ErrConcurrencyFull
+	}
+	defer atomic.AddInt64(&b.waiting, -1)
+
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return b.release, nil
+	case <-timer.C:
+		return nil, ErrConcurrencyFull
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//release 归还名额
+func (b *Bulkhead) release(bool) {
+	<-b.slots
+}
+
+//InFlight 正在处理的请求数
+func (b *Bulkhead) InFlight() int {
+	return len(b.slots)
+}
+
+//Waiting 正在排队的请求数
+func (b *Bulkhead) Waiting() int {
+	return int(atomic.LoadInt64(&b.waiting))
+}
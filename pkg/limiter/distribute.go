@@ -1,32 +1,95 @@
 package limiter
 
 import (
-	"errors"
-	"github.com/spf13/cast"
-	"math"
 	"gin-api/pkg/config"
 	"gin-api/pkg/redis"
-	"sync"
+	goredis "github.com/go-redis/redis/v8"
+	"strconv"
 	"time"
 )
 
+//tokenBucketScript 令牌桶的原子实现, 以 redis 服务器时间(毫秒)为准, 避免多实例之间的时钟误差
+//	KEYS[1] 令牌桶的 key
+//	ARGV[1] 每放入一个令牌间隔的毫秒数(可以为小数, 以支持 "3-S" 这类不能整除的速率)
+//	ARGV[2] 桶的容量, 即允许的最大突发请求数
+//	ARGV[3] 本次请求需要的令牌数
+//返回 {是否允许(1/0), 剩余令牌数, 允许时为桶填满需要的毫秒数, 拒绝时为需要等待的毫秒数}
+var tokenBucketScript = goredis.NewScript(`
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+
+local interval = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'last_refresh')
+local tokens = tonumber(bucket[1])
+local last = tonumber(bucket[2])
+if tokens == nil or last == nil then
+	tokens = capacity
+	last = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - last) / interval)
+
+local allowed = 0
+local wait = 0
+if tokens >= requested then
+	tokens = tokens - requested
+	allowed = 1
+	wait = math.ceil((capacity - tokens) * interval)
+else
+	wait = math.ceil((requested - tokens) * interval)
+end
+
+redis.call('HMSET', KEYS[1], 'tokens', tokens, 'last_refresh', now)
+-- 桶被填满后 key 就没有存在的必要了
+redis.call('PEXPIRE', KEYS[1], math.ceil(capacity * interval) + 1000)
+
+return {allowed, math.floor(tokens), wait}
+`)
+
 //DistributeBucket 分布式限流
 type DistributeBucket struct {
-	redis      *redis.RedisClient
-	keyPrefix  string
-	lockPrefix string
-	rate       int64 //固定的token放入速率, r/s
-	capacity   int64 //桶的容量
-	lock       sync.Mutex
+	redis     *redis.RedisClient
+	keyPrefix string
+	burst     int
+}
+
+//DistributeOption 分布式限流器的可选参数
+type DistributeOption func(d *DistributeBucket)
+
+//WithBurst 设置令牌桶的缺省容量(允许的最大突发请求数), format 中指定了 burst 时以 format 为准,
+//两者都缺省时等于 format 中的 limit
+func WithBurst(burst int) DistributeOption {
+	return func(d *DistributeBucket) {
+		d.burst = burst
+	}
+}
+
+//WithClient 使用指定的 redis 连接, 缺省时使用 redis.Connection("limiter")
+func WithClient(rds *redis.RedisClient) DistributeOption {
+	return func(d *DistributeBucket) {
+		d.redis = rds
+	}
 }
 
 //NewDistributeBucket 实例化一个分布式限流器
-func NewDistributeBucket() *DistributeBucket {
-	return &DistributeBucket{
-		redis:      redis.DefaultClient(),
-		keyPrefix:  config.GetString("app.name") + ":limiter:",
-		lockPrefix: config.GetString("app.name") + ":lock:",
+func NewDistributeBucket(options ...DistributeOption) *DistributeBucket {
+	d := &DistributeBucket{
+		keyPrefix: config.GetString("app.name") + ":limiter:",
+	}
+	for _, option := range options {
+		option(d)
+	}
+	if d.redis == nil {
+		d.redis = redis.Connection("limiter")
 	}
+	return d
 }
 
 //Check 检测请求是否超额
@@ -36,71 +99,35 @@ func NewDistributeBucket() *DistributeBucket {
 //	10 reqs/minute: "10-M"
 //	1000 reqs/hour: "1000-H"
 //	2000 reqs/day: "2000-D"
-func (d *DistributeBucket) Check(key string, format string) error {
-	limit, everyDuration, err := ParseFormat(format)
-	if err != nil {
-		return err
-	}
-
-	//换算成每秒能处理几个,即令牌桶中的速率 rate(r/s)
-	d.rate     = cast.ToInt64(math.Ceil(cast.ToFloat64(limit) / everyDuration.Seconds()))
-	//容量设置为rate的5倍,即可以应对的最高突发性流量
-	d.capacity = 10 * d.rate
-	//多久能填满桶
-	fillTime := math.Floor(float64(d.capacity / d.rate))
-	//key 的过期时间设置为填满时间的2倍
-	ttl      := time.Duration(2 * fillTime) * time.Second
-
-	//获取分布式锁
-	lockKey := d.lockPrefix + key
-	d.redis.Lock(lockKey, ttl)
-	defer d.redis.ReleaseLock(lockKey)
-
-	//获取令牌
-	fullKey := d.keyPrefix + key
-	data := d.getTokenBucket(fullKey)
-
-	var tokens int64
-	var lastRefresh int64
-	now := time.Now().Unix()
-
-	if len(data) == 0 { // 没有值说明是第一次进入
-		tokens = d.capacity
-		lastRefresh = now
-	} else { //根据速率和时间差,判断应放入多少令牌
-		tokens = cast.ToInt64(tokens)
-		lastRefresh = cast.ToInt64(data["last_refresh"])
-		//重置令牌数量
-		tokens = tokens + (now-lastRefresh)*d.rate
-		if tokens > d.capacity {
-			tokens = d.capacity
+//	100 reqs/15 minutes: "100/15m"
+// 在 format 前加上算法前缀可选择其他限流算法, 如 "fixed:500-H";
+// 多档规则以逗号分隔, 如 "10-S,500-H", 详见 ParsePolicy
+func (d *DistributeBucket) Check(key string, format string) (*Result, error) {
+	return checkPolicy(format, func(rule Rule) (*Result, error) {
+		if rule.Algorithm != TokenBucket {
+			return d.checkWindow(key, rule)
 		}
-		//重置令牌更新时间
-		lastRefresh = now
-	}
+		return d.checkToken(key, rule)
+	})
+}
 
-	//本次请求token数量是否足够
-	if tokens > 0 {
-		//允许本次请求,并计算token余量
-		tokens = tokens - 1
-		//更新令牌桶
-		d.setTokenBucket(fullKey, tokens, lastRefresh, ttl)
-		return nil
-	} else {
-		//没有令牌,则拒绝
-		return errors.New("访问太频繁")
+//checkToken 使用令牌桶检测请求是否超额
+func (d *DistributeBucket) checkToken(key string, rule Rule) (*Result, error) {
+	if rule.Burst == 0 {
+		rule.Burst = d.burst
 	}
-}
 
-//getTokenBucket 获取令牌桶
-func (d *DistributeBucket) getTokenBucket(fullKey string)map[string]string{
-	return d.redis.HGetAll(fullKey)
-}
+	//放入令牌的间隔(毫秒), 传间隔而不是速率, 避免 "1-H" 这类速率的浮点误差使等待时间多出 1 毫秒;
+	//间隔与容量均为本次调用的局部变量, 多协程共用一个限流器也不会相互影响
+	interval := float64(rule.Period/time.Millisecond) / float64(rule.Limit)
+	capacity := rule.Capacity()
 
-//setTokenBucket 更新令牌桶
-func (d *DistributeBucket) setTokenBucket(key string, tokens, lastRefresh int64, expire time.Duration) {
-	d.redis.HSet(key, "tokens", tokens, "last_refresh", lastRefresh)
-	d.redis.Expire(key, expire)
+	res, err := tokenBucketScript.Run(d.redis.Ctx, d.redis.Client, []string{d.keyPrefix + key + ":" + rule.String()},
+		strconv.FormatFloat(interval, 'f', -1, 64), capacity, 1).Slice()
+	if err != nil {
+		return nil, err
+	}
+	return scriptResult(capacity, res), nil
 }
 
 //HealthCheck 检查分布式限流器,用来在单机和分布式之间切换
@@ -0,0 +1,222 @@
+package limiter
+
+import (
+	"context"
+	"gin-api/pkg/redis"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+//newTestBucket 基于 miniredis 创建分布式限流器, 脚本中 TIME 返回的时间固定在整点, 只有调用 advance 才会推移
+func newTestBucket(t *testing.T) (*DistributeBucket, func(d time.Duration)) {
+	s := miniredis.RunT(t)
+	now := time.Date(2021, 10, 1, 8, 0, 0, 0, time.UTC)
+	s.SetTime(now)
+	advance := func(d time.Duration) {
+		now = now.Add(d)
+		s.SetTime(now)
+	}
+
+	client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewDistributeBucket(WithClient(&redis.RedisClient{Client: client, Ctx: context.Background()})), advance
+}
+
+//mustCheck 检测一次请求, 出错时终止测试
+func mustCheck(t *testing.T, d *DistributeBucket, key string, format string) *Result {
+	t.Helper()
+	result, err := d.Check(key, format)
+	if err != nil {
+		t.Fatalf("Check(%q, %q): %v", key, format, err)
+	}
+	return result
+}
+
+//expectAllowed 连续检测 n 次, 全部应被允许
+func expectAllowed(t *testing.T, d *DistributeBucket, key string, format string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if result := mustCheck(t, d, key, format); !result.Allowed {
+			t.Fatalf("%q 第 %d 次请求被拒绝: %+v", format, i+1, result)
+		}
+	}
+}
+
+//expectDenied 检测一次, 应被拒绝且 RetryAfter 等于 retryAfter
+func expectDenied(t *testing.T, d *DistributeBucket, key string, format string, retryAfter time.Duration) {
+	t.Helper()
+	result := mustCheck(t, d, key, format)
+	if result.Allowed {
+		t.Fatalf("%q 超额的请求被允许: %+v", format, result)
+	}
+	if result.Remaining != 0 || result.RetryAfter != retryAfter {
+		t.Fatalf("%q 拒绝时 Remaining=%d RetryAfter=%v, 期望 0 与 %v", format, result.Remaining, result.RetryAfter, retryAfter)
+	}
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	d, _ := newTestBucket(t)
+
+	for i := 0; i < 5; i++ {
+		result := mustCheck(t, d, "burst", "10-S burst 5")
+		if !result.Allowed || result.Limit != 5 || result.Remaining != 4-i {
+			t.Fatalf("第 %d 次请求: %+v", i+1, result)
+		}
+	}
+	//每秒 10 个令牌, 下一个令牌需要 100 毫秒
+	expectDenied(t, d, "burst", "10-S burst 5", 100*time.Millisecond)
+
+	//WithBurst 在 format 未指定 burst 时生效
+	d.burst = 3
+	expectAllowed(t, d, "option", "10-S", 3)
+	expectDenied(t, d, "option", "10-S", 100*time.Millisecond)
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	d, advance := newTestBucket(t)
+
+	expectAllowed(t, d, "refill", "10-S", 10)
+	expectDenied(t, d, "refill", "10-S", 100*time.Millisecond)
+
+	advance(100*time.Millisecond)
+	expectAllowed(t, d, "refill", "10-S", 1)
+	expectDenied(t, d, "refill", "10-S", 100*time.Millisecond)
+
+	//桶最多填满到容量, 空闲再久也只能突发 10 次
+	advance(time.Hour)
+	expectAllowed(t, d, "refill", "10-S", 10)
+	expectDenied(t, d, "refill", "10-S", 100*time.Millisecond)
+}
+
+func TestTokenBucketSubSecondRate(t *testing.T) {
+	d, advance := newTestBucket(t)
+
+	//每分钟 2 次, 即每毫秒放入的令牌数远小于 1
+	expectAllowed(t, d, "slow", "2-M", 2)
+	expectDenied(t, d, "slow", "2-M", 30*time.Second)
+
+	advance(29*time.Second)
+	expectDenied(t, d, "slow", "2-M", time.Second)
+	advance(time.Second)
+	expectAllowed(t, d, "slow", "2-M", 1)
+
+	//每小时 1 次
+	expectAllowed(t, d, "hourly", "1-H", 1)
+	expectDenied(t, d, "hourly", "1-H", time.Hour)
+}
+
+func TestFixedWindow(t *testing.T) {
+	d, advance := newTestBucket(t)
+	advance(10*time.Second)
+
+	for i := 0; i < 3; i++ {
+		result := mustCheck(t, d, "fixed", "fixed:3-M")
+		if !result.Allowed || result.Remaining != 2-i || result.Reset != 50*time.Second {
+			t.Fatalf("第 %d 次请求: %+v", i+1, result)
+		}
+	}
+	//窗口与整分钟对齐, 需要等到下一分钟
+	expectDenied(t, d, "fixed", "fixed:3-M", 50*time.Second)
+
+	advance(50*time.Second)
+	expectAllowed(t, d, "fixed", "fixed:3-M", 3)
+	expectDenied(t, d, "fixed", "fixed:3-M", time.Minute)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	d, advance := newTestBucket(t)
+
+	expectAllowed(t, d, "sliding", "sliding:4-M", 4)
+	expectDenied(t, d, "sliding", "sliding:4-M", time.Minute)
+
+	//下一个窗口过半时, 上一个窗口的 4 次按一半计入, 还可以再请求 2 次
+	advance(90*time.Second)
+	expectAllowed(t, d, "sliding", "sliding:4-M", 2)
+	result := mustCheck(t, d, "sliding", "sliding:4-M")
+	if result.Allowed {
+		t.Fatalf("超额的请求被允许: %+v", result)
+	}
+
+	//两个窗口之后计数清零
+	advance(2*time.Minute)
+	expectAllowed(t, d, "sliding", "sliding:4-M", 4)
+}
+
+func TestSlidingLog(t *testing.T) {
+	d, advance := newTestBucket(t)
+
+	expectAllowed(t, d, "log", "sliding_log:3-M", 1)
+	advance(20*time.Second)
+	expectAllowed(t, d, "log", "sliding_log:3-M", 2)
+	//最早的请求 40 秒后移出窗口
+	expectDenied(t, d, "log", "sliding_log:3-M", 40*time.Second)
+
+	advance(40*time.Second)
+	expectAllowed(t, d, "log", "sliding_log:3-M", 1)
+	expectDenied(t, d, "log", "sliding_log:3-M", 20*time.Second)
+}
+
+func TestGCRA(t *testing.T) {
+	d, advance := newTestBucket(t)
+
+	//每 100 毫秒放行一次, 允许 3 次突发
+	expectAllowed(t, d, "gcra", "gcra:10-S burst 3", 3)
+	expectDenied(t, d, "gcra", "gcra:10-S burst 3", 100*time.Millisecond)
+
+	advance(100*time.Millisecond)
+	expectAllowed(t, d, "gcra", "gcra:10-S burst 3", 1)
+	expectDenied(t, d, "gcra", "gcra:10-S burst 3", 100*time.Millisecond)
+
+	//不指定 burst 时容量等于 limit
+	expectAllowed(t, d, "even", "gcra:2-S", 2)
+	expectDenied(t, d, "even", "gcra:2-S", 500*time.Millisecond)
+}
+
+func TestMultiTier(t *testing.T) {
+	d, advance := newTestBucket(t)
+
+	//每秒 5 次, 同时每分钟 8 次
+	expectAllowed(t, d, "tier", "5-S,fixed:8-M", 5)
+	expectDenied(t, d, "tier", "5-S,fixed:8-M", 200*time.Millisecond)
+
+	advance(time.Second)
+	expectAllowed(t, d, "tier", "5-S,fixed:8-M", 3)
+	expectDenied(t, d, "tier", "5-S,fixed:8-M", 59*time.Second)
+}
+
+func TestConcurrentCallers(t *testing.T) {
+	for _, format := range []string{"20-M", "fixed:20-M", "sliding:20-M", "sliding_log:20-M", "gcra:20-M"} {
+		t.Run(format, func(t *testing.T) {
+			d, _ := newTestBucket(t)
+
+			var allowed int64
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 10; j++ {
+						result, err := d.Check("shared", format)
+						if err != nil {
+							t.Error(err)
+							return
+						}
+						if result.Allowed {
+							atomic.AddInt64(&allowed, 1)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if allowed != 20 {
+				t.Fatalf("100 个并发请求中放行了 %d 个, 期望 20 个", allowed)
+			}
+		})
+	}
+}
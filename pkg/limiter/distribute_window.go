@@ -0,0 +1,151 @@
+package limiter
+
+import (
+	"errors"
+	"gin-api/pkg/helpers"
+	goredis "github.com/go-redis/redis/v8"
+	"strconv"
+	"time"
+)
+
+//以下脚本均以 redis 服务器时间(毫秒)为准, 返回 {是否允许(1/0), 剩余次数, 需要等待或距窗口重置的毫秒数}
+
+//nowScript 获取 redis 服务器的毫秒时间, 供各脚本复用
+const nowScript = `
+if redis.replicate_commands then
+	redis.replicate_commands()
+end
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+`
+
+//fixedWindowScript 固定窗口
+//	ARGV[1] limit, ARGV[2] 窗口长度(毫秒), ARGV[3] 时区偏移(毫秒)
+var fixedWindowScript = goredis.NewScript(nowScript + `
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local offset = tonumber(ARGV[3])
+
+local window = math.floor((now + offset) / period)
+local reset = (window + 1) * period - offset - now
+
+local state = redis.call('HMGET', KEYS[1], 'window', 'count')
+local count = 0
+if tonumber(state[1]) == window then
+	count = tonumber(state[2]) or 0
+end
+
+if count >= limit then
+	return {0, 0, reset}
+end
+
+count = count + 1
+redis.call('HMSET', KEYS[1], 'window', window, 'count', count)
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {1, limit - count, reset}
+`)
+
+//slidingWindowScript 滑动窗口计数
+//	ARGV[1] limit, ARGV[2] 窗口长度(毫秒)
+var slidingWindowScript = goredis.NewScript(nowScript + `
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+
+local window = math.floor(now / period)
+local state = redis.call('HMGET', KEYS[1], 'window', 'curr', 'prev')
+local last = tonumber(state[1])
+local curr = tonumber(state[2]) or 0
+local prev = tonumber(state[3]) or 0
+if last == window - 1 then
+	prev = curr
+	curr = 0
+elseif last ~= window then
+	prev = 0
+	curr = 0
+end
+
+local elapsed = now - window * period
+local estimated = prev * (period - elapsed) / period + curr
+if estimated + 1 > limit then
+	local wait = period - elapsed
+	if prev > 0 and curr + 1 <= limit then
+		wait = math.ceil(period * (1 - (limit - curr - 1) / prev)) - elapsed
+	end
+	redis.call('HMSET', KEYS[1], 'window', window, 'curr', curr, 'prev', prev)
+	redis.call('PEXPIRE', KEYS[1], 2 * period)
+	return {0, 0, math.max(wait, 1)}
+end
+
+curr = curr + 1
+redis.call('HMSET', KEYS[1], 'window', window, 'curr', curr, 'prev', prev)
+redis.call('PEXPIRE', KEYS[1], 2 * period)
+return {1, math.max(0, math.floor(limit - estimated - 1)), period - elapsed}
+`)
+
+//slidingLogScript 滑动窗口日志, 使用有序集合记录窗口内每个请求的时间
+//	ARGV[1] limit, ARGV[2] 窗口长度(毫秒), ARGV[3] 请求的唯一标识
+var slidingLogScript = goredis.NewScript(nowScript + `
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - period)
+local count = redis.call('ZCARD', KEYS[1])
+if count >= limit then
+	local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+	return {0, 0, tonumber(oldest[2]) + period - now}
+end
+
+redis.call('ZADD', KEYS[1], now, now .. '-' .. ARGV[3])
+redis.call('PEXPIRE', KEYS[1], period)
+return {1, limit - count - 1, period}
+`)
+
+//gcraScript 通用信元速率算法
+//	ARGV[1] 发送间隔(毫秒, 可为小数), ARGV[2] 容忍度(毫秒)
+var gcraScript = goredis.NewScript(nowScript + `
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+
+local tat = tonumber(redis.call('GET', KEYS[1])) or now
+if tat < now then
+	tat = now
+end
+
+if tat - now > tolerance then
+	return {0, 0, math.ceil(tat - now - tolerance)}
+end
+
+local newTat = tat + interval
+redis.call('SET', KEYS[1], tostring(newTat), 'PX', math.ceil(newTat - now))
+return {1, math.floor((tolerance - (newTat - now) + interval) / interval), math.ceil(newTat - now)}
+`)
+
+//checkWindow 使用窗口类算法或 GCRA 检测请求是否超额
+func (d *DistributeBucket) checkWindow(key string, rule Rule) (*Result, error) {
+	period := int64(rule.Period / time.Millisecond)
+	fullKey := d.keyPrefix + key + ":" + rule.String()
+
+	var cmd *goredis.Cmd
+	switch rule.Algorithm {
+	case FixedWindow:
+		offset := int64(windowOffset() / time.Millisecond)
+		cmd = fixedWindowScript.Run(d.redis.Ctx, d.redis.Client, []string{fullKey}, rule.Limit, period, offset)
+	case SlidingWindow:
+		cmd = slidingWindowScript.Run(d.redis.Ctx, d.redis.Client, []string{fullKey}, rule.Limit, period)
+	case SlidingLog:
+		cmd = slidingLogScript.Run(d.redis.Ctx, d.redis.Client, []string{fullKey}, rule.Limit, period, helpers.StrUuid(16))
+	case GCRA:
+		interval := float64(period) / float64(rule.Limit)
+		tolerance := interval * float64(rule.Capacity()-1)
+		cmd = gcraScript.Run(d.redis.Ctx, d.redis.Client, []string{fullKey},
+			strconv.FormatFloat(interval, 'f', -1, 64), strconv.FormatFloat(tolerance, 'f', -1, 64))
+	default:
+		return nil, errors.New("不支持的限流算法: " + rule.Algorithm)
+	}
+
+	res, err := cmd.Slice()
+	if err != nil {
+		return nil, err
+	}
+	return scriptResult(rule.Capacity(), res), nil
+}
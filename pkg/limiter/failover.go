@@ -0,0 +1,288 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"gin-api/pkg/config"
+	"gin-api/pkg/helpers"
+	"gin-api/pkg/logger"
+	"gin-api/pkg/redis"
+	goredis "github.com/go-redis/redis/v8"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//heartbeatScript 上报本实例的心跳并返回存活的实例数, 同时作为 redis 的健康检查
+//	KEYS[1] 实例集合, ARGV[1] 实例 id, ARGV[2] 心跳超时(毫秒)
+var heartbeatScript = goredis.NewScript(nowScript + `
+local ttl = tonumber(ARGV[2])
+redis.call('ZADD', KEYS[1], now, ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - ttl)
+redis.call('PEXPIRE', KEYS[1], ttl)
+return redis.call('ZCARD', KEYS[1])
+`)
+
+//HealthMonitor 在后台定期检查 redis 的可用性, 供分布式限流器决定是否降级为单机版。
+//连续失败 failThreshold 次才判定为不可用, 之后连续成功 recoverThreshold 次才恢复, 避免在网络抖动时来回切换
+type HealthMonitor struct {
+	redis            *redis.RedisClient
+	key              string
+	id               string
+	interval         time.Duration
+	timeout          time.Duration
+	failThreshold    int
+	recoverThreshold int
+
+	mux       sync.Mutex
+	healthy   int32
+	failures  int
+	successes int
+	instances int
+
+	transitions uint64
+	stop        chan struct{}
+	stopOnce    sync.Once
+}
+
+//MonitorStats 健康检查的状态
+type MonitorStats struct {
+	Healthy bool
+	//Transitions 在分布式与单机版之间切换的次数
+	Transitions uint64
+	//Instances 最近一次检查时存活的实例数
+	Instances int
+}
+
+//MonitorOption 健康检查的可选参数
+type MonitorOption func(m *HealthMonitor)
+
+//WithInterval 设置检查间隔与单次检查的超时时间
+func WithInterval(interval, timeout time.Duration) MonitorOption {
+	return func(m *HealthMonitor) {
+		m.interval, m.timeout = interval, timeout
+	}
+}
+
+//WithThreshold 设置判定为不可用所需的连续失败次数, 以及恢复所需的连续成功次数
+func WithThreshold(fail, recover int) MonitorOption {
+	return func(m *HealthMonitor) {
+		m.failThreshold, m.recoverThreshold = fail, recover
+	}
+}
+
+var (
+	defaultMonitor *HealthMonitor
+	monitorOnce    sync.Once
+)
+
+//DefaultMonitor 返回基于 redis.Connection("limiter") 的健康检查, 参数取自配置 limiter.failover
+func DefaultMonitor() *HealthMonitor {
+	monitorOnce.Do(func() {
+		defaultMonitor = NewHealthMonitor(redis.Connection("limiter"),
+			WithInterval(
+				time.Duration(config.GetInt("limiter.failover.interval", 1000))*time.Millisecond,
+				time.Duration(config.GetInt("limiter.failover.timeout", 500))*time.Millisecond,
+			),
+			WithThreshold(
+				config.GetInt("limiter.failover.fail_threshold", 3),
+				config.GetInt("limiter.failover.recover_threshold", 5),
+			),
+		)
+	})
+	return defaultMonitor
+}
+
+//NewHealthMonitor 实例化健康检查并立即开始后台检查, 初始状态为可用
+func NewHealthMonitor(rds *redis.RedisClient, options ...MonitorOption) *HealthMonitor {
+	hostname, _ := os.Hostname()
+	m := &HealthMonitor{
+		redis:            rds,
+		key:              config.GetString("app.name") + ":limiter:instances",
+		id:               fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), helpers.StrUuid(8)),
+		interval:         time.Second,
+		timeout:          500 * time.Millisecond,
+		failThreshold:    3,
+		recoverThreshold: 5,
+		healthy:          1,
+		instances:        1,
+		stop:             make(chan struct{}),
+	}
+	for _, option := range options {
+		option(m)
+	}
+
+	go m.run()
+	return m
+}
+
+//run 定期检查, 直到 Stop
+func (m *HealthMonitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.check()
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//check 上报心跳, 成功即视为 redis 可用
+func (m *HealthMonitor) check() {
+	ctx, cancel := context.WithTimeout(m.redis.Ctx, m.timeout)
+	defer cancel()
+
+	//实例数只在 redis 可用时才能统计, 心跳超时取检查间隔的 3 倍, 容忍偶尔的检查失败
+	ttl := 3 * m.interval / time.Millisecond
+	count, err := heartbeatScript.Run(ctx, m.redis.Client, []string{m.key}, m.id, int64(ttl)).Int()
+	if err == nil {
+		m.mux.Lock()
+		m.instances = count
+		m.mux.Unlock()
+	}
+	m.record(err)
+}
+
+//ReportFailure 限流请求遇到 redis 错误时调用, 与后台检查一起计入连续失败次数
+func (m *HealthMonitor) ReportFailure(err error) {
+	m.record(err)
+}
+
+//record 记录一次检查结果, 达到阈值时切换状态
+func (m *HealthMonitor) record(err error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	healthy := atomic.LoadInt32(&m.healthy) == 1
+	if err != nil {
+		m.failures++
+		m.successes = 0
+		if healthy && m.failures >= m.failThreshold {
+			m.transition(false, err)
+		}
+		return
+	}
+
+	m.successes++
+	m.failures = 0
+	if !healthy && m.successes >= m.recoverThreshold {
+		m.transition(true, nil)
+	}
+}
+
+//transition 切换状态并记录日志, 调用方需持有锁
+func (m *HealthMonitor) transition(healthy bool, err error) {
+	atomic.AddUint64(&m.transitions, 1)
+	if healthy {
+		atomic.StoreInt32(&m.healthy, 1)
+		logger.Log("limiter", "redis 已恢复, 切换回分布式限流")
+		return
+	}
+	atomic.StoreInt32(&m.healthy, 0)
+	logger.Log("limiter", "redis 不可用, 降级为单机版限流: "+err.Error())
+}
+
+//Healthy redis 是否可用
+func (m *HealthMonitor) Healthy() bool {
+	return atomic.LoadInt32(&m.healthy) == 1
+}
+
+//Instances 最近一次 redis 可用时统计到的实例数
+func (m *HealthMonitor) Instances() int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.instances
+}
+
+//Stats 返回健康检查的状态
+func (m *HealthMonitor) Stats() MonitorStats {
+	return MonitorStats{
+		Healthy:     m.Healthy(),
+		Transitions: atomic.LoadUint64(&m.transitions),
+		Instances:   m.Instances(),
+	}
+}
+
+//Stop 停止后台检查
+func (m *HealthMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
+//Failover 分布式限流器, redis 不可用时降级为单机版。
+//是否可用由 HealthMonitor 在后台判断, 检测请求时不会额外访问 redis
+type Failover struct {
+	distribute *DistributeBucket
+	local      *AloneBucket
+	monitor    *HealthMonitor
+	//scale 降级期间是否将单机版的配额按实例数均分, 使所有实例加起来的配额与分布式限流时接近
+	scale  bool
+	scaled sync.Map
+}
+
+//NewFailover 实例化可降级的分布式限流器
+func NewFailover(distribute *DistributeBucket, local *AloneBucket, monitor *HealthMonitor, scale bool) *Failover {
+	return &Failover{
+		distribute: distribute,
+		local:      local,
+		monitor:    monitor,
+		scale:      scale,
+	}
+}
+
+//Check 检测请求是否超额, redis 可用时使用分布式限流, 否则使用单机版。
+//分布式限流出错时本次请求改用单机版, 并计入健康检查的失败次数
+func (f *Failover) Check(key string, format string) (*Result, error) {
+	//先排除格式错误, 之后分布式限流返回的错误都来自 redis
+	if _, err := ParsePolicy(format); err != nil {
+		return nil, err
+	}
+	if f.monitor.Healthy() {
+		result, err := f.distribute.Check(key, format)
+		if err == nil {
+			return result, nil
+		}
+		f.monitor.ReportFailure(err)
+	}
+	return f.checkLocal(key, format)
+}
+
+//checkLocal 使用单机版检测, 需要时按实例数均分配额
+func (f *Failover) checkLocal(key string, format string) (*Result, error) {
+	instances := f.monitor.Instances()
+	if !f.scale || instances <= 1 {
+		return f.local.Check(key, format)
+	}
+
+	cacheKey := strconv.Itoa(instances) + "|" + format
+	scaled, ok := f.scaled.Load(cacheKey)
+	if !ok {
+		policy, err := ParsePolicy(format)
+		if err != nil {
+			return nil, err
+		}
+		scaled, _ = f.scaled.LoadOrStore(cacheKey, scalePolicy(policy, instances))
+	}
+	return f.local.Check(key, scaled.(string))
+}
+
+//scalePolicy 将每档规则的配额按实例数均分(向上取整, 至少为 1), 返回可被 ParsePolicy 解析的 format
+func scalePolicy(policy Policy, instances int) string {
+	tiers := make([]string, 0, len(policy))
+	for _, rule := range policy {
+		rule.Limit = int(math.Ceil(float64(rule.Limit) / float64(instances)))
+		if rule.Burst > 0 {
+			rule.Burst = int(math.Ceil(float64(rule.Burst) / float64(instances)))
+		}
+		tiers = append(tiers, rule.String())
+	}
+	return strings.Join(tiers, ",")
+}
@@ -0,0 +1,214 @@
+package limiter
+
+import (
+	"errors"
+	"fmt"
+	"gin-api/pkg/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var timeRule = map[string]time.Duration{
+	"S": time.Second,
+	"M": time.Minute,
+	"H": time.Hour,
+	"D": 24 * time.Hour,
+}
+
+//durationUnits 自定义周期(如 "100/15m")支持的单位, "ms" 需排在 "m" 之前匹配
+var durationUnits = []struct {
+	unit     string
+	duration time.Duration
+}{
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+}
+
+//Rule 解析后的单档限流规则
+type Rule struct {
+	Algorithm string
+	Limit     int
+	Period    time.Duration
+	//Burst 允许的最大突发请求数, 仅令牌桶和 GCRA 有效, 0 表示等于 Limit
+	Burst int
+}
+
+//Capacity 返回允许的最大突发请求数
+func (r Rule) Capacity() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return r.Limit
+}
+
+//String 返回规则的规范写法, 同时用于区分同一个 key 下不同档位的存储
+func (r Rule) String() string {
+	s := r.Algorithm + ":" + strconv.Itoa(r.Limit) + "/" + strconv.FormatInt(int64(r.Period/time.Millisecond), 10) + "ms"
+	if r.Burst > 0 {
+		s += " burst " + strconv.Itoa(r.Burst)
+	}
+	return s
+}
+
+//Policy 由多档规则组成的限流策略, 每一档都需要满足
+type Policy []Rule
+
+//policies 已解析的 format, 避免每个请求都重复解析
+var policies sync.Map
+
+//ParsePolicy 解析完整的 format, 多档规则以逗号分隔, 例如:
+//	"10-S,500-H,5000-D"   每秒 10 次, 同时每小时 500 次、每天 5000 次
+//	"100/15m"             每 15 分钟 100 次
+//	"10-S burst 30"       每秒 10 次, 允许 30 次突发
+//	"gcra:100/1m burst 20, fixed:5000-D"
+func ParsePolicy(format string) (Policy, error) {
+	if cached, ok := policies.Load(format); ok {
+		return cached.(Policy), nil
+	}
+
+	var policy Policy
+	for _, tier := range strings.Split(format, ",") {
+		rule, err := ParseRule(tier)
+		if err != nil {
+			return nil, err
+		}
+		policy = append(policy, rule)
+	}
+	policies.Store(format, policy)
+	return policy, nil
+}
+
+//MustParse 解析 format, 格式有误时 panic, 用于在注册路由时尽早暴露配置错误
+func MustParse(format string) Policy {
+	policy, err := ParsePolicy(format)
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+//Named 返回配置 limiter.rates 中按名称定义的 format, 未配置或格式有误时 panic
+func Named(name string) string {
+	format := config.GetString("limiter.rates." + strings.ToLower(name))
+	if format == "" {
+		panic(errors.New("未配置限流规则: limiter.rates." + name))
+	}
+	MustParse(format)
+	return format
+}
+
+//ParseRule 解析单档规则, 格式为 "[算法:]数量-单位" 或 "[算法:]数量/周期", 可在末尾追加 "burst 数量",
+//没有算法前缀时使用令牌桶, 例如 "gcra:10-S"、"fixed:100/15m"、"10-S burst 30"
+func ParseRule(format string) (Rule, error) {
+	rule := Rule{Algorithm: TokenBucket}
+	fields := strings.Fields(format)
+	switch {
+	case len(fields) == 1:
+	case len(fields) == 3 && strings.EqualFold(fields[1], "burst"):
+		burst, err := strconv.Atoi(fields[2])
+		if err != nil || burst <= 0 {
+			return rule, formatError(format, "burst 必须为正整数")
+		}
+		rule.Burst = burst
+	default:
+		return rule, formatError(format, "")
+	}
+
+	spec := fields[0]
+	if i := strings.Index(spec, ":"); i >= 0 {
+		rule.Algorithm = strings.ToLower(spec[:i])
+		spec = spec[i+1:]
+		if !algorithms[rule.Algorithm] {
+			return rule, errors.New("不支持的限流算法: " + rule.Algorithm)
+		}
+	}
+	if rule.Burst > 0 && rule.Algorithm != TokenBucket && rule.Algorithm != GCRA {
+		return rule, formatError(format, "burst 仅适用于 token 和 gcra 算法")
+	}
+
+	var err error
+	if i := strings.Index(spec, "/"); i >= 0 {
+		rule.Limit, err = parseLimit(spec[:i])
+		if err == nil {
+			rule.Period, err = parsePeriod(spec[i+1:])
+		}
+	} else {
+		rule.Limit, rule.Period, err = ParseFormat(spec)
+	}
+	if err != nil {
+		return rule, formatError(format, err.Error())
+	}
+	return rule, nil
+}
+
+//ParseFormat 解析 "数量-单位" 格式的 format, 获取单位时间(every)的限制数量(limit), 单位支持 S/M/H/D
+func ParseFormat(format string) (limit int, everyDuration time.Duration, err error) {
+	sp := strings.Split(format, "-")
+	if len(sp) != 2 {
+		return 0, 0, errors.New("应为 数量-单位 或 数量/周期")
+	}
+	if limit, err = parseLimit(sp[0]); err != nil {
+		return 0, 0, err
+	}
+	everyDuration, ok := timeRule[strings.ToUpper(sp[1])]
+	if !ok {
+		return 0, 0, errors.New("不支持的时间单位: " + sp[1])
+	}
+	return limit, everyDuration, nil
+}
+
+//parseLimit 解析限制数量, 必须为正整数
+func parseLimit(s string) (int, error) {
+	limit, err := strconv.Atoi(s)
+	if err != nil || limit <= 0 {
+		return 0, errors.New("限制数量必须为正整数")
+	}
+	return limit, nil
+}
+
+//parsePeriod 解析自定义周期, 如 "15m"、"1h30m"、"2d"、"500ms", 省略数字时视为 1, 如 "s"、"h"
+func parsePeriod(s string) (time.Duration, error) {
+	var period time.Duration
+	rest := strings.ToLower(s)
+	for rest != "" {
+		i := 0
+		for i < len(rest) && rest[i] >= '0' && rest[i] <= '9' {
+			i++
+		}
+		n := 1
+		if i > 0 {
+			n, _ = strconv.Atoi(rest[:i])
+		}
+		rest = rest[i:]
+
+		matched := false
+		for _, u := range durationUnits {
+			if strings.HasPrefix(rest, u.unit) {
+				period += time.Duration(n) * u.duration
+				rest = rest[len(u.unit):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, errors.New("不支持的时间周期: " + s)
+		}
+	}
+	if period < time.Millisecond {
+		return 0, errors.New("时间周期必须大于 0: " + s)
+	}
+	return period, nil
+}
+
+//formatError 构造带有原始 format 的错误信息
+func formatError(format string, reason string) error {
+	if reason == "" {
+		return fmt.Errorf("限流格式有误: %q", strings.TrimSpace(format))
+	}
+	return fmt.Errorf("限流格式有误: %q, %s", strings.TrimSpace(format), reason)
+}
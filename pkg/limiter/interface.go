@@ -1,30 +1,112 @@
 package limiter
 
 import (
-	"errors"
-	"github.com/spf13/cast"
-	"strings"
+	"gin-api/pkg/config"
+	"sync"
 	"time"
 )
 
+//LimiterIfac 限流器, 请求超额时返回的 Result.Allowed 为 false,
+//error 仅表示格式错误或存储不可用等异常
 type LimiterIfac interface {
-	Check(key string, format string) error
+	Check(key string, format string) (*Result, error)
 }
 
-var timeRule = map[string]time.Duration{
-	"S": time.Second,
-	"M": time.Minute,
-	"H": time.Hour,
-	"D": 24 * time.Hour,
+//Result 单次检测的结果
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	//Reset 允许时为配额完全恢复(或窗口重置)的剩余时间, 拒绝时同 RetryAfter
+	Reset time.Duration
+	//RetryAfter 被拒绝时需要等待多久才能重试
+	RetryAfter time.Duration
 }
 
-//ParseFormat 解析 format, 获取单位时间(every)的限制数量(limit)
-func ParseFormat(format string) (limit int, everyDuration time.Duration, err error) {
-	sp := strings.Split(format, "-")
-	if len(sp) != 2 {
-		return 0, 0, errors.New("限流格式有误")
+//newResult 基于算法返回的 {是否允许, 剩余次数, 毫秒} 构造结果
+func newResult(limit int, allowed bool, remaining int, ms int64) *Result {
+	r := &Result{
+		Allowed:   allowed,
+		Limit:     limit,
+		Remaining: remaining,
+		Reset:     time.Duration(ms) * time.Millisecond,
 	}
-	limit = cast.ToInt(sp[0])
-	everyDuration = timeRule[sp[1]]
-	return limit, everyDuration, nil
-}
\ No newline at end of file
+	if !allowed {
+		r.Remaining = 0
+		r.RetryAfter = r.Reset
+	}
+	return r
+}
+
+//checkPolicy 依次检测 format 中的每一档规则, 任意一档超额即拒绝, 不再检测后面的规则;
+//全部通过时返回剩余次数最少的一档, 使响应头反映最先触达的配额
+//注意被后面的规则拒绝时, 前面的规则已经计数, 因此应把周期短、最容易触达的规则写在前面
+func checkPolicy(format string, check func(rule Rule) (*Result, error)) (*Result, error) {
+	policy, err := ParsePolicy(format)
+	if err != nil {
+		return nil, err
+	}
+
+	var tightest *Result
+	for _, rule := range policy {
+		result, err := check(rule)
+		if err != nil {
+			return nil, err
+		}
+		if !result.Allowed {
+			return result, nil
+		}
+		if tightest == nil || result.Remaining < tightest.Remaining {
+			tightest = result
+		}
+	}
+	return tightest, nil
+}
+
+//scriptResult 解析 lua 脚本返回的 {是否允许(1/0), 剩余次数, 毫秒}
+func scriptResult(limit int, res []interface{}) *Result {
+	allowed, _ := res[0].(int64)
+	remaining, _ := res[1].(int64)
+	ms, _ := res[2].(int64)
+	return newResult(limit, allowed == 1, int(remaining), ms)
+}
+
+//限流算法, 在 format 前加上 "算法:" 即可选择, 例如 "fixed:500-H", 缺省为令牌桶
+const (
+	//TokenBucket 令牌桶, 允许一定的突发流量
+	TokenBucket = "token"
+	//FixedWindow 固定窗口, 窗口按自然时间对齐(如整点、零点), 适合 "每个自然小时 N 次" 这类配额
+	FixedWindow = "fixed"
+	//SlidingLog 滑动窗口日志, 记录窗口内每个请求的时间, 最精确但占用内存最多
+	SlidingLog = "sliding_log"
+	//SlidingWindow 滑动窗口计数, 使用前后两个固定窗口的计数加权估算
+	SlidingWindow = "sliding"
+	//GCRA 通用信元速率算法, 请求在周期内均匀放行
+	GCRA = "gcra"
+)
+
+var algorithms = map[string]bool{
+	TokenBucket:   true,
+	FixedWindow:   true,
+	SlidingLog:    true,
+	SlidingWindow: true,
+	GCRA:          true,
+}
+
+var (
+	location     *time.Location
+	locationOnce sync.Once
+)
+
+//windowOffset 返回 app.timezone 相对 UTC 的偏移, 用于将固定窗口对齐到本地的整点/零点
+func windowOffset() time.Duration {
+	locationOnce.Do(func() {
+		loc, err := time.LoadLocation(config.GetString("app.timezone"))
+		if err != nil {
+			loc = time.Local
+		}
+		location = loc
+	})
+	_, offset := time.Now().In(location).Zone()
+	return time.Duration(offset) * time.Second
+}
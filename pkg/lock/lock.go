@@ -0,0 +1,291 @@
+// Package lock 基于 redis 的分布式锁。
+// 每次加锁生成唯一的 token 作为锁的值, 续期和释放时通过 Lua 脚本校验 token,
+// 锁过期后被其他实例获取时, 原持有者不会误删或误续期别人的锁
+package lock
+
+import (
+	"context"
+	"errors"
+	"gin-api/pkg/config"
+	"gin-api/pkg/helpers"
+	"gin-api/pkg/logger"
+	"gin-api/pkg/redis"
+	"math/rand"
+	"sync"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrNotAcquired = errors.New("[lock] 锁已被其他实例持有")
+	ErrNotHeld     = errors.New("[lock] 锁已过期或已被其他实例持有")
+	ErrInvalidTTL  = errors.New("[lock] ttl 不能小于 1 毫秒")
+)
+
+//releaseScript 只有持有者才能释放锁
+var releaseScript = goredis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+//refreshScript 只有持有者才能续期
+var refreshScript = goredis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+//options 加锁的可选参数
+type options struct {
+	client     *redis.RedisClient
+	prefix     string
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	watchdog   bool
+}
+
+//Option 加锁的可选参数
+type Option func(o *options)
+
+//WithClient 使用指定的 redis 连接, 缺省时使用 redis.DefaultClient()
+func WithClient(rds *redis.RedisClient) Option {
+	return func(o *options) {
+		o.client = rds
+	}
+}
+
+//WithPrefix 设置锁在 redis 中的 key 前缀, 缺省为 "{app.name}:lock:"
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+//WithBackoff 设置 Acquire 重试的等待时间, 从 min 开始每次翻倍直到 max, 并加入随机抖动, 缺省为 10 毫秒和 500 毫秒
+func WithBackoff(min, max time.Duration) Option {
+	return func(o *options) {
+		o.minBackoff, o.maxBackoff = min, max
+	}
+}
+
+//WithWatchdog 持有期间每隔 ttl/3 自动续期, 直到 Release 或续期失败, 适合耗时不确定的任务
+func WithWatchdog() Option {
+	return func(o *options) {
+		o.watchdog = true
+	}
+}
+
+//newOptions 合并缺省参数与 opts
+func newOptions(opts []Option) *options {
+	o := &options{
+		minBackoff: 10 * time.Millisecond,
+		maxBackoff: 500 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.minBackoff <= 0 {
+		o.minBackoff = time.Millisecond
+	}
+	if o.client == nil {
+		o.client = redis.DefaultClient()
+	}
+	if o.prefix == "" {
+		o.prefix = config.GetString("app.name") + ":lock:"
+	}
+	return o
+}
+
+// Lock 已获取的锁
+type Lock struct {
+	client *redis.RedisClient
+	key    string
+	token  string
+	ttl    time.Duration
+
+	once sync.Once
+	stop chan struct{}
+	lost chan struct{}
+}
+
+//TryAcquire 尝试获取一次锁, 锁已被其他实例持有时返回 ErrNotAcquired
+func TryAcquire(ctx context.Context, key string, ttl time.Duration, opts ...Option) (*Lock, error) {
+	return tryAcquire(ctx, key, ttl, newOptions(opts))
+}
+
+//Acquire 获取锁, 锁已被其他实例持有时按退避时间重试, 直到获取成功或 ctx 结束, 用法如下:
+//	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+//	defer cancel()
+//	l, err := lock.Acquire(ctx, "order:1", 10*time.Second)
+//	if err != nil {
+//		return err
+//	}
+//	defer l.Release()
+func Acquire(ctx context.Context, key string, ttl time.Duration, opts ...Option) (*Lock, error) {
+	o := newOptions(opts)
+	backoff := o.minBackoff
+	for {
+		l, err := tryAcquire(ctx, key, ttl, o)
+		if err != ErrNotAcquired {
+			return l, err
+		}
+
+		//在 [backoff/2, backoff) 之间随机等待, 避免多个实例同时重试
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > o.maxBackoff {
+			backoff = o.maxBackoff
+		}
+	}
+}
+
+//tryAcquire 使用 SET NX 获取锁, ttl 小于 1 毫秒时 SET 不会设置过期时间, 锁将永远不会释放
+func tryAcquire(ctx context.Context, key string, ttl time.Duration, o *options) (*Lock, error) {
+	if ttl < time.Millisecond {
+		return nil, ErrInvalidTTL
+	}
+	l := &Lock{
+		client: o.client,
+		key:    o.prefix + key,
+		token:  helpers.StrUuid(32),
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	ok, err := o.client.Client.SetNX(ctx, l.key, l.token, ttl).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotAcquired
+	}
+	if o.watchdog {
+		go l.watchdog()
+	}
+	return l, nil
+}
+
+//watchdog 每隔 ttl/3 续期一次, 续期失败时关闭 Lost
+func (l *Lock) watchdog() {
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+			err := l.Refresh(ctx, l.ttl)
+			cancel()
+			//网络抖动时在下一个周期重试, 锁确实已经不属于自己时才放弃
+			if err == ErrNotHeld {
+				logger.Log("lock-watchdog", l.key+": "+err.Error())
+				close(l.lost)
+				return
+			}
+			logger.LogIf("lock-watchdog", err)
+		}
+	}
+}
+
+//Key 锁在 redis 中的 key
+func (l *Lock) Key() string {
+	return l.key
+}
+
+//Token 本次加锁的唯一标识
+func (l *Lock) Token() string {
+	return l.token
+}
+
+//Lost 开启 WithWatchdog 时, 续期发现锁已不属于自己后关闭, 持有者应尽快停止受锁保护的操作
+func (l *Lock) Lost() <-chan struct{} {
+	return l.lost
+}
+
+//Refresh 将锁的有效期重置为 ttl, 锁已过期或已被其他实例持有时返回 ErrNotHeld;
+//ttl 小于 1 毫秒时返回 ErrInvalidTTL, 否则 PEXPIRE 0 会直接删除锁
+func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+	n, err := refreshScript.Run(ctx, l.client.Client, []string{l.key}, l.token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+//TTL 锁的剩余有效期, 锁已不属于自己时返回 0
+func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
+	value, err := l.client.Client.Get(ctx, l.key).Result()
+	if err == goredis.Nil || (err == nil && value != l.token) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return l.client.Client.PTTL(ctx, l.key).Result()
+}
+
+//Release 释放锁并停止自动续期, 锁已过期或已被其他实例持有时返回 ErrNotHeld
+func (l *Lock) Release() error {
+	l.once.Do(func() {
+		close(l.stop)
+	})
+	n, err := releaseScript.Run(context.Background(), l.client.Client, []string{l.key}, l.token).Int()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotHeld
+	}
+	return nil
+}
+
+//WithLock 获取锁(开启自动续期)后执行 fn, 执行完毕释放锁, 用法如下:
+//	err := lock.WithLock(ctx, "report:daily", time.Minute, func(ctx context.Context) error {
+//		return buildReport(ctx)
+//	})
+//获取锁失败时返回 ctx.Err() 或 redis 的错误; 执行期间锁丢失时 fn 收到的 ctx 会被取消
+func WithLock(ctx context.Context, key string, ttl time.Duration, fn func(ctx context.Context) error, opts ...Option) error {
+	l, err := Acquire(ctx, key, ttl, append(opts, WithWatchdog())...)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		logger.LogIf("lock-release", ignoreNotHeld(l.Release()))
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-l.Lost():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return fn(ctx)
+}
+
+//ignoreNotHeld 锁已过期导致释放失败时不需要记录日志
+func ignoreNotHeld(err error) error {
+	if err == ErrNotHeld {
+		return nil
+	}
+	return err
+}
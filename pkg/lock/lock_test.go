@@ -0,0 +1,89 @@
+package lock
+
+import (
+	"context"
+	"gin-api/pkg/redis"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+//newTestOptions 基于 miniredis 的加锁参数
+func newTestOptions(t *testing.T) (*miniredis.Miniredis, []Option) {
+	s := miniredis.RunT(t)
+	client := goredis.NewClient(&goredis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return s, []Option{WithClient(&redis.RedisClient{Client: client, Ctx: context.Background()}), WithPrefix("lock:")}
+}
+
+func TestTryAcquireAndRelease(t *testing.T) {
+	s, opts := newTestOptions(t)
+	ctx := context.Background()
+
+	l, err := TryAcquire(ctx, "order", time.Second, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := TryAcquire(ctx, "order", time.Second, opts...); err != ErrNotAcquired {
+		t.Fatalf("重复加锁返回 %v, 期望 ErrNotAcquired", err)
+	}
+	if ttl := s.TTL("lock:order"); ttl != time.Second {
+		t.Fatalf("锁的有效期为 %v, 期望 1s", ttl)
+	}
+
+	if err := l.Refresh(ctx, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := s.TTL("lock:order"); ttl != 5*time.Second {
+		t.Fatalf("续期后有效期为 %v, 期望 5s", ttl)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Exists("lock:order") {
+		t.Fatal("释放后锁仍然存在")
+	}
+	if err := l.Refresh(ctx, time.Second); err != ErrNotHeld {
+		t.Fatalf("释放后续期返回 %v, 期望 ErrNotHeld", err)
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	s, opts := newTestOptions(t)
+	ctx := context.Background()
+
+	for _, ttl := range []time.Duration{-time.Second, 0, time.Microsecond, 999 * time.Microsecond} {
+		if _, err := TryAcquire(ctx, "invalid", ttl, opts...); err != ErrInvalidTTL {
+			t.Fatalf("TryAcquire(ttl=%v) 返回 %v, 期望 ErrInvalidTTL", ttl, err)
+		}
+		//Acquire 不应把无效的 ttl 当作锁被占用而一直重试
+		timeout, cancel := context.WithTimeout(ctx, time.Second)
+		_, err := Acquire(timeout, "invalid", ttl, opts...)
+		cancel()
+		if err != ErrInvalidTTL {
+			t.Fatalf("Acquire(ttl=%v) 返回 %v, 期望 ErrInvalidTTL", ttl, err)
+		}
+		if err := WithLock(ctx, "invalid", ttl, func(ctx context.Context) error { return nil }, opts...); err != ErrInvalidTTL {
+			t.Fatalf("WithLock(ttl=%v) 返回 %v, 期望 ErrInvalidTTL", ttl, err)
+		}
+	}
+	if s.Exists("lock:invalid") {
+		t.Fatal("无效的 ttl 创建了永不过期的锁")
+	}
+
+	l, err := TryAcquire(ctx, "refresh", time.Millisecond, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ttl := range []time.Duration{0, 500 * time.Microsecond} {
+		if err := l.Refresh(ctx, ttl); err != ErrInvalidTTL {
+			t.Fatalf("Refresh(ttl=%v) 返回 %v, 期望 ErrInvalidTTL", ttl, err)
+		}
+	}
+	if !s.Exists("lock:refresh") {
+		t.Fatal("无效的 ttl 续期删除了锁")
+	}
+}
@@ -0,0 +1,187 @@
+package mq
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNilMessage = errors.New("消息不能为空")
+	ErrDuplicate  = errors.New("消息重复, 去重窗口内已发送过相同 DedupKey 的消息")
+)
+
+//DefaultDedupWindow 未指定去重窗口时使用的默认值
+const DefaultDedupWindow = time.Hour
+
+// Message 队列中传递的消息, 发送时只需要填写 Body, 其余字段按需填写, ID 与 Timestamp 为空时自动生成
+type Message struct {
+	ID          string            //消息 id, 同一条消息重复投递时 id 不变
+	Key         string            //业务 key, 如订单号, 用于按 key 保证顺序和排查问题
+	Headers     map[string]string //自定义消息头
+	Body        []byte            //消息内容
+	ContentType string            //消息内容的类型, 如 application/json
+	Timestamp   time.Time         //发送时间
+	Attempts    int               //第几次投递, 从 1 开始, 只在消费时有值
+	Priority    uint8             //优先级, 由 WithPriority 设置
+	ExpireAt    time.Time         //过期时间, 由 WithTTL 设置, 过期的消息会被丢弃而不会交给消费者
+}
+
+//NewMessage 实例化消息
+func NewMessage(body []byte) *Message {
+	return &Message{Body: body}
+}
+
+//Header 读取消息头
+func (m *Message) Header(name string) string {
+	return m.Headers[name]
+}
+
+//SetHeader 设置消息头
+func (m *Message) SetHeader(name, value string) *Message {
+	if m.Headers == nil {
+		m.Headers = make(map[string]string)
+	}
+	m.Headers[name] = value
+	return m
+}
+
+//Expired 消息是否已过期
+func (m *Message) Expired() bool {
+	return !m.ExpireAt.IsZero() && time.Now().After(m.ExpireAt)
+}
+
+// PublishOptions 发送消息时的选项
+type PublishOptions struct {
+	Delay       time.Duration //延时投递
+	Priority    uint8         //优先级, 数字越大越优先, 驱动不支持时忽略
+	TTL         time.Duration //消息的存活时间, 从可以投递时(即延时结束后)开始计算
+	DedupKey    string        //去重 key, DedupWindow 内 DedupKey 相同的消息只发送一次
+	DedupWindow time.Duration
+}
+
+type PublishOption func(*PublishOptions)
+
+//WithDelay 延时投递
+func WithDelay(delay time.Duration) PublishOption {
+	return func(o *PublishOptions) {
+		o.Delay = delay
+	}
+}
+
+//WithPriority 设置优先级
+func WithPriority(priority uint8) PublishOption {
+	return func(o *PublishOptions) {
+		o.Priority = priority
+	}
+}
+
+//WithTTL 设置消息的存活时间, 超过后消息被丢弃
+func WithTTL(ttl time.Duration) PublishOption {
+	return func(o *PublishOptions) {
+		o.TTL = ttl
+	}
+}
+
+//WithDedupKey 在 window 内只发送一次 key 相同的消息, window 小于等于 0 时使用 DefaultDedupWindow
+func WithDedupKey(key string, window time.Duration) PublishOption {
+	return func(o *PublishOptions) {
+		o.DedupKey = key
+		o.DedupWindow = window
+	}
+}
+
+//PreparePublish 供驱动在发送前调用: 应用选项, 补全消息的 ID、Timestamp、Priority 与 ExpireAt
+func PreparePublish(msg *Message, opts ...PublishOption) (PublishOptions, error) {
+	options := PublishOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if msg == nil {
+		return options, ErrNilMessage
+	}
+	if options.DedupKey != "" && options.DedupWindow <= 0 {
+		options.DedupWindow = DefaultDedupWindow
+	}
+
+	if msg.ID == "" {
+		msg.ID = NewID()
+	}
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	if options.Priority > 0 {
+		msg.Priority = options.Priority
+	}
+	if options.TTL > 0 {
+		msg.ExpireAt = msg.Timestamp.Add(options.Delay + options.TTL)
+	}
+	return options, nil
+}
+
+//NewID 生成 32 位十六进制的随机消息 id
+func NewID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// Canceler 支持取消延时消息的驱动
+type Canceler interface {
+	//Cancel 取消还未投递的延时消息, 消息已投递或不存在时返回 false
+	Cancel(ctx context.Context, msgId string) (bool, error)
+}
+
+// Deduplicator 发送端去重, 驱动在发送带 DedupKey 的消息前调用
+type Deduplicator interface {
+	//Claim 占用 key, window 内 key 已被占用时返回 false 与占用它的消息 id
+	Claim(ctx context.Context, key string, msgId string, window time.Duration) (bool, string, error)
+	//Release 释放 key, 消息发送失败时调用以便重试
+	Release(ctx context.Context, key string) error
+}
+
+// MemoryDeduplicator 进程内去重, 只能对同一进程发送的消息去重
+type MemoryDeduplicator struct {
+	mu      sync.Mutex
+	entries map[string]dedupEntry
+}
+
+type dedupEntry struct {
+	msgId    string
+	expireAt time.Time
+}
+
+//确保 MemoryDeduplicator 实现了 Deduplicator
+var _ Deduplicator = (*MemoryDeduplicator)(nil)
+
+func NewMemoryDeduplicator() *MemoryDeduplicator {
+	return &MemoryDeduplicator{entries: make(map[string]dedupEntry)}
+}
+
+func (d *MemoryDeduplicator) Claim(ctx context.Context, key string, msgId string, window time.Duration) (bool, string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := d.entries[key]; ok && now.Before(entry.expireAt) {
+		return false, entry.msgId, nil
+	}
+	//顺便清理过期的 key, 避免 map 无限增长
+	for k, entry := range d.entries {
+		if !now.Before(entry.expireAt) {
+			delete(d.entries, k)
+		}
+	}
+	d.entries[key] = dedupEntry{msgId: msgId, expireAt: now.Add(window)}
+	return true, "", nil
+}
+
+func (d *MemoryDeduplicator) Release(ctx context.Context, key string) error {
+	d.mu.Lock()
+	delete(d.entries, key)
+	d.mu.Unlock()
+	return nil
+}
@@ -5,16 +5,27 @@ import (
 	"fmt"
 	"reflect"
 	"runtime"
+	"time"
 )
 
+//Handler 消费者处理消息的回调, 返回 nil 时确认消息, 返回错误时消息会被重新投递
+type Handler func(ctx context.Context, msg *Message) error
+
+//ConsumeCallBack 旧版回调, 只能拿到消息 id 与消息内容, 新代码请使用 Handler
 type ConsumeCallBack func(ctx context.Context, msgId string, msg []byte, extra interface{}) error
 
-type Contracts interface {
-	SendNormalMsg(ctx context.Context, data []byte) (err error, msgId string)
-	ReceiveNormalMsg(ctx context.Context, callback ConsumeCallBack)
+//Handler 将旧版回调转换为 Handler, extra 原样传给回调
+func (callback ConsumeCallBack) Handler(extra interface{}) Handler {
+	return func(ctx context.Context, msg *Message) error {
+		return callback(ctx, msg.ID, msg.Body, extra)
+	}
+}
 
-	SendDelayMsg(ctx context.Context, data []byte, delay int64) (err error, msgId string)
-	ReceiveDelayMsg(ctx context.Context, callback ConsumeCallBack)
+type Contracts interface {
+	//Publish 发送消息, 返回消息 id
+	Publish(ctx context.Context, msg *Message, opts ...PublishOption) (string, error)
+	//Consume 消费消息, 阻塞直到 ctx 结束(返回 ctx.Err())或出现无法恢复的错误
+	Consume(ctx context.Context, handler Handler) error
 }
 
 type Service struct {
@@ -27,30 +38,53 @@ func NewService(driver Contracts) *Service {
 	}
 }
 
-func (s *Service) SendNormalMsg(ctx context.Context, data []byte) (err error, msgId string) {
-	return s.Driver.SendNormalMsg(ctx, data)
+func (s *Service) Publish(ctx context.Context, msg *Message, opts ...PublishOption) (string, error) {
+	return s.Driver.Publish(ctx, msg, opts...)
+}
+
+func (s *Service) Consume(ctx context.Context, handler Handler) error {
+	return s.Driver.Consume(ctx, handler)
+}
+
+func (s *Service) SendNormalMsg(ctx context.Context, data []byte) (msgId string, err error) {
+	return s.Driver.Publish(ctx, NewMessage(data))
 }
 
 func (s *Service) ReceiveNormalMsg(ctx context.Context, callback ConsumeCallBack) {
-	s.Driver.ReceiveNormalMsg(ctx, callback)
+	s.Driver.Consume(ctx, callback.Handler(nil))
 }
 
-func (s *Service) SendDelayMsg(ctx context.Context, data []byte, delay int64) (err error, msgId string) {
+//SendDelayMsg 发送延时消息, delay 单位为毫秒
+func (s *Service) SendDelayMsg(ctx context.Context, data []byte, delay int64) (msgId string, err error) {
 	if delay <= 0 {
 		panic("SendDelayMsg must provide delay")
 	}
-	return s.Driver.SendDelayMsg(ctx, data, delay)
+	return s.Driver.Publish(ctx, NewMessage(data), WithDelay(time.Duration(delay)*time.Millisecond))
 }
 
+//ReceiveDelayMsg 延时消息到期后与普通消息进入同一个队列, 与 ReceiveNormalMsg 相同
 func (s *Service) ReceiveDelayMsg(ctx context.Context, callback ConsumeCallBack) {
-	s.Driver.ReceiveDelayMsg(ctx, callback)
+	s.Driver.Consume(ctx, callback.Handler(nil))
 }
 
 func Printf(s string, i ...interface{}) {
 	fmt.Println(fmt.Sprintf(s, i...))
 }
 
-func GetFuncName(callback ConsumeCallBack) string {
+//GetFuncName 回调函数的名称, 用于日志, callback 可以是 Handler 或 ConsumeCallBack
+func GetFuncName(callback interface{}) string {
 	v := reflect.ValueOf(callback)
 	return runtime.FuncForPC(v.Pointer()).Name()
 }
+
+//Sleep 等待 d, ctx 提前结束时返回 false
+func Sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
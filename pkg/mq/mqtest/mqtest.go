@@ -0,0 +1,556 @@
+// Package mqtest 消息队列驱动的一致性测试, 每个实现了 mq.Contracts 的驱动都应通过, 用法如下:
+//	func TestRedisMQ(t *testing.T) {
+//		mqtest.Run(t, func(t *testing.T) mq.Contracts {
+//			return redismq.NewRedisMQ(rdb, redismq.Config{
+//				QueueName:   mqtest.QueueName(t),
+//				Retry:       mqtest.Retry,
+//				Concurrency: mqtest.Concurrency,
+//			})
+//		})
+//	}
+package mqtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gin-api/pkg/mq"
+)
+
+//Timeout 等待消息或 Consume 返回的最长时间, 驱动较慢时可以调大
+var Timeout = 10 * time.Second
+
+//Retry 驱动应使用的重试策略, 缩短等待时间以加快测试
+var Retry = mq.RetryPolicy{MaxAttempts: 3, Backoff: 100 * time.Millisecond, MaxBackoff: 200 * time.Millisecond, Jitter: -1}
+
+//Concurrency 驱动应使用的消费协程数
+var Concurrency = 4
+
+//Factory 为每个子测试创建一个驱动, 驱动应使用 Retry 作为重试策略、Concurrency 作为消费协程数, 不同子测试的驱动必须使用不同的队列
+type Factory func(t *testing.T) mq.Contracts
+
+//QueueName 根据测试名生成唯一的队列名
+func QueueName(t *testing.T) string {
+	name := strings.NewReplacer("/", ".", " ", "_").Replace(t.Name())
+	return fmt.Sprintf("mqtest.%v.%v", name, time.Now().UnixNano())
+}
+
+//Run 运行全部一致性测试
+func Run(t *testing.T, factory Factory) {
+	t.Run("RoundTrip", func(t *testing.T) { testRoundTrip(t, factory(t)) })
+	t.Run("Order", func(t *testing.T) { testOrder(t, factory(t)) })
+	t.Run("Delay", func(t *testing.T) { testDelay(t, factory(t)) })
+	t.Run("MixedDelays", func(t *testing.T) { testMixedDelays(t, factory(t)) })
+	t.Run("CancelDelayed", func(t *testing.T) { testCancelDelayed(t, factory(t)) })
+	t.Run("TTL", func(t *testing.T) { testTTL(t, factory(t)) })
+	t.Run("Dedup", func(t *testing.T) { testDedup(t, factory(t)) })
+	t.Run("Cancel", func(t *testing.T) { testCancel(t, factory(t)) })
+	t.Run("Retry", func(t *testing.T) { testRetry(t, factory(t)) })
+	t.Run("DeadLetter", func(t *testing.T) { testDeadLetter(t, deadLetterQueue(t, factory(t))) })
+	t.Run("Poison", func(t *testing.T) { testPoison(t, deadLetterQueue(t, factory(t))) })
+	t.Run("Panic", func(t *testing.T) { testPanic(t, factory(t)) })
+	t.Run("KeyOrder", func(t *testing.T) { testKeyOrder(t, factory(t)) })
+	t.Run("Drain", func(t *testing.T) { testDrain(t, factory(t)) })
+}
+
+// dlqDriver 同时实现了 mq.Contracts 与 mq.DeadLetterQueue 的驱动
+type dlqDriver interface {
+	mq.Contracts
+	mq.DeadLetterQueue
+}
+
+//deadLetterQueue 驱动未实现 mq.DeadLetterQueue 时跳过测试
+func deadLetterQueue(t *testing.T, driver mq.Contracts) dlqDriver {
+	dlq, ok := driver.(dlqDriver)
+	if !ok {
+		t.Skip("驱动未实现 mq.DeadLetterQueue")
+	}
+	return dlq
+}
+
+// Consumer 在后台运行 Consume, 收到的消息写入 Messages
+type Consumer struct {
+	Messages chan *mq.Message
+	cancel   context.CancelFunc
+	done     chan error
+	once     sync.Once
+}
+
+//Start 在后台开始消费, handler 为 nil 时只接收消息, 测试结束时自动停止
+func Start(t *testing.T, driver mq.Contracts, handler mq.Handler) *Consumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Consumer{
+		Messages: make(chan *mq.Message, 1024),
+		cancel:   cancel,
+		done:     make(chan error, 1),
+	}
+	go func() {
+		c.done <- driver.Consume(ctx, func(ctx context.Context, msg *mq.Message) error {
+			if handler != nil {
+				if err := handler(ctx, msg); err != nil {
+					return err
+				}
+			}
+			c.Messages <- msg
+			return nil
+		})
+	}()
+	t.Cleanup(func() { c.Stop(t) })
+	return c
+}
+
+//Stop 停止消费, Consume 必须在 Timeout 内返回 ctx 的错误
+func (c *Consumer) Stop(t *testing.T) {
+	c.once.Do(func() {
+		c.cancel()
+		select {
+		case err := <-c.done:
+			if err != context.Canceled {
+				t.Errorf("ctx 取消后 Consume 应返回 context.Canceled, 实际返回 %v", err)
+			}
+		case <-time.After(Timeout):
+			t.Errorf("ctx 取消后 Consume 未在 %v 内返回", Timeout)
+		}
+	})
+}
+
+//Next 等待下一条消息, 超时则测试失败
+func (c *Consumer) Next(t *testing.T) *mq.Message {
+	t.Helper()
+	select {
+	case msg := <-c.Messages:
+		return msg
+	case <-time.After(Timeout):
+		t.Fatalf("%v 内没有收到消息", Timeout)
+		return nil
+	}
+}
+
+//None 在 wait 内不应收到消息
+func (c *Consumer) None(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case msg := <-c.Messages:
+		t.Fatalf("不应收到消息, 实际收到 %v: %s", msg.ID, msg.Body)
+	case <-time.After(wait):
+	}
+}
+
+//Publish 发送消息, 出错则测试失败
+func Publish(t *testing.T, driver mq.Contracts, msg *mq.Message, opts ...mq.PublishOption) string {
+	t.Helper()
+	msgId, err := driver.Publish(context.Background(), msg, opts...)
+	if err != nil {
+		t.Fatalf("发送消息失败: %v", err)
+	}
+	if msgId == "" || msgId != msg.ID {
+		t.Fatalf("Publish 应返回消息 id %q, 实际返回 %q", msg.ID, msgId)
+	}
+	return msgId
+}
+
+func testRoundTrip(t *testing.T, driver mq.Contracts) {
+	sent := mq.NewMessage([]byte(`{"order_id":1}`))
+	sent.Key = "order:1"
+	sent.ContentType = "application/json"
+	sent.SetHeader("trace-id", "abc").SetHeader("source", "mqtest")
+	msgId := Publish(t, driver, sent)
+
+	got := Start(t, driver, nil).Next(t)
+	if got.ID != msgId {
+		t.Errorf("ID = %q, want %q", got.ID, msgId)
+	}
+	if string(got.Body) != string(sent.Body) {
+		t.Errorf("Body = %s, want %s", got.Body, sent.Body)
+	}
+	if got.Key != sent.Key {
+		t.Errorf("Key = %q, want %q", got.Key, sent.Key)
+	}
+	if got.ContentType != sent.ContentType {
+		t.Errorf("ContentType = %q, want %q", got.ContentType, sent.ContentType)
+	}
+	for name, value := range sent.Headers {
+		if got.Header(name) != value {
+			t.Errorf("Header(%q) = %q, want %q", name, got.Header(name), value)
+		}
+	}
+	if diff := got.Timestamp.Sub(sent.Timestamp); diff < -time.Second || diff > time.Second {
+		t.Errorf("Timestamp = %v, want %v", got.Timestamp, sent.Timestamp)
+	}
+	if got.Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", got.Attempts)
+	}
+}
+
+func testOrder(t *testing.T, driver mq.Contracts) {
+	const n = 20
+	for i := 0; i < n; i++ {
+		msg := mq.NewMessage([]byte(fmt.Sprint(i)))
+		msg.Key = "order"
+		Publish(t, driver, msg)
+	}
+
+	consumer := Start(t, driver, nil)
+	for i := 0; i < n; i++ {
+		if got := string(consumer.Next(t).Body); got != fmt.Sprint(i) {
+			t.Fatalf("第 %d 条消息为 %s, 单个消费者应按发送顺序收到 key 相同的消息", i, got)
+		}
+	}
+}
+
+func testDelay(t *testing.T, driver mq.Contracts) {
+	const delay = time.Second
+	consumer := Start(t, driver, nil)
+
+	start := time.Now()
+	delayed := Publish(t, driver, mq.NewMessage([]byte("delayed")), mq.WithDelay(delay))
+	immediate := Publish(t, driver, mq.NewMessage([]byte("immediate")))
+
+	if got := consumer.Next(t); got.ID != immediate {
+		t.Fatalf("应先收到未延时的消息, 实际收到 %s", got.Body)
+	}
+	got := consumer.Next(t)
+	if got.ID != delayed {
+		t.Fatalf("应收到延时消息, 实际收到 %s", got.Body)
+	}
+	//broker 的过期检查精度有限, 允许提前 100 毫秒
+	if elapsed := time.Since(start); elapsed < delay-100*time.Millisecond {
+		t.Errorf("延时消息在 %v 后收到, 应不早于 %v", elapsed, delay)
+	}
+}
+
+//MixedDelays 混合延时测试的消息数量与最长延时
+var (
+	MixedDelayMessages = 2000
+	MixedDelayMax      = 2 * time.Second
+)
+
+func testMixedDelays(t *testing.T, driver mq.Contracts) {
+	//延时取 100 毫秒的整数倍, 避免 rabbitmq 为每个不同的延时创建一个延时队列
+	steps := int(MixedDelayMax / (100 * time.Millisecond))
+	due := make(map[string]time.Time, MixedDelayMessages)
+	for i := 0; i < MixedDelayMessages; i++ {
+		delay := time.Duration(rand.Intn(steps+1)) * 100 * time.Millisecond
+		msg := mq.NewMessage([]byte(fmt.Sprint(i)))
+		if delay > 0 {
+			Publish(t, driver, msg, mq.WithDelay(delay))
+		} else {
+			Publish(t, driver, msg)
+		}
+		due[msg.ID] = msg.Timestamp.Add(delay)
+	}
+
+	consumer := Start(t, driver, nil)
+	early := 0
+	for received := 0; received < MixedDelayMessages; received++ {
+		msg := consumer.Next(t)
+		dueAt, ok := due[msg.ID]
+		if !ok {
+			t.Fatalf("收到重复或未知的消息 %q", msg.ID)
+		}
+		delete(due, msg.ID)
+		if time.Now().Before(dueAt.Add(-100 * time.Millisecond)) {
+			early++
+		}
+	}
+	if early > 0 {
+		t.Errorf("%d 条延时消息在投递时间之前被收到", early)
+	}
+	consumer.None(t, 300*time.Millisecond)
+}
+
+func testCancelDelayed(t *testing.T, driver mq.Contracts) {
+	canceler, ok := driver.(mq.Canceler)
+	if !ok {
+		t.Skip("驱动未实现 mq.Canceler")
+	}
+	consumer := Start(t, driver, nil)
+
+	canceled := Publish(t, driver, mq.NewMessage([]byte("canceled")), mq.WithDelay(500*time.Millisecond))
+	kept := Publish(t, driver, mq.NewMessage([]byte("kept")), mq.WithDelay(500*time.Millisecond))
+	if ok, err := canceler.Cancel(context.Background(), canceled); err != nil || !ok {
+		t.Fatalf("Cancel = %v, %v, want true, nil", ok, err)
+	}
+
+	if got := consumer.Next(t); got.ID != kept {
+		t.Fatalf("已取消的消息不应被投递, 实际收到 %s", got.Body)
+	}
+	consumer.None(t, 300*time.Millisecond)
+	if ok, err := canceler.Cancel(context.Background(), kept); err != nil || ok {
+		t.Errorf("已投递的消息 Cancel = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func testTTL(t *testing.T, driver mq.Contracts) {
+	Publish(t, driver, mq.NewMessage([]byte("expired")), mq.WithTTL(100*time.Millisecond))
+	time.Sleep(300 * time.Millisecond)
+	alive := Publish(t, driver, mq.NewMessage([]byte("alive")), mq.WithTTL(time.Hour))
+
+	consumer := Start(t, driver, nil)
+	if got := consumer.Next(t); got.ID != alive {
+		t.Fatalf("过期的消息不应交给消费者, 实际收到 %s", got.Body)
+	}
+	consumer.None(t, 500*time.Millisecond)
+}
+
+func testDedup(t *testing.T, driver mq.Contracts) {
+	first := Publish(t, driver, mq.NewMessage([]byte("first")), mq.WithDedupKey("order:1:paid", time.Minute))
+
+	msgId, err := driver.Publish(context.Background(), mq.NewMessage([]byte("second")), mq.WithDedupKey("order:1:paid", time.Minute))
+	if err != mq.ErrDuplicate {
+		t.Fatalf("重复发送应返回 ErrDuplicate, 实际返回 %v", err)
+	}
+	if msgId != first {
+		t.Errorf("重复发送应返回第一条消息的 id %q, 实际返回 %q", first, msgId)
+	}
+
+	consumer := Start(t, driver, nil)
+	if got := consumer.Next(t); got.ID != first {
+		t.Fatalf("应收到第一条消息, 实际收到 %s", got.Body)
+	}
+	consumer.None(t, 500*time.Millisecond)
+}
+
+func testCancel(t *testing.T, driver mq.Contracts) {
+	consumer := Start(t, driver, nil)
+	Publish(t, driver, mq.NewMessage([]byte("before cancel")))
+	consumer.Next(t)
+	consumer.Stop(t)
+
+	//已取消的 ctx 应立即返回
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan error, 1)
+	go func() { done <- driver.Consume(ctx, func(ctx context.Context, msg *mq.Message) error { return nil }) }()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("Consume 应返回 context.Canceled, 实际返回 %v", err)
+		}
+	case <-time.After(Timeout):
+		t.Errorf("使用已取消的 ctx 时 Consume 未在 %v 内返回", Timeout)
+	}
+}
+
+func testRetry(t *testing.T, driver mq.Contracts) {
+	failures := Retry.MaxAttempts - 1
+	consumer := Start(t, driver, func(ctx context.Context, msg *mq.Message) error {
+		if msg.Attempts <= failures {
+			return errors.New("mqtest: 模拟消费失败")
+		}
+		return nil
+	})
+	msgId := Publish(t, driver, mq.NewMessage([]byte("retry")))
+
+	got := consumer.Next(t)
+	if got.ID != msgId {
+		t.Fatalf("重试的消息 id 应保持不变, 实际为 %q", got.ID)
+	}
+	if got.Attempts != Retry.MaxAttempts {
+		t.Errorf("Attempts = %d, want %d", got.Attempts, Retry.MaxAttempts)
+	}
+	consumer.None(t, 500*time.Millisecond)
+}
+
+//waitDeadLetters 等待死信队列中出现 n 条消息
+func waitDeadLetters(t *testing.T, dlq mq.DeadLetterQueue, n int) []*mq.DeadLetter {
+	t.Helper()
+	deadline := time.Now().Add(Timeout)
+	for {
+		letters, err := dlq.Inspect(context.Background(), 100)
+		if err != nil {
+			t.Fatalf("Inspect 失败: %v", err)
+		}
+		if len(letters) >= n {
+			return letters
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v 内死信队列中只有 %d 条消息, want %d", Timeout, len(letters), n)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func testDeadLetter(t *testing.T, driver dlqDriver) {
+	failing := true
+	var mu sync.Mutex
+	consumer := Start(t, driver, func(ctx context.Context, msg *mq.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failing {
+			return errors.New("mqtest: 模拟消费失败")
+		}
+		return nil
+	})
+	first := Publish(t, driver, mq.NewMessage([]byte("first")))
+	second := Publish(t, driver, mq.NewMessage([]byte("second")))
+
+	letters := waitDeadLetters(t, driver, 2)
+	for _, letter := range letters {
+		if letter.Reason != mq.ReasonMaxAttempts {
+			t.Errorf("Reason = %q, want %q", letter.Reason, mq.ReasonMaxAttempts)
+		}
+		if letter.Message.Attempts != Retry.MaxAttempts {
+			t.Errorf("Attempts = %d, want %d", letter.Message.Attempts, Retry.MaxAttempts)
+		}
+		if letter.Error == "" || letter.FailedAt.IsZero() {
+			t.Errorf("死信应记录错误信息与时间, 实际为 %q, %v", letter.Error, letter.FailedAt)
+		}
+	}
+	consumer.None(t, 200*time.Millisecond)
+
+	//Inspect 不移除消息
+	if again := waitDeadLetters(t, driver, 2); len(again) != 2 {
+		t.Fatalf("Inspect 后死信队列应仍有 2 条消息, 实际 %d 条", len(again))
+	}
+
+	mu.Lock()
+	failing = false
+	mu.Unlock()
+	if n, err := driver.Replay(context.Background(), first); err != nil || n != 1 {
+		t.Fatalf("Replay = %d, %v, want 1, nil", n, err)
+	}
+	got := consumer.Next(t)
+	if got.ID != first || got.Attempts != 1 {
+		t.Errorf("Replay 后收到 %q(第 %d 次投递), want %q(第 1 次投递)", got.ID, got.Attempts, first)
+	}
+
+	if n, err := driver.Purge(context.Background()); err != nil || n != 1 {
+		t.Fatalf("Purge = %d, %v, want 1, nil", n, err)
+	}
+	if letters, err := driver.Inspect(context.Background(), 100); err != nil || len(letters) != 0 {
+		t.Fatalf("Purge 后死信队列应为空, 实际 %d 条, err: %v", len(letters), err)
+	}
+	if n, _ := driver.Replay(context.Background(), second); n != 0 {
+		t.Errorf("已删除的死信不应被 Replay")
+	}
+}
+
+func testPoison(t *testing.T, driver dlqDriver) {
+	calls := make(chan struct{}, 10)
+	Start(t, driver, func(ctx context.Context, msg *mq.Message) error {
+		calls <- struct{}{}
+		return mq.Poison(errors.New("mqtest: 无法处理的消息"))
+	})
+	msgId := Publish(t, driver, mq.NewMessage([]byte("poison")))
+
+	letters := waitDeadLetters(t, driver, 1)
+	if letters[0].Message.ID != msgId || letters[0].Reason != mq.ReasonPoison {
+		t.Errorf("死信为 %q(%v), want %q(%v)", letters[0].Message.ID, letters[0].Reason, msgId, mq.ReasonPoison)
+	}
+	if len(calls) != 1 {
+		t.Errorf("Poison 的消息不应重试, 实际处理了 %d 次", len(calls))
+	}
+
+	if n, err := driver.Purge(context.Background(), msgId); err != nil || n != 1 {
+		t.Fatalf("Purge = %d, %v, want 1, nil", n, err)
+	}
+}
+
+func testPanic(t *testing.T, driver mq.Contracts) {
+	consumer := Start(t, driver, func(ctx context.Context, msg *mq.Message) error {
+		if string(msg.Body) == "panic" && msg.Attempts == 1 {
+			panic("mqtest: 模拟回调 panic")
+		}
+		return nil
+	})
+	panicked := Publish(t, driver, mq.NewMessage([]byte("panic")))
+	normal := Publish(t, driver, mq.NewMessage([]byte("normal")))
+
+	received := map[string]int{}
+	for i := 0; i < 2; i++ {
+		msg := consumer.Next(t)
+		received[msg.ID] = msg.Attempts
+	}
+	if received[normal] != 1 {
+		t.Errorf("回调 panic 后消费者应继续处理其他消息")
+	}
+	if received[panicked] != 2 {
+		t.Errorf("panic 的消息应按失败重试, 实际第 %d 次投递时收到", received[panicked])
+	}
+}
+
+func testKeyOrder(t *testing.T, driver mq.Contracts) {
+	const keys, perKey = 5, 20
+	for i := 0; i < perKey; i++ {
+		for k := 0; k < keys; k++ {
+			msg := mq.NewMessage([]byte(fmt.Sprint(i)))
+			msg.Key = fmt.Sprintf("key-%d", k)
+			Publish(t, driver, msg)
+		}
+	}
+
+	var running, maxRunning int32
+	consumer := Start(t, driver, func(ctx context.Context, msg *mq.Message) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			max := atomic.LoadInt32(&maxRunning)
+			if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+				break
+			}
+		}
+		time.Sleep(time.Duration(rand.Intn(5)) * time.Millisecond)
+		return nil
+	})
+
+	next := map[string]int{}
+	for i := 0; i < keys*perKey; i++ {
+		msg := consumer.Next(t)
+		if got := string(msg.Body); got != fmt.Sprint(next[msg.Key]) {
+			t.Fatalf("%v 的第 %d 条消息为 %s, 同一个 key 的消息应按发送顺序处理", msg.Key, next[msg.Key], got)
+		}
+		next[msg.Key]++
+	}
+	if Concurrency > 1 && maxRunning < 2 {
+		t.Errorf("Concurrency 为 %d 时消息应被并发处理, 实际最多同时处理 %d 条", Concurrency, maxRunning)
+	}
+}
+
+func testDrain(t *testing.T, driver mq.Contracts) {
+	started := make(chan struct{})
+	var finished int32
+	var handlerErr error
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- driver.Consume(ctx, func(ctx context.Context, msg *mq.Message) error {
+			close(started)
+			time.Sleep(500 * time.Millisecond)
+			handlerErr = ctx.Err()
+			atomic.StoreInt32(&finished, 1)
+			return nil
+		})
+	}()
+	Publish(t, driver, mq.NewMessage([]byte("drain")))
+
+	select {
+	case <-started:
+	case <-time.After(Timeout):
+		t.Fatalf("%v 内没有收到消息", Timeout)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(Timeout):
+		t.Fatalf("ctx 取消后 Consume 未在 %v 内返回", Timeout)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("Consume 应等待处理中的消息完成后再返回")
+	}
+	if handlerErr != nil {
+		t.Errorf("优雅退出期间回调的 ctx 不应被取消, 实际为 %v", handlerErr)
+	}
+
+	//处理完成的消息已确认, 不会再次投递
+	Start(t, driver, nil).None(t, 500*time.Millisecond)
+}
@@ -0,0 +1,130 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Pool 消费者的协程池, 同一个 key 的任务总是由同一个协程按提交顺序执行, 没有 key 的任务由任意空闲的协程执行
+type Pool struct {
+	shared chan func()
+	keyed  []chan func()
+	wg     sync.WaitGroup
+}
+
+//NewPool 启动 concurrency 个协程, buffer 为等待执行的任务数量上限, 队列满时 Submit 阻塞
+func NewPool(concurrency int, buffer int) *Pool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if buffer < concurrency {
+		buffer = concurrency
+	}
+
+	p := &Pool{
+		shared: make(chan func(), buffer),
+		keyed:  make([]chan func(), concurrency),
+	}
+	for i := range p.keyed {
+		p.keyed[i] = make(chan func(), buffer/concurrency)
+		p.wg.Add(1)
+		go p.work(p.keyed[i])
+	}
+	return p
+}
+
+func (p *Pool) work(keyed chan func()) {
+	defer p.wg.Done()
+	shared := p.shared
+	for keyed != nil || shared != nil {
+		select {
+		case task, ok := <-keyed:
+			if !ok {
+				keyed = nil
+				continue
+			}
+			task()
+		case task, ok := <-shared:
+			if !ok {
+				shared = nil
+				continue
+			}
+			task()
+		}
+	}
+}
+
+//Submit 提交任务, 只有一个协程时所有任务按提交顺序执行
+func (p *Pool) Submit(key string, task func()) {
+	if key == "" && len(p.keyed) > 1 {
+		p.shared <- task
+		return
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	p.keyed[h.Sum32()%uint32(len(p.keyed))] <- task
+}
+
+//Close 不再接收任务, 等待已提交的任务全部执行完毕
+func (p *Pool) Close() {
+	close(p.shared)
+	for _, keyed := range p.keyed {
+		close(keyed)
+	}
+	p.wg.Wait()
+}
+
+// detachedContext 保留 parent 的值但不会随 parent 取消
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+func (c detachedContext) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
+//DrainContext 供消费者传给回调的 ctx: ctx 结束后不会立即取消, 以便处理中的消息完成(优雅退出),
+//ctx 结束 timeout 后仍未处理完时才取消; 消费者退出时调用返回的 cancel 释放资源
+func DrainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(detachedContext{parent: ctx})
+	go func() {
+		select {
+		case <-drainCtx.Done():
+		case <-ctx.Done():
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			select {
+			case <-drainCtx.Done():
+			case <-timer.C:
+				cancel()
+			}
+		}
+	}()
+	return drainCtx, cancel
+}
+
+//Invoke 执行回调, 回调 panic 时转为错误返回, 避免一条消息导致消费协程退出
+func Invoke(ctx context.Context, handler Handler, msg *Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("callback panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return handler(ctx, msg)
+}
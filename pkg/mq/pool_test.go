@@ -0,0 +1,175 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPoolKeyOrder(t *testing.T) {
+	p := NewPool(8, 64)
+
+	var mu sync.Mutex
+	seen := make(map[string][]int)
+	for i := 0; i < 2000; i++ {
+		i := i
+		key := fmt.Sprintf("key-%d", i%16)
+		p.Submit(key, func() {
+			//让出调度, 使不同 key 的任务交错执行
+			if i%7 == 0 {
+				time.Sleep(time.Microsecond)
+			}
+			mu.Lock()
+			seen[key] = append(seen[key], i)
+			mu.Unlock()
+		})
+	}
+	p.Close()
+
+	if len(seen) != 16 {
+		t.Fatalf("执行了 %d 个 key 的任务, 期望 16 个", len(seen))
+	}
+	for key, order := range seen {
+		if len(order) != 125 {
+			t.Fatalf("%v 执行了 %d 个任务, 期望 125 个", key, len(order))
+		}
+		for j := 1; j < len(order); j++ {
+			if order[j] < order[j-1] {
+				t.Fatalf("%v 的任务没有按提交顺序执行: %v", key, order)
+			}
+		}
+	}
+}
+
+func TestPoolSharedTasksRunConcurrently(t *testing.T) {
+	p := NewPool(4, 4)
+	defer p.Close()
+
+	//4 个没有 key 的任务互相等待, 只有同时执行才能全部完成
+	var started sync.WaitGroup
+	started.Add(4)
+	done := make(chan struct{}, 4)
+	for i := 0; i < 4; i++ {
+		p.Submit("", func() {
+			started.Done()
+			started.Wait()
+			done <- struct{}{}
+		})
+	}
+	for i := 0; i < 4; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("没有 key 的任务没有并发执行")
+		}
+	}
+}
+
+func TestPoolCloseWaitsForQueuedTasks(t *testing.T) {
+	p := NewPool(2, 20)
+
+	var finished int64
+	for i := 0; i < 20; i++ {
+		key := ""
+		if i%2 == 0 {
+			key = "even"
+		}
+		p.Submit(key, func() {
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt64(&finished, 1)
+		})
+	}
+	p.Close()
+
+	if n := atomic.LoadInt64(&finished); n != 20 {
+		t.Fatalf("Close 返回时只执行完 %d 个任务, 期望 20 个", n)
+	}
+}
+
+type ctxKey struct{}
+
+func TestDrainContextCancelsAfterTimeout(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "value"))
+	ctx, cancel := DrainContext(parent, 300*time.Millisecond)
+	defer cancel()
+
+	if ctx.Value(ctxKey{}) != "value" {
+		t.Fatal("DrainContext 没有保留 parent 的值")
+	}
+
+	start := time.Now()
+	cancelParent()
+
+	select {
+	case <-ctx.Done():
+		t.Fatal("parent 结束后立即取消了")
+	case <-time.After(150 * time.Millisecond):
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("超时前 Err() = %v", ctx.Err())
+	}
+
+	select {
+	case <-ctx.Done():
+		if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+			t.Fatalf("parent 结束 %v 后就取消了, 期望至少 300ms", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("超时后仍未取消")
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Fatalf("Err() = %v, 期望 context.Canceled", ctx.Err())
+	}
+}
+
+func TestDrainContextCancel(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.Background())
+	defer cancelParent()
+
+	//parent 未结束时调用 cancel 立即取消
+	ctx, cancel := DrainContext(parent, time.Hour)
+	cancel()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("cancel 后没有取消")
+	}
+	if parent.Err() != nil {
+		t.Fatal("cancel 不应影响 parent")
+	}
+}
+
+func TestInvoke(t *testing.T) {
+	msg := NewMessage([]byte("body"))
+
+	err := Invoke(context.Background(), func(ctx context.Context, m *Message) error {
+		panic("boom")
+	}, msg)
+	if err == nil || !strings.Contains(err.Error(), "callback panic: boom") {
+		t.Fatalf("panic 没有转为错误: %v", err)
+	}
+	if !strings.Contains(err.Error(), "pool_test.go") {
+		t.Fatalf("错误中没有 panic 的堆栈: %v", err)
+	}
+
+	cause := errors.New("failed")
+	if err := Invoke(context.Background(), func(ctx context.Context, m *Message) error {
+		return cause
+	}, msg); err != cause {
+		t.Fatalf("Invoke() = %v, 期望原样返回回调的错误", err)
+	}
+
+	if err := Invoke(context.Background(), func(ctx context.Context, m *Message) error {
+		if m != msg {
+			t.Error("回调收到的消息不是传入的消息")
+		}
+		return nil
+	}, msg); err != nil {
+		t.Fatalf("Invoke() = %v", err)
+	}
+}
@@ -0,0 +1,160 @@
+package rabbitmq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gin-api/pkg/mq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+//deadQueueName 死信队列, 每个队列一个, 通过默认交换机投递
+func (r *RabbitMQ) deadQueueName() string {
+	return fmt.Sprintf("dead.%v", r.QueueName)
+}
+
+func (r *RabbitMQ) declareDeadQueue(channel *amqp.Channel) (amqp.Queue, error) {
+	q, err := channel.QueueDeclare(r.deadQueueName(), true, false, false, false, nil)
+	if err != nil {
+		return q, fmt.Errorf("failed to declare dead letter queue, err:%v", err)
+	}
+	return q, nil
+}
+
+//dead 将消息连同原因写入死信队列, 然后确认原消息
+func (r *RabbitMQ) dead(channel *amqp.Channel, delivery amqp.Delivery, msg *mq.Message, reason string, cause error) {
+	publishing := encode(msg)
+	publishing.Headers[headerDeadReason] = reason
+	publishing.Headers[headerDeadAt] = time.Now().UnixNano() / int64(time.Millisecond)
+	if cause != nil {
+		publishing.Headers[headerDeadError] = cause.Error()
+	}
+
+	_, err := r.declareDeadQueue(channel)
+	if err == nil {
+		err = channel.PublishWithContext(context.Background(), "", r.deadQueueName(), false, false, publishing)
+	}
+	if err != nil {
+		r.err <- fmt.Errorf("move to dead letter queue failed: %v, msg_id: %v", err, msg.ID)
+		delivery.Nack(false, true)
+		return
+	}
+	r.err <- fmt.Errorf("message moved to dead letter queue, reason: %v, msg_id: %v", reason, msg.ID)
+	delivery.Ack(false)
+}
+
+//Inspect 查看最早进入死信队列的 limit 条消息, 读取的消息不确认, 关闭 channel 后回到队列
+func (r *RabbitMQ) Inspect(ctx context.Context, limit int) ([]*mq.DeadLetter, error) {
+	channel, err := r.channel()
+	if err != nil {
+		return nil, err
+	}
+	defer channel.Close()
+	if _, err := r.declareDeadQueue(channel); err != nil {
+		return nil, err
+	}
+
+	letters := make([]*mq.DeadLetter, 0, limit)
+	for len(letters) < limit {
+		delivery, ok, err := channel.Get(r.deadQueueName(), false)
+		if err != nil {
+			return letters, err
+		}
+		if !ok {
+			break
+		}
+		letters = append(letters, decodeDead(delivery))
+	}
+	return letters, nil
+}
+
+//Replay 将死信重新发送到正常队列, 投递次数从 0 开始重新计算, 广播模式下所有消费者都会再次收到
+func (r *RabbitMQ) Replay(ctx context.Context, ids ...string) (int, error) {
+	return r.eachDead(ctx, ids, func(channel *amqp.Channel, letter *mq.DeadLetter) error {
+		msg := letter.Message
+		msg.Attempts = 0
+		msg.ExpireAt = time.Time{}
+
+		if err := r.declareExchange(channel); err != nil {
+			return err
+		}
+		if r.Mode != BroadCast {
+			if _, err := r.declareQueue(channel, r.normalRoutingKey, false); err != nil {
+				return err
+			}
+		}
+		return channel.PublishWithContext(ctx, r.normalExchangeName, r.normalRoutingKey, false, false, encode(msg))
+	})
+}
+
+//Purge 删除死信
+func (r *RabbitMQ) Purge(ctx context.Context, ids ...string) (int, error) {
+	if len(ids) > 0 {
+		return r.eachDead(ctx, ids, func(channel *amqp.Channel, letter *mq.DeadLetter) error {
+			return nil
+		})
+	}
+
+	channel, err := r.channel()
+	if err != nil {
+		return 0, err
+	}
+	defer channel.Close()
+	if _, err := r.declareDeadQueue(channel); err != nil {
+		return 0, err
+	}
+	return channel.QueuePurge(r.deadQueueName(), false)
+}
+
+//eachDead 逐条读取死信队列, 对 ids 中的死信调用 fn 并在成功后确认, 其余死信在关闭 channel 后回到队列
+func (r *RabbitMQ) eachDead(ctx context.Context, ids []string, fn func(channel *amqp.Channel, letter *mq.DeadLetter) error) (int, error) {
+	channel, err := r.channel()
+	if err != nil {
+		return 0, err
+	}
+	defer channel.Close()
+	q, err := r.declareDeadQueue(channel)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := 0; i < q.Messages; i++ {
+		delivery, ok, err := channel.Get(q.Name, false)
+		if err != nil {
+			return count, err
+		}
+		if !ok {
+			break
+		}
+
+		letter := decodeDead(delivery)
+		if !mq.MatchId(ids, letter.Message.ID) {
+			continue
+		}
+		if err := fn(channel, letter); err != nil {
+			return count, err
+		}
+		if err := delivery.Ack(false); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+//decodeDead 解码死信, Attempts 为进入死信队列前已投递的次数
+func decodeDead(delivery amqp.Delivery) *mq.DeadLetter {
+	msg := decode(delivery)
+	msg.Attempts = int(toInt64(delivery.Headers[headerAttempts]))
+	letter := &mq.DeadLetter{
+		Message:  msg,
+		Reason:   msg.Header(headerDeadReason),
+		Error:    msg.Header(headerDeadError),
+		FailedAt: time.Unix(0, toInt64(delivery.Headers[headerDeadAt])*int64(time.Millisecond)),
+	}
+	delete(msg.Headers, headerDeadReason)
+	delete(msg.Headers, headerDeadError)
+	return letter
+}
@@ -2,11 +2,12 @@ package rabbitmq
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
-	"gin-api/pkg/hash"
 	"gin-api/pkg/mq"
 	amqp "github.com/rabbitmq/amqp091-go"
 )
@@ -19,33 +20,58 @@ const (
 	BroadCast WorkMode = "broadcast"
 )
 
+//队列类型
+const (
+	QueueClassic = "classic"
+	QueueQuorum  = "quorum"
+)
+
 var (
 	retryLimit           = 20
+	maxMessageSize       = 4 * 1024 * 1024
 	normalExchangeDirect = "normal.exchange.direct" //直连交换机,用来投递单播消息
 	normalExchangeTopic  = "normal.exchange.topic"  //主题交换机,用来投递topic消息
 )
 
-//确保 RabbitMQ 实现了 mq.Contracts
-var _ mq.Contracts = (*RabbitMQ)(nil)
+//消息头中由驱动使用的字段, 消费时不会出现在 Message.Headers 中
+const (
+	headerKey        = "x-message-key"
+	headerAttempts   = "x-attempts"  //此前已投递的次数
+	headerExpireAt   = "x-expire-at" //过期时间, 毫秒时间戳
+	headerDeadReason = "x-dead-reason"
+	headerDeadError  = "x-dead-error"
+	headerDeadAt     = "x-dead-at"
+)
+
+//确保 RabbitMQ 实现了 mq.Contracts 与 mq.DeadLetterQueue
+var (
+	_ mq.Contracts       = (*RabbitMQ)(nil)
+	_ mq.DeadLetterQueue = (*RabbitMQ)(nil)
+)
 
 type Config struct {
-	QueueName string
-	Mode      WorkMode
-	LogPrintf func(string, ...interface{})
+	QueueName    string
+	Mode         WorkMode
+	LogPrintf    func(string, ...interface{})
+	MaxPriority  uint8           //队列支持的最大优先级(1-255), 为 0 时不支持优先级; 已存在的队列不能修改该参数
+	QueueType    string          //队列类型 QueueClassic(默认) 或 QueueQuorum, 见 NewRabbitMQ; 已存在的队列不能修改该参数
+	Deduplicator mq.Deduplicator //发送端去重, 默认只能对同一进程发送的消息去重
+	Retry        mq.RetryPolicy  //消费失败后的重试策略, 未设置的字段使用默认值
+	Concurrency  int             //同时处理消息的协程数, 默认 1; 大于 1 时只保证同一个 Message.Key 的消息按顺序处理
+	Prefetch     int             //broker 预先推送的未确认消息数量上限(Qos), 默认 10
+	DrainTimeout time.Duration   //ctx 结束后等待处理中的消息完成的最长时间, 超时后取消回调的 ctx, 默认 30 秒
 
 	exchangeName       string
 	exchangeKind       string //交换机类型(direct/topic)
 	normalExchangeName string //交换机名称
 	normalRoutingKey   string //路由名称
-	deadExchangeName   string //死信交换机名称
-	deadRoutingKey     string //死信路由名称
 }
 
 type RabbitMQ struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	dsn     string
-	err     chan error
+	mu   sync.Mutex
+	conn *amqp.Connection
+	dsn  string
+	err  chan error
 	Config
 }
 
@@ -59,14 +85,41 @@ func NewRabbitMQ(dsn string, queueConfig Config) *RabbitMQ {
 		panic("please provide a legal working mode")
 	}
 
+	//默认使用 classic 队列, 与已存在的队列保持兼容(队列类型不同时重新声明会失败);
+	//quorum 队列通过 x-delivery-count 记录重复投递的次数, 处理时进程反复崩溃的消息才能进入死信队列(mq.ReasonRedelivered),
+	//classic 队列只有 Redelivered 标记, 无法识别; quorum 队列不支持优先级, 也不能是广播模式下自动删除的队列
+	if queueConfig.QueueType == "" {
+		queueConfig.QueueType = QueueClassic
+	}
+	if queueConfig.QueueType != QueueClassic && queueConfig.QueueType != QueueQuorum {
+		panic("please provide a legal queue type")
+	}
+	if queueConfig.QueueType == QueueQuorum && (queueConfig.MaxPriority > 0 || queueConfig.Mode == BroadCast) {
+		panic("quorum queue does not support priority or broadcast mode")
+	}
+
 	if queueConfig.LogPrintf == nil {
 		queueConfig.LogPrintf = mq.Printf
 	}
 
+	if queueConfig.Deduplicator == nil {
+		queueConfig.Deduplicator = mq.NewMemoryDeduplicator()
+	}
+	if queueConfig.Concurrency <= 0 {
+		queueConfig.Concurrency = 1
+	}
+	if queueConfig.Prefetch <= 0 {
+		queueConfig.Prefetch = 10
+	}
+	if queueConfig.DrainTimeout <= 0 {
+		queueConfig.DrainTimeout = 30 * time.Second
+	}
+
 	r := &RabbitMQ{
 		dsn: dsn,
 		err: make(chan error),
 	}
+	go r.log()
 
 	return r.setConfig(queueConfig).connect()
 }
@@ -74,44 +127,61 @@ func NewRabbitMQ(dsn string, queueConfig Config) *RabbitMQ {
 func (r *RabbitMQ) setConfig(queueConfig Config) *RabbitMQ {
 	if queueConfig.Mode == Worker || queueConfig.Mode == BroadCast {
 		queueConfig.exchangeName = normalExchangeDirect
-	} else if r.Mode == Topic {
+	} else if queueConfig.Mode == Topic {
 		queueConfig.exchangeName = normalExchangeTopic
 	}
 
-	routingKey := addPrefix(queueConfig.QueueName)
 	queueConfig.exchangeKind = getExchangeKind(queueConfig.exchangeName)
 	queueConfig.normalExchangeName = queueConfig.exchangeName
-	queueConfig.normalRoutingKey = routingKey
-	queueConfig.deadExchangeName = replaceNormalToDead(queueConfig.exchangeName)
-	queueConfig.deadRoutingKey = replaceNormalToDead(routingKey)
+	queueConfig.normalRoutingKey = addPrefix(queueConfig.QueueName)
 	r.Config = queueConfig
 	return r
 }
 
+//connect 连接 broker, 连续失败 retryLimit 次后 panic
 func (r *RabbitMQ) connect() *RabbitMQ {
-	go r.log()
-	if r.conn != nil && !r.conn.IsClosed() {
-		return r
-	}
-
 	retryCount := 0
 	for {
-		var err error
-		r.conn, err = amqp.Dial(r.dsn)
-		if err != nil || r.conn == nil || r.conn.IsClosed() {
+		err := r.dial()
+		if err != nil {
 			if retryCount > retryLimit {
 				panic("RabbitMQ Service Unavailable")
 			}
-			r.err <- fmt.Errorf("connect failed : %v", err)
+			r.err <- err
 			time.Sleep(time.Duration(retryCount) * time.Second)
 			retryCount++
 			continue
 		}
-		retryCount = 0
 		return r
 	}
 }
 
+//dial 连接已断开时重新连接
+func (r *RabbitMQ) dial() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil && !r.conn.IsClosed() {
+		return nil
+	}
+
+	conn, err := amqp.Dial(r.dsn)
+	if err != nil {
+		return fmt.Errorf("connect failed : %v", err)
+	}
+	r.conn = conn
+	return nil
+}
+
+//channel 创建 channel, 用完后由调用方关闭
+func (r *RabbitMQ) channel() (*amqp.Channel, error) {
+	if err := r.dial(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn.Channel()
+}
+
 func (r *RabbitMQ) log() {
 	for {
 		select {
@@ -124,214 +194,408 @@ func (r *RabbitMQ) log() {
 	}
 }
 
-func (r *RabbitMQ) destroy() {
-	r.channel.Close()
-	r.conn.Close()
+//Close 关闭连接
+func (r *RabbitMQ) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil || r.conn.IsClosed() {
+		return nil
+	}
+	return r.conn.Close()
 }
 
-//SendNormalMsg 发送消息，delay 为延时投递时间(单位毫秒)
-func (r *RabbitMQ) SendNormalMsg(ctx context.Context, message []byte) (error, string) {
-	return r.publish(ctx, message, 0)
+//SendNormalMsg 发送消息
+func (r *RabbitMQ) SendNormalMsg(ctx context.Context, message []byte) (string, error) {
+	return r.Publish(ctx, mq.NewMessage(message))
 }
 
 //SendDelayMsg 发送延时消息，delay 为延时投递时间(单位毫秒)
-func (r *RabbitMQ) SendDelayMsg(ctx context.Context, message []byte, delay int64) (error, string) {
+func (r *RabbitMQ) SendDelayMsg(ctx context.Context, message []byte, delay int64) (string, error) {
 	if delay <= 0 {
 		panic("SendDelayMsg must provide delay ms")
 	}
-	return r.publish(ctx, message, delay)
+	return r.Publish(ctx, mq.NewMessage(message), mq.WithDelay(time.Duration(delay)*time.Millisecond))
 }
 
-//publish 发送消息
-//参数说明： message 为需要投递的消息，delay 如果大于0则延时投递消息
-//返回值说明： errChan 为消息投递中出现的错误
-func (r *RabbitMQ) publish(ctx context.Context, message []byte, delay int64) (error, string) {
-	defer r.destroy()
-	if len(message) > 4*1024*1024 {
-		return fmt.Errorf("message size cannot exceed 4M"), ""
+//Publish 发送消息
+//延时消息先投递到按延时时长命名的延时队列, 该队列没有消费者, 消息过期后经死信转发到正常队列,
+//相同延时的消息共用一个延时队列, 因此不会出现短延时的消息被长延时的消息阻塞的情况, 延时队列闲置一段时间后自动删除
+func (r *RabbitMQ) Publish(ctx context.Context, msg *mq.Message, opts ...mq.PublishOption) (string, error) {
+	options, err := mq.PreparePublish(msg, opts...)
+	if err != nil {
+		return "", err
+	}
+	if len(msg.Body) > maxMessageSize {
+		return "", fmt.Errorf("message size cannot exceed 4M")
 	}
 
-	//创建channel
-	r.connect()
-	channel, _ := r.conn.Channel()
-	r.channel = channel
+	if options.DedupKey != "" {
+		claimed, msgId, err := r.Deduplicator.Claim(ctx, options.DedupKey, msg.ID, options.DedupWindow)
+		if err != nil {
+			return "", err
+		}
+		if !claimed {
+			return msgId, mq.ErrDuplicate
+		}
+	}
 
-	//死信交换机属性
-	argsQue := make(map[string]interface{})
-	if delay > 0 {
-		argsQue["x-message-ttl"] = delay                        //消息过期时间,毫秒
-		argsQue["x-dead-letter-exchange"] = r.deadExchangeName  //指定死信交换机
-		argsQue["x-dead-letter-routing-key"] = r.deadRoutingKey //指定死信routing-key
+	if err := r.publish(ctx, msg, options); err != nil {
+		if options.DedupKey != "" {
+			r.Deduplicator.Release(ctx, options.DedupKey)
+		}
+		return "", err
 	}
+	return msg.ID, nil
+}
 
-	//创建队列,队列名称为空让 broker 自动生成
-	q, err := r.channel.QueueDeclare(
-		r.normalRoutingKey,
-		true,
-		false,
-		false,
-		false,
-		argsQue)
+func (r *RabbitMQ) publish(ctx context.Context, msg *mq.Message, options mq.PublishOptions) error {
+	channel, err := r.channel()
 	if err != nil {
-		return fmt.Errorf("failed to declare queue, err:%v", err), ""
+		return err
 	}
+	defer channel.Close()
 
-	//队列绑定（将队列、routing-key、交换机三者绑定到一起）
-	err = r.channel.QueueBind(
-		q.Name,
-		r.normalRoutingKey,
-		r.normalExchangeName,
-		false,
-		nil,
-	)
-	if err != nil {
-		return fmt.Errorf("failed to QueueBind, err:%v", err), ""
+	if err := r.declareExchange(channel); err != nil {
+		return err
 	}
 
-	msgId := r.GenMsgId(message)
-	err = r.channel.PublishWithContext(
-		ctx,
-		r.normalExchangeName,
-		r.normalRoutingKey, //routing-key
-		false,
-		false,
-		amqp.Publishing{
-			MessageId:   r.GenMsgId(message),
-			ContentType: "text/plain",
-			Body:        message,
-		})
-	if err != nil {
-		return fmt.Errorf("failed to PublishWithContext, err:%v", err), ""
+	//广播模式的队列由各个消费者创建, 其余模式提前创建队列, 避免消费者启动前的消息丢失
+	if r.Mode != BroadCast {
+		if _, err := r.declareQueue(channel, r.normalRoutingKey, false); err != nil {
+			return err
+		}
 	}
 
-	//非延时广播应删掉队列
-	if r.Mode == BroadCast && delay <= 0 {
-		r.channel.QueueDelete(q.Name, false, false, false)
+	publishing := encode(msg)
+	exchange, routingKey := r.normalExchangeName, r.normalRoutingKey
+	if options.Delay > 0 {
+		name := fmt.Sprintf("delay.%v.%v", r.QueueName, options.Delay.Milliseconds())
+		if routingKey, err = declareDelayQueue(channel, name, options.Delay, r.normalExchangeName, r.normalRoutingKey); err != nil {
+			return err
+		}
+		exchange = ""
+	} else if options.TTL > 0 {
+		//死信转发时 broker 会移除 expiration, 所以只对非延时消息设置, 延时消息的过期由消费端检查
+		publishing.Expiration = fmt.Sprint(options.TTL.Milliseconds())
 	}
 
-	return nil, msgId
+	err = channel.PublishWithContext(ctx, exchange, routingKey, false, false, publishing)
+	if err != nil {
+		return fmt.Errorf("failed to PublishWithContext, err:%v", err)
+	}
+	return nil
 }
 
 //ReceiveNormalMsg 接收消息 ，callback 为消费者提供的回调，消息会写入到该回调中供消费者自行处理，callback() 没有错误则会自动ack，反之broker会再次投递。
 func (r *RabbitMQ) ReceiveNormalMsg(ctx context.Context, callback mq.ConsumeCallBack) {
-	r.consume(ctx, r.normalExchangeName, r.normalRoutingKey, callback)
+	r.receive(ctx, callback)
 }
 
-//ReceiveDelayMsg 接收延时消息, callback 为消费者提供的回调，消息会写入到该回调中供消费者自行处理，callback() 没有错误则会自动ack，反之broker会再次投递。
+//ReceiveDelayMsg 延时消息到期后转发到正常队列, 与 ReceiveNormalMsg 相同
 func (r *RabbitMQ) ReceiveDelayMsg(ctx context.Context, callback mq.ConsumeCallBack) {
-	r.consume(ctx, r.deadExchangeName, r.deadRoutingKey, callback)
+	r.receive(ctx, callback)
 }
 
-func (r *RabbitMQ) GenMsgId(message []byte) string {
-	return fmt.Sprintf("%v-%v-%v", removePrefix(r.normalRoutingKey), time.Now().Format("20060102150405"), hash.HashByMd5(string(message)))
+func (r *RabbitMQ) receive(ctx context.Context, callback mq.ConsumeCallBack) {
+	if err := r.Consume(ctx, callback.Handler(nil)); err != nil && ctx.Err() == nil {
+		r.err <- err
+	}
 }
 
-//consume 消费消息，需要传入交换机、routing-key以及回调函数
-func (r *RabbitMQ) consume(ctx context.Context, exchangeName, routingKey string, callback mq.ConsumeCallBack) {
-	for {
-		//创建 channel
-		r.connect()
-		channel, _ := r.conn.Channel()
-		r.channel = channel
-		notifyClose := r.conn.NotifyClose(make(chan *amqp.Error))
-
-		//创建交换机
-		err := r.channel.ExchangeDeclare(
-			exchangeName,
-			r.exchangeKind,
-			true,
-			false,
-			false,
-			false,
-			nil,
-		)
+//Consume 消费消息, 交给 Concurrency 个协程处理, 连接断开后自动重连, 直到 ctx 结束;
+//ctx 结束后不再接收消息, 等待处理中的消息完成后返回, 未处理的消息由 broker 重新投递
+func (r *RabbitMQ) Consume(ctx context.Context, handler mq.Handler) error {
+	handlerCtx, cancel := mq.DrainContext(ctx, r.DrainTimeout)
+	defer cancel()
 
-		if err != nil {
-			r.err <- fmt.Errorf("failed to declare exchange, err:%v", err)
-			continue
+	retryCount := 0
+	for {
+		err := r.consume(ctx, handlerCtx, handler, func() { retryCount = 0 })
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
-		//手动设置队列名和自动生成队列名的区别.
-		//手动设置：多worker队列名相同，所以会在worker间均衡派发消息
-		//自动生成：多worker队列名不相同，消息会派发给每一位worker
-		queueName := routingKey
-		autoDelete := false
-		if r.Mode == BroadCast {
-			queueName = ""
-			autoDelete = true
+		r.err <- err
+		retryCount++
+		if retryCount > retryLimit {
+			return errors.New("RabbitMQ Service Unavailable")
 		}
-		q, err := r.channel.QueueDeclare(
-			queueName,
-			true,
-			autoDelete,
-			false,
-			false,
-			nil,
-		)
-
-		if err != nil {
-			r.err <- fmt.Errorf("failed to declare queue, err:%v", err)
-			continue
+		if !mq.Sleep(ctx, time.Duration(retryCount)*time.Second) {
+			return ctx.Err()
 		}
+	}
+}
 
-		//队列绑定（将队列、routing-key、交换机三者绑定到一起）
-		err = r.channel.QueueBind(q.Name, routingKey, exchangeName, false, nil)
-		if err != nil {
-			r.err <- fmt.Errorf("failed to QueueBind, err:%v", err)
-			continue
-		}
+//consume 创建 channel 并消费消息, 直到 ctx 结束或 channel 关闭, ready 在开始接收消息时调用, handlerCtx 为传给回调的 ctx
+func (r *RabbitMQ) consume(ctx context.Context, handlerCtx context.Context, handler mq.Handler, ready func()) error {
+	channel, err := r.channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+	notifyClose := channel.NotifyClose(make(chan *amqp.Error, 1))
 
-		//负载均衡策略-根据负载量公平调度
-		//if r.exchangeKind == "direct" && ! r.isBroadCast {
-		//	err := r.channel.Qos(1, 0, false)
-		//	if err != nil {
-		//		logs.CtxError(ctx, "failed to Qos, err:%v", err)
-		//		panic(fmt.Errorf("failed to Qos, err:%v", err))
-		//	}
-		//}
-
-		//消费消息
-		msgs, err := r.channel.Consume(
-			q.Name,
-			"",
-			false,
-			false,
-			false,
-			false,
-			nil,
-		)
-		if err != nil {
-			r.err <- fmt.Errorf("failed to ReceiveNormalMsg, err:%v", err)
-			continue
+	if err := r.declareExchange(channel); err != nil {
+		return err
+	}
+
+	//手动设置队列名和自动生成队列名的区别.
+	//手动设置：多worker队列名相同，所以会在worker间均衡派发消息
+	//自动生成：多worker队列名不相同，消息会派发给每一位worker
+	queueName := r.normalRoutingKey
+	if r.Mode == BroadCast {
+		queueName = ""
+	}
+	q, err := r.declareQueue(channel, queueName, r.Mode == BroadCast)
+	if err != nil {
+		return err
+	}
+
+	//负载均衡策略-根据负载量公平调度, 同时限制了协程池中等待处理的消息数量
+	if err := channel.Qos(r.Prefetch, 0, false); err != nil {
+		return fmt.Errorf("failed to Qos, err:%v", err)
+	}
+
+	//消费消息
+	msgs, err := channel.Consume(
+		q.Name,
+		"",
+		false,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to ReceiveNormalMsg, err:%v", err)
+	}
+	ready()
+
+	//先关闭协程池等待处理中的消息完成, 再关闭 channel, 保证这些消息能被确认
+	pool := mq.NewPool(r.Concurrency, r.Prefetch)
+	defer pool.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-notifyClose:
+			return fmt.Errorf("receive notifyClose: %v", e)
+		case delivery, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf("consume chan has been closed")
+			}
+			key, _ := delivery.Headers[headerKey].(string)
+			pool.Submit(key, func() {
+				r.handle(handlerCtx, channel, q.Name, handler, delivery)
+			})
 		}
+	}
+}
 
-		quit := false
-		for !quit {
-			select {
-			case <-ctx.Done():
-				r.err <- fmt.Errorf("context done")
-				r.destroy()
-				return
-			case e := <-notifyClose:
-				r.err <- fmt.Errorf("receive notifyClose: %v", e)
-				quit = true
-			case msg, ok := <-msgs:
-				if !ok {
-					r.err <- fmt.Errorf("consume chan has been closed")
-					quit = true
-					continue
-				}
-				err := callback(ctx, msg.MessageId, msg.Body, nil)
-				if err != nil {
-					r.err <- fmt.Errorf("callback exec failed, callbackName:%v, callbackResult:%v", mq.GetFuncName(callback), err)
-					continue
-				}
-				msg.Ack(true)
+//handle 处理一条消息, queueName 为消费的队列, 重试的消息会在等待后回到该队列
+func (r *RabbitMQ) handle(ctx context.Context, channel *amqp.Channel, queueName string, handler mq.Handler, delivery amqp.Delivery) {
+	msg := decode(delivery)
+	if msg.Expired() {
+		delivery.Ack(false)
+		return
+	}
+	//此前的投递次数已达上限却仍未确认, 说明每次处理都没有返回, 通常是处理该消息时进程崩溃
+	if r.Retry.Exhausted(msg.Attempts - 1) {
+		r.dead(channel, delivery, msg, mq.ReasonRedelivered, nil)
+		return
+	}
+
+	err := mq.Invoke(ctx, handler, msg)
+	if err == nil {
+		delivery.Ack(false)
+		return
+	}
+
+	r.err <- fmt.Errorf("callback exec failed, callbackName:%v, callbackResult:%v, msg_id:%v, attempts:%v", mq.GetFuncName(handler), err, msg.ID, msg.Attempts)
+	switch {
+	case mq.IsPoison(err):
+		r.dead(channel, delivery, msg, mq.ReasonPoison, err)
+	case r.Retry.Exhausted(msg.Attempts):
+		r.dead(channel, delivery, msg, mq.ReasonMaxAttempts, err)
+	case ctx.Err() != nil:
+		//消费者正在停止, 交还 broker 重新投递
+		delivery.Nack(false, true)
+	default:
+		r.retry(channel, queueName, delivery, msg)
+	}
+}
+
+//retry 经重试队列延时后将消息重新投递到 queueName, 并在消息头中记录已投递的次数
+//广播模式下直接投递到当前消费者的队列, 不会让其他消费者再次收到
+func (r *RabbitMQ) retry(channel *amqp.Channel, queueName string, delivery amqp.Delivery, msg *mq.Message) {
+	delay := roundDelay(r.Retry.Delay(msg.Attempts))
+	name := fmt.Sprintf("retry.%v.%v", queueName, delay.Milliseconds())
+	retryQueue, err := declareDelayQueue(channel, name, delay, "", queueName)
+	if err == nil {
+		err = channel.PublishWithContext(context.Background(), "", retryQueue, false, false, encode(msg))
+	}
+	if err != nil {
+		r.err <- fmt.Errorf("retry message failed: %v, msg_id: %v", err, msg.ID)
+		delivery.Nack(false, true)
+		return
+	}
+	delivery.Ack(false)
+}
+
+//roundDelay 将重试的等待时间取整, 避免随机浮动产生大量不同时长的重试队列: 1 秒以内按 100 毫秒取整, 否则按秒取整
+func roundDelay(delay time.Duration) time.Duration {
+	unit := time.Second
+	if delay < time.Second {
+		unit = 100 * time.Millisecond
+	}
+	rounded := delay.Round(unit)
+	if rounded < unit {
+		rounded = unit
+	}
+	return rounded
+}
+
+func (r *RabbitMQ) declareExchange(channel *amqp.Channel) error {
+	err := channel.ExchangeDeclare(
+		r.normalExchangeName,
+		r.exchangeKind,
+		true,
+		false,
+		false,
+		false,
+		nil,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to declare exchange, err:%v", err)
+	}
+	return nil
+}
+
+//queueArgs 声明队列的参数, 默认的 classic 队列不设置 x-queue-type, 以便与升级前创建的队列保持一致
+func (r *RabbitMQ) queueArgs() amqp.Table {
+	args := amqp.Table{}
+	if r.MaxPriority > 0 {
+		args["x-max-priority"] = r.MaxPriority
+	}
+	if r.QueueType == QueueQuorum {
+		args["x-queue-type"] = QueueQuorum
+	}
+	return args
+}
+
+//declareQueue 创建队列并绑定到交换机, name 为空时由 broker 生成队列名
+func (r *RabbitMQ) declareQueue(channel *amqp.Channel, name string, autoDelete bool) (amqp.Queue, error) {
+	q, err := channel.QueueDeclare(name, true, autoDelete, false, false, r.queueArgs())
+	if err != nil {
+		return q, fmt.Errorf("failed to declare queue, err:%v", err)
+	}
+
+	//队列绑定（将队列、routing-key、交换机三者绑定到一起）
+	if err := channel.QueueBind(q.Name, r.normalRoutingKey, r.normalExchangeName, false, nil); err != nil {
+		return q, fmt.Errorf("failed to QueueBind, err:%v", err)
+	}
+	return q, nil
+}
+
+//declareDelayQueue 创建没有消费者的延时队列, 消息过期后转发到 exchange 的 routingKey, 返回队列名
+func declareDelayQueue(channel *amqp.Channel, name string, delay time.Duration, exchange, routingKey string) (string, error) {
+	ttl := delay.Milliseconds()
+	_, err := channel.QueueDeclare(name, true, false, false, false, amqp.Table{
+		"x-message-ttl":             ttl,      //消息过期时间,毫秒
+		"x-dead-letter-exchange":    exchange, //过期后转发到的交换机
+		"x-dead-letter-routing-key": routingKey,
+		"x-expires":                 ttl + 60000, //闲置一分钟后删除队列
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to declare delay queue, err:%v", err)
+	}
+	return name, nil
+}
+
+//encode 将消息编码为 amqp 消息
+func encode(msg *mq.Message) amqp.Publishing {
+	headers := amqp.Table{}
+	for name, value := range msg.Headers {
+		headers[name] = value
+	}
+	if msg.Key != "" {
+		headers[headerKey] = msg.Key
+	}
+	if msg.Attempts > 0 {
+		headers[headerAttempts] = int32(msg.Attempts)
+	}
+	if !msg.ExpireAt.IsZero() {
+		headers[headerExpireAt] = msg.ExpireAt.UnixNano() / int64(time.Millisecond)
+	}
+
+	return amqp.Publishing{
+		Headers:      headers,
+		ContentType:  msg.ContentType,
+		DeliveryMode: amqp.Persistent,
+		Priority:     msg.Priority,
+		MessageId:    msg.ID,
+		Timestamp:    msg.Timestamp,
+		Body:         msg.Body,
+	}
+}
+
+//decode 将 amqp 消息解码为 Message, Attempts 为包括本次在内的投递次数
+func decode(delivery amqp.Delivery) *mq.Message {
+	msg := &mq.Message{
+		ID:          delivery.MessageId,
+		Body:        delivery.Body,
+		ContentType: delivery.ContentType,
+		Timestamp:   delivery.Timestamp,
+		Priority:    delivery.Priority,
+		Attempts:    1,
+	}
+
+	for name, value := range delivery.Headers {
+		switch name {
+		case headerKey:
+			msg.Key = fmt.Sprint(value)
+		case headerAttempts:
+			msg.Attempts += int(toInt64(value))
+		case headerExpireAt:
+			msg.ExpireAt = time.Unix(0, toInt64(value)*int64(time.Millisecond))
+		case "x-delivery-count":
+			//quorum 队列会记录重复投递的次数
+			msg.Attempts += int(toInt64(value))
+		default:
+			//自定义消息头都是字符串, x-death 等 broker 添加的头忽略
+			if s, ok := value.(string); ok {
+				msg.SetHeader(name, s)
 			}
 		}
+	}
+	//classic 队列只能知道是否为重复投递, 进程反复崩溃时每次都只算作第 2 次投递
+	if _, ok := delivery.Headers["x-delivery-count"]; !ok && delivery.Redelivered {
+		msg.Attempts++
+	}
+	return msg
+}
 
-		r.destroy()
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case int:
+		return int64(v)
+	case int8:
+		return int64(v)
+	case int16:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case uint8:
+		return int64(v)
+	case uint16:
+		return int64(v)
+	case uint32:
+		return int64(v)
 	}
+	return 0
 }
 
 func addPrefix(routingKey string) string {
@@ -342,13 +606,6 @@ func removePrefix(routingKey string) string {
 	return strings.TrimPrefix(routingKey, "normal.")
 }
 
-func replaceNormalToDead(str string) string {
-	if !strings.HasPrefix(str, "normal.") {
-		return str
-	}
-	return strings.Replace(str, "normal.", "dead.", -1)
-}
-
 func getExchangeKind(exchangeName string) string {
 	arr := strings.Split(exchangeName, ".")
 	return arr[len(arr)-1]
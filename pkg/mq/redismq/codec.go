@@ -0,0 +1,141 @@
+package redismq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gin-api/pkg/mq"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/cast"
+)
+
+//stream 中每条消息的字段, 时间均为毫秒时间戳
+const (
+	fieldId          = "id"
+	fieldKey         = "key"
+	fieldHeaders     = "headers"
+	fieldBody        = "body"
+	fieldContentType = "content_type"
+	fieldTimestamp   = "timestamp"
+	fieldPriority    = "priority"
+	fieldExpireAt    = "expire_at"
+	fieldDeliverAt   = "deliver_at" //旧版本将延时消息直接写入 stream, 以该字段记录投递时间
+	fieldAttempts    = "attempts" //此前已投递的次数, 重新发送时累加
+
+	legacyFieldMessage = "message" //旧版本只保存消息内容, timestamp 为 RFC3339 格式的投递时间
+)
+
+//encode 将消息编码为 stream 的字段
+func encode(msg *mq.Message) (map[string]interface{}, error) {
+	values := map[string]interface{}{
+		fieldId:        msg.ID,
+		fieldBody:      msg.Body,
+		fieldTimestamp: toMillis(msg.Timestamp),
+	}
+	if msg.Key != "" {
+		values[fieldKey] = msg.Key
+	}
+	if len(msg.Headers) > 0 {
+		headers, err := json.Marshal(msg.Headers)
+		if err != nil {
+			return nil, err
+		}
+		values[fieldHeaders] = headers
+	}
+	if msg.ContentType != "" {
+		values[fieldContentType] = msg.ContentType
+	}
+	if msg.Priority > 0 {
+		values[fieldPriority] = msg.Priority
+	}
+	if !msg.ExpireAt.IsZero() {
+		values[fieldExpireAt] = toMillis(msg.ExpireAt)
+	}
+	if msg.Attempts > 0 {
+		values[fieldAttempts] = msg.Attempts
+	}
+	return values, nil
+}
+
+//decode 从 stream 的字段解码消息, 返回消息与旧版本记录的投递时间, Attempts 为此前已投递的次数
+func decode(message redis.XMessage) (*mq.Message, time.Time, error) {
+	values := message.Values
+	msg := &mq.Message{ID: cast.ToString(values[fieldId])}
+
+	body, ok := values[fieldBody]
+	if !ok {
+		return decodeLegacy(message)
+	}
+	msg.Body = []byte(cast.ToString(body))
+	msg.Key = cast.ToString(values[fieldKey])
+	msg.ContentType = cast.ToString(values[fieldContentType])
+	msg.Timestamp = fromMillis(values[fieldTimestamp])
+	msg.Priority = cast.ToUint8(values[fieldPriority])
+	msg.ExpireAt = fromMillis(values[fieldExpireAt])
+	msg.Attempts = cast.ToInt(values[fieldAttempts])
+	if headers, ok := values[fieldHeaders]; ok {
+		if err := json.Unmarshal([]byte(cast.ToString(headers)), &msg.Headers); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	if msg.ID == "" {
+		return nil, time.Time{}, errors.New("消息缺少 id")
+	}
+	return msg, fromMillis(values[fieldDeliverAt]), nil
+}
+
+//decodeLegacy 解码旧版本发送的消息, 使用 stream id 作为消息 id
+func decodeLegacy(message redis.XMessage) (*mq.Message, time.Time, error) {
+	body, ok := message.Values[legacyFieldMessage]
+	if !ok {
+		return nil, time.Time{}, errors.New("消息缺少 body")
+	}
+	msg := &mq.Message{ID: message.ID, Body: []byte(cast.ToString(body))}
+
+	var deliverAt time.Time
+	if timestamp, ok := message.Values[fieldTimestamp].(string); ok {
+		var err error
+		if deliverAt, err = time.Parse(time.RFC3339Nano, timestamp); err != nil {
+			return nil, time.Time{}, err
+		}
+	}
+	return msg, deliverAt, nil
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+//fromMillis 毫秒时间戳转为时间, 字段不存在时返回零值
+func fromMillis(value interface{}) time.Time {
+	ms := cast.ToInt64(value)
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// redisDeduplicator 基于 SET NX 的去重, 多个进程共享
+type redisDeduplicator struct {
+	rdb    redis.UniversalClient
+	prefix string
+}
+
+func (d *redisDeduplicator) Claim(ctx context.Context, key string, msgId string, window time.Duration) (bool, string, error) {
+	claimed, err := d.rdb.SetNX(ctx, d.prefix+key, msgId, window).Result()
+	if err != nil || claimed {
+		return claimed, "", err
+	}
+	existing, err := d.rdb.Get(ctx, d.prefix+key).Result()
+	if err == redis.Nil {
+		//占用者恰好过期, 视为重复, 由调用方决定是否重试
+		err = nil
+	}
+	return false, existing, err
+}
+
+func (d *redisDeduplicator) Release(ctx context.Context, key string) error {
+	return d.rdb.Del(ctx, d.prefix+key).Err()
+}
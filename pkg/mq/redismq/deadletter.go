@@ -0,0 +1,151 @@
+package redismq
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gin-api/pkg/mq"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/cast"
+)
+
+//死信 stream 在原消息字段的基础上增加的字段
+const (
+	fieldDeadReason = "dead_reason"
+	fieldDeadError  = "dead_error"
+	fieldDeadAt     = "dead_at"
+)
+
+//deadKey 死信 stream, 每个消费组一个, 广播模式下各组的死信互不影响
+func (r *RedisMQ) deadKey() string {
+	return r.Config.GroupName + ":dead"
+}
+
+//dead 将消息写入死信 stream 并记录已投递的次数, 然后确认并删除原条目
+func (r *RedisMQ) dead(message redis.XMessage, attempts int, reason string, cause error) {
+	values := copyValues(message.Values)
+	if attempts > 0 {
+		values[fieldAttempts] = attempts
+	}
+	values[fieldDeadReason] = reason
+	values[fieldDeadAt] = toMillis(time.Now())
+	if cause != nil {
+		values[fieldDeadError] = cause.Error()
+	}
+
+	err := r.rdb.XAdd(context.Background(), &redis.XAddArgs{
+		Stream:       r.deadKey(),
+		ID:           "*",
+		MaxLenApprox: 100000,
+		Values:       values,
+	}).Err()
+	if err != nil {
+		//写入失败时保留原条目, 闲置超过 ClaimIdle 后重新投递
+		r.err <- fmt.Errorf("move to dead letter queue failed: %v, stream_id: %v", err, message.ID)
+		return
+	}
+	r.err <- fmt.Errorf("message moved to dead letter queue, reason: %v, stream_id: %v", reason, message.ID)
+	r.remove(message.ID)
+}
+
+//Inspect 查看最早进入死信队列的 limit 条消息
+func (r *RedisMQ) Inspect(ctx context.Context, limit int) ([]*mq.DeadLetter, error) {
+	messages, err := r.rdb.XRangeN(ctx, r.deadKey(), "-", "+", int64(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]*mq.DeadLetter, len(messages))
+	for i, message := range messages {
+		letters[i] = decodeDead(message)
+	}
+	return letters, nil
+}
+
+//Replay 将死信重新发送到 stream, 投递次数从 0 开始重新计算, 广播模式下其他消费组也会再次收到
+func (r *RedisMQ) Replay(ctx context.Context, ids ...string) (int, error) {
+	return r.eachDead(ctx, ids, func(message redis.XMessage) error {
+		values := copyValues(message.Values)
+		for _, field := range []string{fieldDeadReason, fieldDeadError, fieldDeadAt, fieldAttempts, fieldDeliverAt, fieldExpireAt} {
+			delete(values, field)
+		}
+		if err := r.rdb.XAdd(ctx, &redis.XAddArgs{Stream: r.Config.QueueName, ID: "*", Values: values}).Err(); err != nil {
+			return err
+		}
+		return r.rdb.XDel(ctx, r.deadKey(), message.ID).Err()
+	})
+}
+
+//Purge 删除死信
+func (r *RedisMQ) Purge(ctx context.Context, ids ...string) (int, error) {
+	if len(ids) == 0 {
+		count, err := r.rdb.XLen(ctx, r.deadKey()).Result()
+		if err != nil {
+			return 0, err
+		}
+		return int(count), r.rdb.Del(ctx, r.deadKey()).Err()
+	}
+	return r.eachDead(ctx, ids, func(message redis.XMessage) error {
+		return r.rdb.XDel(ctx, r.deadKey(), message.ID).Err()
+	})
+}
+
+//eachDead 分批遍历死信 stream, 对 ids 中的死信调用 fn, 返回调用成功的次数
+func (r *RedisMQ) eachDead(ctx context.Context, ids []string, fn func(message redis.XMessage) error) (int, error) {
+	count, start := 0, "-"
+	for {
+		messages, err := r.rdb.XRangeN(ctx, r.deadKey(), start, "+", 100).Result()
+		if err != nil {
+			return count, err
+		}
+		for _, message := range messages {
+			if !mq.MatchId(ids, decodeDead(message).Message.ID) {
+				continue
+			}
+			if err := fn(message); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(messages) < 100 {
+			return count, nil
+		}
+		start = nextStreamId(messages[len(messages)-1].ID)
+	}
+}
+
+//decodeDead 解码死信, 原消息无法解码时以死信 stream 中的 id 作为消息 id, 原始字段放在 Headers 中
+func decodeDead(message redis.XMessage) *mq.DeadLetter {
+	msg, _, err := decode(message)
+	if err != nil {
+		msg = &mq.Message{ID: message.ID}
+		for field, value := range message.Values {
+			msg.SetHeader(field, cast.ToString(value))
+		}
+	}
+
+	return &mq.DeadLetter{
+		Message:  msg,
+		Reason:   cast.ToString(message.Values[fieldDeadReason]),
+		Error:    cast.ToString(message.Values[fieldDeadError]),
+		FailedAt: fromMillis(message.Values[fieldDeadAt]),
+	}
+}
+
+//nextStreamId stream 中紧随 id 之后的 id, 用于分批遍历
+func nextStreamId(id string) string {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return id
+	}
+	return fmt.Sprintf("%v-%v", parts[0], cast.ToUint64(parts[1])+1)
+}
+
+func copyValues(values map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(values)+3)
+	for field, value := range values {
+		copied[field] = value
+	}
+	return copied
+}
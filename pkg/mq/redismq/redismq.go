@@ -3,6 +3,8 @@ package redismq
 import (
 	"context"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"gin-api/pkg/mq"
@@ -10,8 +12,11 @@ import (
 	"github.com/spf13/cast"
 )
 
-//确保 RedisMQ 实现了 mq.Contracts
-var _ mq.Contracts = (*RedisMQ)(nil)
+//确保 RedisMQ 实现了 mq.Contracts 与 mq.DeadLetterQueue
+var (
+	_ mq.Contracts       = (*RedisMQ)(nil)
+	_ mq.DeadLetterQueue = (*RedisMQ)(nil)
+)
 
 //Config 定义了redis-stream 配置信息,多协程下不同的 groupName和consumerName可以模拟出三种消费模式：
 //   1.worker模式(一对一, 启动一个goroutine): groupName和consumerName传空就可以了
@@ -22,16 +27,33 @@ type Config struct {
 	GroupName    string
 	ConsumerName string
 	LogPrintf    func(string, ...interface{})
+
+	BlockTime    time.Duration   //每次阻塞读取新消息的最长时间, 也是 ctx 结束后 Consume 最迟返回的时间, 默认 2 秒
+	ClaimIdle    time.Duration   //消息投递后超过该时长仍未确认(回调失败或消费者宕机)时重新投递, 默认 30 秒
+	Deduplicator mq.Deduplicator //发送端去重, 默认使用 redis 的 SET NX 实现
+	Retry        mq.RetryPolicy  //消费失败后的重试策略, 未设置的字段使用默认值
+	PollInterval time.Duration   //检查到期延时消息的间隔, 也是延时消息的最大误差, 默认 100 毫秒
+
+	Concurrency  int           //同时处理消息的协程数, 默认 1; 大于 1 时只保证同一个 Message.Key 的消息按顺序处理
+	Prefetch     int           //每次读取的消息数量, 也是等待处理的消息数量上限, 默认 10
+	DrainTimeout time.Duration //ctx 结束后等待处理中的消息完成的最长时间, 超时后取消回调的 ctx, 默认 30 秒
+}
+
+// worker 一次 Consume 调用的状态
+type worker struct {
+	ctx      context.Context //传给回调的 ctx, Consume 的 ctx 结束后延迟取消
+	handler  mq.Handler
+	pool     *mq.Pool
+	inflight sync.Map //已分发还未处理完的 stream id, 认领时跳过
 }
 
 type RedisMQ struct {
-	rdb  *redis.Client
-	err  chan error
-	done chan bool
+	rdb redis.UniversalClient
+	err chan error
 	Config
 }
 
-func NewRedisMQ(rdb *redis.Client, queueConfig Config) *RedisMQ {
+func NewRedisMQ(rdb redis.UniversalClient, queueConfig Config) *RedisMQ {
 	if queueConfig.QueueName == "" {
 		panic("please provide the queue name")
 	}
@@ -39,11 +61,28 @@ func NewRedisMQ(rdb *redis.Client, queueConfig Config) *RedisMQ {
 	if queueConfig.LogPrintf == nil {
 		queueConfig.LogPrintf = mq.Printf
 	}
+	if queueConfig.BlockTime <= 0 {
+		queueConfig.BlockTime = 2 * time.Second
+	}
+	if queueConfig.ClaimIdle <= 0 {
+		queueConfig.ClaimIdle = 30 * time.Second
+	}
+	if queueConfig.PollInterval <= 0 {
+		queueConfig.PollInterval = 100 * time.Millisecond
+	}
+	if queueConfig.Concurrency <= 0 {
+		queueConfig.Concurrency = 1
+	}
+	if queueConfig.Prefetch <= 0 {
+		queueConfig.Prefetch = 10
+	}
+	if queueConfig.DrainTimeout <= 0 {
+		queueConfig.DrainTimeout = 30 * time.Second
+	}
 
 	redisMQ := &RedisMQ{
-		rdb:  rdb,
-		err:  make(chan error),
-		done: make(chan bool),
+		rdb: rdb,
+		err: make(chan error),
 	}
 
 	return redisMQ.setConfig(queueConfig)
@@ -63,6 +102,9 @@ func (r *RedisMQ) setConfig(queueConfig Config) *RedisMQ {
 	queueConfig.QueueName = fmt.Sprintf("queue:stream.%v", queueName)
 	queueConfig.GroupName = fmt.Sprintf("queue:stream.%v:group.%v", queueName, groupName)
 	queueConfig.ConsumerName = fmt.Sprintf("queue:stream.%v:group.%v:consumer.%v", queueName, groupName, consumerName)
+	if queueConfig.Deduplicator == nil {
+		queueConfig.Deduplicator = &redisDeduplicator{rdb: r.rdb, prefix: queueConfig.QueueName + ":dedup:"}
+	}
 	r.Config = queueConfig
 
 	go r.log()
@@ -88,159 +130,277 @@ func (r *RedisMQ) log() {
 	}
 }
 
-func (r *RedisMQ) SendNormalMsg(ctx context.Context, msg []byte) (error, string) {
-	return r.publish(ctx, msg, 0)
+func (r *RedisMQ) SendNormalMsg(ctx context.Context, msg []byte) (string, error) {
+	return r.Publish(ctx, mq.NewMessage(msg))
 }
 
 func (r *RedisMQ) ReceiveNormalMsg(ctx context.Context, callback mq.ConsumeCallBack) {
-	r.consume(ctx, callback)
+	r.receive(ctx, callback)
 }
 
-func (r *RedisMQ) SendDelayMsg(ctx context.Context, msg []byte, delay int64) (error, string) {
-	return r.publish(ctx, msg, delay)
+//SendDelayMsg 发送延时消息，delay 为延时投递时间(单位毫秒)
+func (r *RedisMQ) SendDelayMsg(ctx context.Context, msg []byte, delay int64) (string, error) {
+	return r.Publish(ctx, mq.NewMessage(msg), mq.WithDelay(time.Duration(delay)*time.Millisecond))
 }
 
+//ReceiveDelayMsg 延时消息到期后写入同一个 stream, 与 ReceiveNormalMsg 相同
 func (r *RedisMQ) ReceiveDelayMsg(ctx context.Context, callback mq.ConsumeCallBack) {
-	r.consume(ctx, callback)
+	r.receive(ctx, callback)
 }
 
-func (r *RedisMQ) publish(ctx context.Context, msg []byte, delay int64) (error, string) {
-	values := map[string]interface{}{
-		"message": msg,
+func (r *RedisMQ) receive(ctx context.Context, callback mq.ConsumeCallBack) {
+	if err := r.Consume(ctx, callback.Handler(r.Config.ConsumerName)); err != nil && ctx.Err() == nil {
+		r.err <- err
 	}
-	if delay > 0 {
-		timestamp := time.Now().Add(time.Duration(delay) * time.Millisecond).Format(time.RFC3339Nano)
-		values["timestamp"] = timestamp
+}
+
+//Publish 发送消息, 延时消息先保存在有序集合中, 由消费者到期后移动到 stream; 优先级只会随消息保存, stream 不支持按优先级投递
+func (r *RedisMQ) Publish(ctx context.Context, msg *mq.Message, opts ...mq.PublishOption) (string, error) {
+	options, err := mq.PreparePublish(msg, opts...)
+	if err != nil {
+		return "", err
 	}
 
-	msgId, err := r.rdb.XAdd(ctx, &redis.XAddArgs{
-		Stream:       r.Config.QueueName,
-		ID:           "*",
-		MaxLenApprox: 100000,
-		Values:       values,
-	}).Result()
+	if options.DedupKey != "" {
+		claimed, msgId, err := r.Deduplicator.Claim(ctx, options.DedupKey, msg.ID, options.DedupWindow)
+		if err != nil {
+			return "", err
+		}
+		if !claimed {
+			return msgId, mq.ErrDuplicate
+		}
+	}
 
-	return err, msgId
+	values, err := encode(msg)
+	if err == nil && options.Delay > 0 {
+		err = r.schedule(ctx, msg.ID, values, msg.Timestamp.Add(options.Delay), "")
+	} else if err == nil {
+		err = r.rdb.XAdd(ctx, &redis.XAddArgs{
+			Stream:       r.Config.QueueName,
+			ID:           "*",
+			MaxLenApprox: 100000,
+			Values:       values,
+		}).Err()
+	}
+	if err != nil {
+		if options.DedupKey != "" {
+			r.Deduplicator.Release(ctx, options.DedupKey)
+		}
+		return "", err
+	}
+
+	return msg.ID, nil
 }
 
-func (r *RedisMQ) consume(ctx context.Context, callback mq.ConsumeCallBack) {
-	go r.processFailAckMsg(ctx, callback)
+//Consume 消费消息, 每轮先重新投递闲置超过 ClaimIdle 的未确认消息, 再阻塞读取新消息, 交给 Concurrency 个协程处理, 直到 ctx 结束;
+//同时在后台将到期的延时消息移动到 stream。ctx 结束后不再读取消息, 等待已读取的消息处理完毕后返回
+func (r *RedisMQ) Consume(ctx context.Context, handler mq.Handler) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := r.createGroup(ctx); err != nil {
+		return err
+	}
+	go r.promote(ctx)
 
-	retryCount := 0
-	r.rdb.XGroupCreateMkStream(ctx, r.Config.QueueName, r.Config.GroupName, "$")
+	handlerCtx, cancel := mq.DrainContext(ctx, r.Config.DrainTimeout)
+	defer cancel()
+	w := &worker{ctx: handlerCtx, handler: handler, pool: mq.NewPool(r.Config.Concurrency, r.Config.Prefetch)}
+	defer w.pool.Close()
 
+	retryCount := 0
 	for {
-		time.Sleep(time.Duration(2*retryCount) * time.Second)
-		notify := true
-
-		result, err := r.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
-			Group:    r.Config.GroupName,
-			Consumer: r.Config.ConsumerName,
-			Streams:  []string{r.Config.QueueName, ">"},
-			Block:    0,
-		}).Result()
-
-		if err != nil && err != redis.Nil {
-			r.err <- fmt.Errorf("XReadGroup failed : %v", err)
-			retryCount++
-			continue
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
-		if len(result) <= 0 {
-			retryCount++
+		err := r.claim(ctx, w)
+		if err == nil {
+			err = r.read(ctx, w)
+		}
+		if err == nil {
+			retryCount = 0
 			continue
 		}
 
-		for _, stream := range result {
-			for _, message := range stream.Messages {
-				timestamp, ok := message.Values["timestamp"].(string)
-				if ok {
-					deliveryTime, err := time.Parse(time.RFC3339Nano, timestamp)
-					if err != nil {
-						r.err <- fmt.Errorf("delay queue parse time failed: %v", err)
-						continue
-					}
-
-					// 如果消息还没到投递时间，则重新加入延时队列
-					if time.Now().Before(deliveryTime) {
-						//重新入队的消息应从 consumer pending 和 stream 列表中移除
-						r.rdb.XAck(ctx, r.Config.QueueName, r.Config.GroupName, message.ID).Result()
-						r.rdb.XDel(ctx, r.Config.QueueName, message.ID)
-						time.Sleep(deliveryTime.Sub(time.Now()))
-
-						_, err := r.rdb.XAdd(ctx, &redis.XAddArgs{
-							Stream: r.Config.QueueName,
-							ID:     "*",
-							Values: message.Values,
-						}).Result()
-
-						if err != nil {
-							r.err <- fmt.Errorf("delay queue re-entry queue faild: %v", err)
-						}
-
-						notify = false
-						continue
-					}
-				}
-
-				if err := callback(ctx, message.ID, []byte(cast.ToString(message.Values["message"])), r.Config.ConsumerName); err != nil {
-					r.err <- fmt.Errorf("callback exec failed, callbackName: %+v, callbackResult: %v", mq.GetFuncName(callback), err)
-					continue
-				}
-
-				if _, err := r.rdb.XAck(ctx, r.Config.QueueName, r.Config.GroupName, message.ID).Result(); err != nil {
-					r.err <- fmt.Errorf("consume ack faild: %v", err)
-					continue
-				}
-
-				r.rdb.XDel(ctx, r.Config.QueueName, message.ID)
-			}
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
-
-		retryCount = 0
-		if notify {
-			r.done <- notify
+		r.err <- err
+		retryCount++
+		if !mq.Sleep(ctx, backoff(retryCount)) {
+			return ctx.Err()
 		}
 	}
-
 }
 
-func (r *RedisMQ) processFailAckMsg(ctx context.Context, callback mq.ConsumeCallBack) {
-	r.rdb.XGroupCreateMkStream(ctx, r.Config.QueueName, r.Config.GroupName, "0")
+//createGroup 创建消费组, 从 stream 的第一条消息开始消费, 消费组已存在时忽略
+func (r *RedisMQ) createGroup(ctx context.Context) error {
+	err := r.rdb.XGroupCreateMkStream(ctx, r.Config.QueueName, r.Config.GroupName, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("XGroupCreate failed : %v", err)
+	}
+	return nil
+}
 
-	for {
-		<-r.done
+//read 读取新消息
+func (r *RedisMQ) read(ctx context.Context, w *worker) error {
+	result, err := r.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    r.Config.GroupName,
+		Consumer: r.Config.ConsumerName,
+		Streams:  []string{r.Config.QueueName, ">"},
+		Count:    int64(r.Config.Prefetch),
+		Block:    r.Config.BlockTime,
+	}).Result()
 
-		result, err := r.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
-			Group:    r.Config.GroupName,
-			Consumer: r.Config.ConsumerName,
-			Streams:  []string{r.Config.QueueName, "0"},
-			Block:    0,
-		}).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		//stream 被删除后消费组也随之消失, 重新创建
+		if strings.HasPrefix(err.Error(), "NOGROUP") {
+			return r.createGroup(ctx)
+		}
+		return fmt.Errorf("XReadGroup failed : %v", err)
+	}
 
-		if err != nil && err != redis.Nil {
-			r.err <- fmt.Errorf("XReadGroup failed in pendings: %v", err)
-			continue
+	for _, stream := range result {
+		for _, message := range stream.Messages {
+			r.dispatch(w, message, 1)
 		}
+	}
+	return nil
+}
+
+//claim 认领消费组中闲置超过 ClaimIdle 的未确认消息并重新处理, 包括回调失败的消息与已宕机消费者的消息
+func (r *RedisMQ) claim(ctx context.Context, w *worker) error {
+	pending, err := r.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream: r.Config.QueueName,
+		Group:  r.Config.GroupName,
+		Start:  "-",
+		End:    "+",
+		Count:  int64(r.Config.Prefetch),
+	}).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("XPending failed : %v", err)
+	}
 
-		if len(result) <= 0 {
+	ids := make([]string, 0, len(pending))
+	deliveries := make(map[string]int64, len(pending))
+	for _, p := range pending {
+		if _, busy := w.inflight.Load(p.ID); busy {
 			continue
 		}
+		if p.Idle >= r.Config.ClaimIdle {
+			ids = append(ids, p.ID)
+			deliveries[p.ID] = p.RetryCount
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
 
-		for _, stream := range result {
-			for _, message := range stream.Messages {
-				if err := callback(ctx, message.ID, []byte(cast.ToString(message.Values["message"])), r.Config.ConsumerName); err != nil {
-					r.err <- fmt.Errorf("callback exec failed in pendings, callbackName: %v, callbackResult: %v", mq.GetFuncName(callback), err)
-					continue
-				}
+	messages, err := r.rdb.XClaim(ctx, &redis.XClaimArgs{
+		Stream:   r.Config.QueueName,
+		Group:    r.Config.GroupName,
+		Consumer: r.Config.ConsumerName,
+		MinIdle:  r.Config.ClaimIdle,
+		Messages: ids,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("XClaim failed : %v", err)
+	}
 
-				if _, err := r.rdb.XAck(ctx, r.Config.QueueName, r.Config.GroupName, message.ID).Result(); err != nil {
-					r.err <- fmt.Errorf("consume ack faild in pendings: %v", err)
-					continue
-				}
+	//认领会使投递次数加 1
+	for _, message := range messages {
+		r.dispatch(w, message, deliveries[message.ID]+1)
+	}
+	return nil
+}
 
-				r.rdb.XDel(ctx, r.Config.QueueName, message.ID)
-			}
+//dispatch 将消息交给协程池, 同一个 key 的消息由同一个协程处理; 协程池已满时阻塞, 不再读取新消息
+func (r *RedisMQ) dispatch(w *worker, message redis.XMessage, deliveries int64) {
+	w.inflight.Store(message.ID, struct{}{})
+	w.pool.Submit(cast.ToString(message.Values[fieldKey]), func() {
+		defer w.inflight.Delete(message.ID)
+		r.handle(w.ctx, w.handler, message, deliveries)
+	})
+}
+
+//handle 处理一条消息, deliveries 为该消息在 stream 中的投递次数
+func (r *RedisMQ) handle(ctx context.Context, handler mq.Handler, message redis.XMessage, deliveries int64) {
+	msg, deliverAt, err := decode(message)
+	if err != nil {
+		r.dead(message, 0, mq.ReasonMalformed, err)
+		return
+	}
+
+	//旧版本直接写入 stream 的延时消息, 还没到投递时间时转为延时消息
+	if deliverAt.After(time.Now()) {
+		if err := r.schedule(context.Background(), msg.ID, message.Values, deliverAt, message.ID); err != nil {
+			r.err <- fmt.Errorf("schedule delayed message failed: %v, stream_id: %v", err, message.ID)
 		}
+		return
+	}
+
+	msg.Attempts += int(deliveries)
+	if msg.Expired() {
+		r.remove(message.ID)
+		return
+	}
+	//此前的投递次数已达上限却仍未确认, 说明每次处理都没有返回, 通常是处理该消息时进程崩溃
+	if r.Retry.Exhausted(msg.Attempts - 1) {
+		r.dead(message, msg.Attempts, mq.ReasonRedelivered, nil)
+		return
+	}
+
+	err = mq.Invoke(ctx, handler, msg)
+	if err == nil {
+		r.remove(message.ID)
+		return
+	}
+
+	r.err <- fmt.Errorf("callback exec failed, callbackName: %v, callbackResult: %v, msg_id: %v, attempts: %v", mq.GetFuncName(handler), err, msg.ID, msg.Attempts)
+	switch {
+	case mq.IsPoison(err):
+		r.dead(message, msg.Attempts, mq.ReasonPoison, err)
+	case r.Retry.Exhausted(msg.Attempts):
+		r.dead(message, msg.Attempts, mq.ReasonMaxAttempts, err)
+	case ctx.Err() != nil:
+		//消费者正在停止, 消息留在 pending 中, 闲置超过 ClaimIdle 后重新投递
+	default:
+		r.retry(message, msg, r.Retry.Delay(msg.Attempts))
+	}
+}
+
+//retry 在 delay 后重新投递消息: 转为延时消息并记录已投递的次数, 同时确认并删除原条目
+func (r *RedisMQ) retry(message redis.XMessage, msg *mq.Message, delay time.Duration) {
+	values := copyValues(message.Values)
+	values[fieldAttempts] = msg.Attempts
+
+	if err := r.schedule(context.Background(), msg.ID, values, time.Now().Add(delay), message.ID); err != nil {
+		r.err <- fmt.Errorf("retry message failed: %v, stream_id: %v", err, message.ID)
+	}
+}
+
+//remove 确认并删除消息, 使用独立的 context, 保证 ctx 结束前已处理完的消息能被确认
+func (r *RedisMQ) remove(streamId string) {
+	ctx := context.Background()
+	if err := r.rdb.XAck(ctx, r.Config.QueueName, r.Config.GroupName, streamId).Err(); err != nil {
+		r.err <- fmt.Errorf("consume ack faild: %v", err)
+		return
+	}
+	r.rdb.XDel(ctx, r.Config.QueueName, streamId)
+}
+
+//backoff 读取失败后的等待时间, 每次增加 2 秒, 最多 30 秒
+func backoff(retryCount int) time.Duration {
+	wait := time.Duration(2*retryCount) * time.Second
+	if wait > 30*time.Second {
+		wait = 30 * time.Second
 	}
+	return wait
 }
@@ -0,0 +1,41 @@
+package redismq
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"gin-api/pkg/mq"
+	"gin-api/pkg/mq/mqtest"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+//newTestClient 设置了环境变量 REDIS_ADDR 时连接该 redis, 否则使用 miniredis
+func newTestClient(t *testing.T) redis.UniversalClient {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = miniredis.RunT(t).Addr()
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+//newTestMQ 创建使用 mqtest 重试策略与并发数的驱动, BlockTime 缩短以便 ctx 结束后尽快返回
+func newTestMQ(t *testing.T, rdb redis.UniversalClient) *RedisMQ {
+	return NewRedisMQ(rdb, Config{
+		QueueName:   mqtest.QueueName(t),
+		BlockTime:   200 * time.Millisecond,
+		Retry:       mqtest.Retry,
+		Concurrency: mqtest.Concurrency,
+		LogPrintf:   t.Logf,
+	})
+}
+
+func TestRedisMQ(t *testing.T) {
+	rdb := newTestClient(t)
+	mqtest.Run(t, func(t *testing.T) mq.Contracts {
+		return newTestMQ(t, rdb)
+	})
+}
@@ -0,0 +1,117 @@
+package redismq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gin-api/pkg/mq"
+	"github.com/go-redis/redis/v8"
+)
+
+//确保 RedisMQ 实现了 mq.Canceler
+var _ mq.Canceler = (*RedisMQ)(nil)
+
+//promoteBatch 每次最多移动的到期消息数量
+const promoteBatch = 500
+
+//promoteScript 将到期的延时消息移动到 stream, 读取、写入与删除在同一个脚本中完成, 多个消费者同时执行也不会重复投递
+//KEYS[1] 有序集合, KEYS[2] stream; ARGV[1] 当前时间(毫秒), ARGV[2] 最多移动的数量, ARGV[3] 消息内容的 key 前缀, ARGV[4] stream 的最大长度
+var promoteScript = redis.NewScript(`
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, ARGV[2])
+for _, id in ipairs(ids) do
+	local key = ARGV[3] .. id
+	local fields = redis.call('HGETALL', key)
+	if #fields > 0 then
+		redis.call('XADD', KEYS[2], 'MAXLEN', '~', ARGV[4], '*', unpack(fields))
+	end
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('DEL', key)
+end
+return #ids
+`)
+
+//延时消息保存在有序集合中, score 为投递时间(毫秒), member 为消息 id, 消息内容保存在每条消息一个的 hash 中;
+//key 使用 {stream 名} 作为 hash tag, 集群模式下与 stream 位于同一个 slot, 可以在同一个事务或脚本中操作
+func (r *RedisMQ) delayedKey() string {
+	return fmt.Sprintf("{%v}:delayed", r.Config.QueueName)
+}
+
+func (r *RedisMQ) payloadPrefix() string {
+	return fmt.Sprintf("{%v}:delayed:", r.Config.QueueName)
+}
+
+//schedule 在 deliverAt 时将 values 写入 stream; ackId 不为空时同一个事务中确认并删除该 stream 条目, 用于重试与旧版本的延时消息
+//同一个消息 id 只保留最后一次调度
+func (r *RedisMQ) schedule(ctx context.Context, msgId string, values map[string]interface{}, deliverAt time.Time, ackId string) error {
+	payload := copyValues(values)
+	delete(payload, fieldDeliverAt)
+
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		key := r.payloadPrefix() + msgId
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, payload)
+		pipe.ZAdd(ctx, r.delayedKey(), &redis.Z{Score: float64(toMillis(deliverAt)), Member: msgId})
+		if ackId != "" {
+			pipe.XAck(ctx, r.Config.QueueName, r.Config.GroupName, ackId)
+			pipe.XDel(ctx, r.Config.QueueName, ackId)
+		}
+		return nil
+	})
+	return err
+}
+
+//Cancel 取消还未投递的延时消息(包括等待重试的消息), 消息已投递或不存在时返回 false
+func (r *RedisMQ) Cancel(ctx context.Context, msgId string) (bool, error) {
+	var removed *redis.IntCmd
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		removed = pipe.ZRem(ctx, r.delayedKey(), msgId)
+		pipe.Del(ctx, r.payloadPrefix()+msgId)
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed.Val() > 0, nil
+}
+
+//Scheduled 还未投递的延时消息数量
+func (r *RedisMQ) Scheduled(ctx context.Context) (int64, error) {
+	return r.rdb.ZCard(ctx, r.delayedKey()).Result()
+}
+
+//promote 每隔 PollInterval 将到期的延时消息移动到 stream, 直到 ctx 结束
+func (r *RedisMQ) promote(ctx context.Context) {
+	ticker := time.NewTicker(r.Config.PollInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			moved, err := r.promoteDue(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					r.err <- fmt.Errorf("promote delayed messages failed: %v", err)
+				}
+				break
+			}
+			//一次没有移动完时立即继续
+			if moved < promoteBatch {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//promoteDue 移动一批到期的延时消息, 返回移动的数量
+func (r *RedisMQ) promoteDue(ctx context.Context) (int, error) {
+	moved, err := promoteScript.Run(ctx, r.rdb,
+		[]string{r.delayedKey(), r.Config.QueueName},
+		toMillis(time.Now()), promoteBatch, r.payloadPrefix(), 100000,
+	).Int()
+	return moved, err
+}
@@ -0,0 +1,158 @@
+package redismq
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gin-api/pkg/mq"
+	"gin-api/pkg/mq/mqtest"
+	"github.com/go-redis/redis/v8"
+)
+
+//scheduleAt 直接调度一条消息, deliverAt 可以是过去的时间
+func scheduleAt(t *testing.T, r *RedisMQ, body string, deliverAt time.Time) string {
+	t.Helper()
+	msg := mq.NewMessage([]byte(body))
+	if _, err := mq.PreparePublish(msg); err != nil {
+		t.Fatal(err)
+	}
+	values, err := encode(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.schedule(context.Background(), msg.ID, values, deliverAt, ""); err != nil {
+		t.Fatal(err)
+	}
+	return msg.ID
+}
+
+//expectCounts 检查还未投递的延时消息数量与 stream 中的消息数量
+func expectCounts(t *testing.T, r *RedisMQ, scheduled int64, streamed int64) {
+	t.Helper()
+	ctx := context.Background()
+	if n, err := r.Scheduled(ctx); err != nil || n != scheduled {
+		t.Fatalf("Scheduled() = %d, %v, 期望 %d", n, err, scheduled)
+	}
+	if n, err := r.rdb.XLen(ctx, r.QueueName).Result(); err != nil || n != streamed {
+		t.Fatalf("stream 中有 %d 条消息, %v, 期望 %d", n, err, streamed)
+	}
+}
+
+func TestPromoteDue(t *testing.T) {
+	ctx := context.Background()
+	r := newTestMQ(t, newTestClient(t))
+
+	past := time.Now().Add(-time.Second)
+	first := scheduleAt(t, r, "first", past)
+	second := scheduleAt(t, r, "second", past.Add(time.Millisecond))
+	future := scheduleAt(t, r, "future", time.Now().Add(time.Hour))
+	expectCounts(t, r, 3, 0)
+
+	moved, err := r.promoteDue(ctx)
+	if err != nil || moved != 2 {
+		t.Fatalf("promoteDue() = %d, %v, 期望移动 2 条", moved, err)
+	}
+	expectCounts(t, r, 1, 2)
+
+	//已移动的消息内容被删除, 未到期的保留
+	for id, exists := range map[string]int64{first: 0, second: 0, future: 1} {
+		if n := r.rdb.Exists(ctx, r.payloadPrefix()+id).Val(); n != exists {
+			t.Fatalf("消息 %v 的内容 Exists = %d, 期望 %d", id, n, exists)
+		}
+	}
+
+	//按投递时间顺序写入 stream, 字段完整
+	entries, err := r.rdb.XRange(ctx, r.QueueName, "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{first, second} {
+		msg, _, err := decode(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.ID != want {
+			t.Fatalf("stream 第 %d 条消息 id = %v, 期望 %v", i+1, msg.ID, want)
+		}
+	}
+
+	if moved, err := r.promoteDue(ctx); err != nil || moved != 0 {
+		t.Fatalf("再次 promoteDue() = %d, %v, 期望 0", moved, err)
+	}
+}
+
+func TestPromoteBatch(t *testing.T) {
+	ctx := context.Background()
+	r := newTestMQ(t, newTestClient(t))
+
+	past := time.Now().Add(-time.Second)
+	for i := 0; i < promoteBatch+1; i++ {
+		scheduleAt(t, r, "batch", past)
+	}
+
+	if moved, err := r.promoteDue(ctx); err != nil || moved != promoteBatch {
+		t.Fatalf("promoteDue() = %d, %v, 期望 %d", moved, err, promoteBatch)
+	}
+	if moved, err := r.promoteDue(ctx); err != nil || moved != 1 {
+		t.Fatalf("promoteDue() = %d, %v, 期望 1", moved, err)
+	}
+	expectCounts(t, r, 0, promoteBatch+1)
+}
+
+func TestCancel(t *testing.T) {
+	ctx := context.Background()
+	r := newTestMQ(t, newTestClient(t))
+
+	id, err := r.Publish(ctx, mq.NewMessage([]byte("delayed")), mq.WithDelay(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectCounts(t, r, 1, 0)
+
+	if ok, err := r.Cancel(ctx, id); err != nil || !ok {
+		t.Fatalf("Cancel() = %v, %v, 期望 true", ok, err)
+	}
+	if n := r.rdb.Exists(ctx, r.payloadPrefix()+id).Val(); n != 0 {
+		t.Fatal("取消后消息内容未删除")
+	}
+	expectCounts(t, r, 0, 0)
+
+	//重复取消与取消不存在的消息都返回 false
+	if ok, err := r.Cancel(ctx, id); err != nil || ok {
+		t.Fatalf("重复 Cancel() = %v, %v, 期望 false", ok, err)
+	}
+
+	//已投递的消息不能取消
+	due := scheduleAt(t, r, "due", time.Now().Add(-time.Second))
+	if _, err := r.promoteDue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.Cancel(ctx, due); err != nil || ok {
+		t.Fatalf("取消已投递的消息 Cancel() = %v, %v, 期望 false", ok, err)
+	}
+	expectCounts(t, r, 0, 1)
+}
+
+func TestLegacyDelay(t *testing.T) {
+	ctx := context.Background()
+	r := newTestMQ(t, newTestClient(t))
+
+	//旧版本写入的延时消息: 只有 message 与 timestamp 字段, 到期前就在 stream 中
+	due := time.Now().Add(500 * time.Millisecond)
+	err := r.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: r.QueueName,
+		Values: map[string]interface{}{"message": "legacy", "timestamp": due.Format(time.RFC3339Nano)},
+	}).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := mqtest.Start(t, r, nil)
+	msg := c.Next(t)
+	if time.Now().Before(due) || string(msg.Body) != "legacy" {
+		t.Fatalf("旧版本的延时消息提前投递或内容有误: %+v", msg)
+	}
+	c.Stop(t)
+	expectCounts(t, r, 0, 0)
+}
@@ -0,0 +1,131 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+//死信原因
+const (
+	ReasonMaxAttempts = "max_attempts" //重试次数用完
+	ReasonPoison      = "poison"       //回调返回 Poison 错误, 不再重试
+	ReasonMalformed   = "malformed"    //消息无法解码
+	ReasonRedelivered = "redelivered"  //回调未返回就被重复投递超过最大次数, 通常是处理该消息时进程崩溃
+)
+
+// RetryPolicy 消费失败后的重试策略, 第 n 次失败后等待 Backoff * Multiplier^(n-1) 再投递, 最长 MaxBackoff,
+// 并在该时间上随机增减 Jitter 比例以避免大量消息同时重试; 投递 MaxAttempts 次仍失败后进入死信队列
+type RetryPolicy struct {
+	MaxAttempts int           //最多投递次数, 默认 5
+	Backoff     time.Duration //第一次重试前的等待时间, 默认 1 秒
+	MaxBackoff  time.Duration //等待时间上限, 默认 5 分钟
+	Multiplier  float64       //等待时间的增长倍数, 默认 2
+	Jitter      float64       //等待时间的随机浮动比例(0-1), 默认 0.2, 为负数时不浮动
+}
+
+//DefaultRetryPolicy 默认的重试策略
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{}.withDefaults()
+}
+
+//withDefaults 为未设置的字段填充默认值
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 5
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = time.Second
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = 5 * time.Minute
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter == 0 {
+		p.Jitter = 0.2
+	}
+	return p
+}
+
+//Exhausted 第 attempts 次投递失败后是否已没有重试机会
+func (p RetryPolicy) Exhausted(attempts int) bool {
+	return attempts >= p.withDefaults().MaxAttempts
+}
+
+//Delay 第 attempts 次投递失败后到下次投递的等待时间
+func (p RetryPolicy) Delay(attempts int) time.Duration {
+	p = p.withDefaults()
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := float64(p.Backoff) * math.Pow(p.Multiplier, float64(attempts-1))
+	if delay > float64(p.MaxBackoff) {
+		delay = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// poisonError 不应重试的错误
+type poisonError struct {
+	err error
+}
+
+func (e *poisonError) Error() string {
+	return e.err.Error()
+}
+
+func (e *poisonError) Unwrap() error {
+	return e.err
+}
+
+//Poison 包装回调返回的错误, 表示消息本身有问题(如格式错误、引用的数据不存在), 重试也不会成功, 直接进入死信队列
+func Poison(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &poisonError{err: err}
+}
+
+//IsPoison 错误是否由 Poison 包装
+func IsPoison(err error) bool {
+	var poison *poisonError
+	return errors.As(err, &poison)
+}
+
+// DeadLetter 死信队列中的消息
+type DeadLetter struct {
+	Message  *Message
+	Reason   string //进入死信队列的原因, 见 Reason* 常量
+	Error    string //最后一次消费失败的错误信息
+	FailedAt time.Time
+}
+
+// DeadLetterQueue 死信队列的管理接口, 驱动的 Consume 会把重试次数用完、被标记为 Poison 或无法解码的消息写入死信队列
+type DeadLetterQueue interface {
+	//Inspect 查看最早进入死信队列的 limit 条消息, 不会移除消息
+	Inspect(ctx context.Context, limit int) ([]*DeadLetter, error)
+	//Replay 将死信重新发送到正常队列并重置投递次数, 不传 ids 时重新发送全部死信, 返回重新发送的数量
+	Replay(ctx context.Context, ids ...string) (int, error)
+	//Purge 删除死信, 不传 ids 时删除全部死信, 返回删除的数量
+	Purge(ctx context.Context, ids ...string) (int, error)
+}
+
+//MatchId 供驱动判断死信是否在 Replay/Purge 的 ids 中, ids 为空时匹配全部
+func MatchId(ids []string, id string) bool {
+	if len(ids) == 0 {
+		return true
+	}
+	for _, item := range ids {
+		if item == id {
+			return true
+		}
+	}
+	return false
+}
@@ -237,15 +237,6 @@ func LocalIp() (ip string, err error) {
 	return
 }
 
-//RemoteIp 获取远程客户端的IP
-func RemoteIp(r *http.Request) string {
-	forwarded := r.Header.Get("X-FORWARDED-FOR")
-	if forwarded != "" {
-		return forwarded
-	}
-	return r.RemoteAddr
-}
-
 //ProxyForward 实现了一个代理转发
 func ProxyForward(w http.ResponseWriter, r *http.Request, dstUrl string)  {
 	u, _ := url.Parse(dstUrl)
@@ -0,0 +1,115 @@
+package net
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	trustedProxies []*net.IPNet
+	proxyMux       sync.RWMutex
+)
+
+//ParseCIDRs 解析 IP 或 CIDR 列表, 单个 IP 会转换为 /32 或 /128
+func ParseCIDRs(items []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("无效的 IP: %s", item)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 CIDR: %s", item)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+//ContainsIp 判断 ip 是否属于任意一个网段
+func ContainsIp(nets []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+//SetTrustedProxies 设置受信任的代理, RemoteIp 只会信任这些代理转发的 X-Forwarded-For
+func SetTrustedProxies(items []string) error {
+	nets, err := ParseCIDRs(items)
+	if err != nil {
+		return err
+	}
+	proxyMux.Lock()
+	trustedProxies = nets
+	proxyMux.Unlock()
+	return nil
+}
+
+//IsTrustedProxy 判断 ip 是否为受信任的代理
+func IsTrustedProxy(ip string) bool {
+	proxyMux.RLock()
+	defer proxyMux.RUnlock()
+	return ContainsIp(trustedProxies, ip)
+}
+
+//RemoteIp 获取远程客户端的IP
+//只有直连地址是受信任代理时才解析 X-Forwarded-For, 并从右向左跳过受信任代理,
+//第一个不受信任的地址即为客户端 IP, 避免客户端伪造 X-Forwarded-For。
+func RemoteIp(r *http.Request) string {
+	ip := hostOnly(r.RemoteAddr)
+	if !IsTrustedProxy(ip) {
+		return ip
+	}
+
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !IsTrustedProxy(hop) {
+				return hop
+			}
+		}
+		return ip
+	}
+
+	if realIp := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIp) != nil {
+		return realIp
+	}
+	return ip
+}
+
+//hostOnly 去掉地址中的端口
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(strings.TrimSpace(addr))
+	if err != nil {
+		return strings.TrimSpace(addr)
+	}
+	return host
+}
@@ -0,0 +1,32 @@
+package net
+
+import (
+	"gin-api/pkg/signature"
+	"io/ioutil"
+	"net/http"
+)
+
+//SignRequest 按服务端 middleware.Sign 的规则为请求添加签名请求头
+func SignRequest(req *http.Request, appKey, secret string) error {
+	return signature.SignRequest(req, appKey, secret)
+}
+
+//DoSigned 对请求签名后发送, 返回响应内容
+func DoSigned(req *http.Request, appKey, secret string) (string, error) {
+	if err := SignRequest(req, appKey, secret); err != nil {
+		return "", err
+	}
+
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
@@ -0,0 +1,170 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	//jwksTTL 公钥集合的缓存时间
+	jwksTTL = time.Hour
+	//jwksMinRefresh 遇到未知 kid 时两次刷新之间的最小间隔, 避免被恶意 kid 打爆提供方
+	jwksMinRefresh = time.Minute
+)
+
+//jsonWebKey 定义了 JWKS 中单个公钥
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//keySet 缓存提供方的公钥集合
+type keySet struct {
+	url       string
+	client    *http.Client
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	mux       sync.Mutex
+}
+
+func newKeySet(url string, client *http.Client) *keySet {
+	return &keySet{url: url, client: client}
+}
+
+//get 根据 kid 获取公钥, 缓存过期或 kid 未知时(提供方轮换了密钥)重新拉取
+func (k *keySet) get(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mux.Lock()
+	defer k.mux.Unlock()
+
+	if k.url == "" {
+		return nil, errors.New("oidc provider 未配置 jwks 地址")
+	}
+
+	key, ok := k.lookup(kid)
+	expired := time.Since(k.fetchedAt) > jwksTTL
+	if ok && !expired {
+		return key, nil
+	}
+
+	if expired || time.Since(k.fetchedAt) > jwksMinRefresh {
+		if err := k.fetch(ctx); err != nil {
+			return nil, err
+		}
+		if key, ok = k.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("jwks 中找不到 kid=%s 的公钥", kid)
+}
+
+//lookup 查找公钥, kid 为空且只有一个公钥时直接使用该公钥
+func (k *keySet) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(k.keys) == 1 {
+		for _, key := range k.keys {
+			return key, true
+		}
+	}
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+//fetch 拉取并解析公钥集合
+func (k *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("jwks 拉取失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks 拉取失败: status=%d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("jwks 解析失败: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	k.keys = keys
+	k.fetchedAt = time.Now()
+	return nil
+}
+
+//publicKey 将 jwk 转换为公钥
+func (j jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("不支持的曲线: %s", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("不支持的密钥类型: %s", j.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
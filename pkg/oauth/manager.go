@@ -0,0 +1,41 @@
+package oauth
+
+import (
+	"sort"
+	"sync"
+)
+
+var (
+	providers = make(map[string]*Provider)
+	mux       sync.RWMutex
+)
+
+//Register 注册提供方, 同名提供方会被覆盖
+func Register(p *Provider) {
+	mux.Lock()
+	defer mux.Unlock()
+	providers[p.Name] = p
+}
+
+//Get 根据名称获取已注册的提供方
+func Get(name string) (*Provider, error) {
+	mux.RLock()
+	defer mux.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+	return p, nil
+}
+
+//Names 返回所有已注册的提供方名称
+func Names() []string {
+	mux.RLock()
+	defer mux.RUnlock()
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
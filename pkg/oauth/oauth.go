@@ -0,0 +1,283 @@
+// Package oauth 实现 OAuth2 授权码模式(含 PKCE)客户端以及 OpenID Connect 登录
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrProviderNotFound = errors.New("oauth provider 不存在")
+	ErrMissingEndpoint  = errors.New("oauth provider 缺少授权或令牌地址")
+)
+
+//Config 定义了一个第三方登录提供方的配置信息
+type Config struct {
+	//Name 提供方名称, 例如 google, github, sso
+	Name         string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+
+	//Issuer 不为空时通过 {Issuer}/.well-known/openid-configuration 自动发现以下各个地址
+	Issuer      string
+	AuthURL     string
+	TokenURL    string
+	UserInfoURL string
+	JwksURL     string
+
+	//UsePKCE 是否启用 PKCE(S256)
+	UsePKCE bool
+
+	//HTTPClient 请求提供方时使用的客户端, 为空则使用默认客户端
+	HTTPClient *http.Client
+}
+
+//Token 定义了令牌端点返回的数据
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	IDToken      string `json:"id_token"`
+	Scope        string `json:"scope"`
+}
+
+//Identity 定义了从提供方获取到的外部身份
+type Identity struct {
+	Provider      string                 `json:"provider"`
+	Subject       string                 `json:"subject"`
+	Email         string                 `json:"email"`
+	EmailVerified bool                   `json:"email_verified"`
+	Name          string                 `json:"name"`
+	Picture       string                 `json:"picture"`
+	Raw           map[string]interface{} `json:"raw"`
+}
+
+//Provider 一个第三方登录提供方
+type Provider struct {
+	Config
+	jwks       *keySet
+	discovered bool
+	mux        sync.Mutex
+}
+
+//NewProvider 实例化提供方
+func NewProvider(cfg Config) *Provider {
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 && cfg.Issuer != "" {
+		cfg.Scopes = []string{"openid", "profile", "email"}
+	}
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	return &Provider{Config: cfg}
+}
+
+//IsOIDC 是否为 OpenID Connect 提供方
+func (p *Provider) IsOIDC() bool {
+	return p.Issuer != ""
+}
+
+//AuthCodeURL 构造跳转到提供方的授权地址
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	if p.AuthURL == "" {
+		return "", ErrMissingEndpoint
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.ClientID)
+	v.Set("state", state)
+	if p.RedirectURL != "" {
+		v.Set("redirect_uri", p.RedirectURL)
+	}
+	if len(p.Scopes) > 0 {
+		v.Set("scope", strings.Join(p.Scopes, " "))
+	}
+	if nonce != "" && p.IsOIDC() {
+		v.Set("nonce", nonce)
+	}
+	if p.UsePKCE && verifier != "" {
+		v.Set("code_challenge", ChallengeS256(verifier))
+		v.Set("code_challenge_method", "S256")
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthURL, "?") {
+		sep = "&"
+	}
+	return p.AuthURL + sep + v.Encode(), nil
+}
+
+//Exchange 使用授权码换取令牌
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+	if p.TokenURL == "" {
+		return nil, ErrMissingEndpoint
+	}
+
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	if p.RedirectURL != "" {
+		v.Set("redirect_uri", p.RedirectURL)
+	}
+	if p.UsePKCE && verifier != "" {
+		v.Set("code_verifier", verifier)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenURL, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+
+	body, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	token := &Token{}
+	if err := json.Unmarshal(body, token); err != nil {
+		return nil, fmt.Errorf("oauth 令牌解析失败: %v", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("oauth 令牌端点未返回 access_token")
+	}
+	return token, nil
+}
+
+//UserInfo 通过 access_token 获取用户信息
+func (p *Provider) UserInfo(ctx context.Context, accessToken string) (map[string]interface{}, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+	if p.UserInfoURL == "" {
+		return nil, errors.New("oauth provider 未配置 userinfo 地址")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.UserInfoURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+
+	body, err := p.do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	info := make(map[string]interface{})
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("oauth userinfo 解析失败: %v", err)
+	}
+	return info, nil
+}
+
+//Identity 基于令牌获取外部身份，OIDC 提供方校验 id_token, 否则请求 userinfo 地址
+func (p *Provider) Identity(ctx context.Context, token *Token, nonce string) (*Identity, error) {
+	var claims map[string]interface{}
+	if p.IsOIDC() {
+		if token.IDToken == "" {
+			return nil, errors.New("oidc 令牌端点未返回 id_token")
+		}
+		c, err := p.VerifyIDToken(ctx, token.IDToken, nonce)
+		if err != nil {
+			return nil, err
+		}
+		claims = c
+	}
+
+	//id_token 中没有 email 等信息时, 再通过 userinfo 补全
+	if p.UserInfoURL != "" && (claims == nil || claims["email"] == nil) {
+		info, err := p.UserInfo(ctx, token.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		if claims != nil && info["sub"] != nil && fmt.Sprint(info["sub"]) != fmt.Sprint(claims["sub"]) {
+			return nil, errors.New("userinfo 与 id_token 的 sub 不一致")
+		}
+		if claims == nil {
+			claims = info
+		} else {
+			for k, v := range info {
+				if _, ok := claims[k]; !ok {
+					claims[k] = v
+				}
+			}
+		}
+	}
+
+	if claims == nil {
+		return nil, errors.New("oauth 无法获取用户身份")
+	}
+	return newIdentity(p.Name, claims)
+}
+
+//newIdentity 将声明映射为 Identity, 非 OIDC 提供方通常使用 id 作为唯一标识
+func newIdentity(provider string, claims map[string]interface{}) (*Identity, error) {
+	identity := &Identity{Provider: provider, Raw: claims}
+	for _, key := range []string{"sub", "id", "user_id"} {
+		if v, ok := claims[key]; ok && v != nil {
+			identity.Subject = jsonString(v)
+			break
+		}
+	}
+	if identity.Subject == "" {
+		return nil, errors.New("oauth 用户身份缺少唯一标识")
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	identity.Picture, _ = claims["picture"].(string)
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = v
+	case string:
+		identity.EmailVerified = v == "true"
+	}
+	return identity, nil
+}
+
+//jsonString 将 json 解码后的值转为字符串, 避免大整数被格式化成科学计数法
+func jsonString(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return fmt.Sprintf("%.0f", f)
+	}
+	return fmt.Sprint(v)
+}
+
+//do 发送请求并返回响应内容
+func (p *Provider) do(req *http.Request) ([]byte, error) {
+	resp, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("oauth 请求 %s 失败: status=%d, body=%s", req.URL.Path, resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,229 @@
+package oauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//clockSkew 校验 id_token 时间时允许的时钟误差
+const clockSkew = time.Minute
+
+//discovery 定义了 .well-known/openid-configuration 中需要用到的字段
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JwksURI               string `json:"jwks_uri"`
+}
+
+//discover 通过 OIDC 发现机制补全提供方的各个地址, 只在第一次成功后生效
+func (p *Provider) discover(ctx context.Context) error {
+	p.mux.Lock()
+	defer p.mux.Unlock()
+
+	if p.discovered || !p.IsOIDC() {
+		return nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return err
+	}
+	body, err := p.do(req)
+	if err != nil {
+		return fmt.Errorf("oidc discovery 失败: %v", err)
+	}
+
+	var d discovery
+	if err := json.Unmarshal(body, &d); err != nil {
+		return fmt.Errorf("oidc discovery 解析失败: %v", err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.Issuer {
+		return fmt.Errorf("oidc issuer 不匹配: 期望 %s, 实际 %s", p.Issuer, d.Issuer)
+	}
+
+	//手动配置的地址优先
+	if p.AuthURL == "" {
+		p.AuthURL = d.AuthorizationEndpoint
+	}
+	if p.TokenURL == "" {
+		p.TokenURL = d.TokenEndpoint
+	}
+	if p.UserInfoURL == "" {
+		p.UserInfoURL = d.UserinfoEndpoint
+	}
+	if p.JwksURL == "" {
+		p.JwksURL = d.JwksURI
+	}
+	p.jwks = newKeySet(p.JwksURL, p.HTTPClient)
+	p.discovered = true
+	return nil
+}
+
+//VerifyIDToken 校验 id_token 的签名以及 iss/aud/exp/nonce 等声明, 成功后返回全部声明
+func (p *Provider) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (map[string]interface{}, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+	if p.jwks == nil {
+		p.jwks = newKeySet(p.JwksURL, p.HTTPClient)
+	}
+
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token 格式有误")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("id_token header 解析失败: %v", err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("id_token 签名解析失败: %v", err)
+	}
+
+	key, err := p.jwks.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]interface{})
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("id_token payload 解析失败: %v", err)
+	}
+	if err := p.validateClaims(claims, nonce); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+//validateClaims 校验 id_token 中的标准声明
+func (p *Provider) validateClaims(claims map[string]interface{}, nonce string) error {
+	now := time.Now()
+
+	if iss, _ := claims["iss"].(string); strings.TrimRight(iss, "/") != p.Issuer {
+		return fmt.Errorf("id_token iss 不匹配: %s", iss)
+	}
+
+	var audiences []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		audiences = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				audiences = append(audiences, s)
+			}
+		}
+	}
+	matched := false
+	for _, aud := range audiences {
+		if aud == p.ClientID {
+			matched = true
+		}
+	}
+	if !matched {
+		return errors.New("id_token aud 不包含当前 client_id")
+	}
+	if azp, ok := claims["azp"].(string); ok && len(audiences) > 1 && azp != p.ClientID {
+		return errors.New("id_token azp 不匹配")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("id_token 缺少 exp")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(clockSkew)) {
+		return errors.New("id_token 已过期")
+	}
+	if iat, ok := claims["iat"].(float64); ok && time.Unix(int64(iat), 0).After(now.Add(clockSkew)) {
+		return errors.New("id_token 签发时间大于当前时间")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && time.Unix(int64(nbf), 0).After(now.Add(clockSkew)) {
+		return errors.New("id_token 还未生效")
+	}
+
+	if nonce != "" {
+		if n, _ := claims["nonce"].(string); n != nonce {
+			return errors.New("id_token nonce 不匹配")
+		}
+	}
+	return nil
+}
+
+//verifySignature 根据 alg 校验签名
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var h crypto.Hash
+	switch alg {
+	case "RS256", "ES256", "PS256":
+		h = crypto.SHA256
+	case "RS384", "ES384", "PS384":
+		h = crypto.SHA384
+	case "RS512", "ES512", "PS512":
+		h = crypto.SHA512
+	default:
+		return fmt.Errorf("id_token 不支持的签名算法: %s", alg)
+	}
+	hasher := h.New()
+	hasher.Write(signed)
+	digest := hasher.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		if strings.HasPrefix(alg, "PS") {
+			err = rsa.VerifyPSS(pub, h, digest, signature, nil)
+		} else if strings.HasPrefix(alg, "RS") {
+			err = rsa.VerifyPKCS1v15(pub, h, digest, signature)
+		} else {
+			err = errors.New("算法与密钥类型不匹配")
+		}
+		if err != nil {
+			return fmt.Errorf("id_token 签名无效: %v", err)
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return errors.New("id_token 签名无效: 算法与密钥类型不匹配")
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return errors.New("id_token 签名长度有误")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("id_token 签名无效")
+		}
+	default:
+		return errors.New("id_token 不支持的密钥类型")
+	}
+	return nil
+}
+
+//decodeSegment 解码 jwt 中 base64url 编码的 json 片段
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,35 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+//GenerateVerifier 生成 PKCE 的 code_verifier (RFC 7636 要求 43~128 位的随机字符串)
+func GenerateVerifier() string {
+	return randomString(32)
+}
+
+//ChallengeS256 基于 code_verifier 计算 S256 方式的 code_challenge
+func ChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+//GenerateState 生成用于防止 CSRF 的 state 参数
+func GenerateState() string {
+	return randomString(24)
+}
+
+//GenerateNonce 生成 OIDC 的 nonce 参数，用于防止 id_token 重放
+func GenerateNonce() string {
+	return randomString(24)
+}
+
+//randomString 生成 n 字节随机数并进行 base64url 编码
+func randomString(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// CheckIn 基于位图的每日签到, 每个用户每月一个 key, 第 n 天对应第 n-1 位, 一个月最多占用 4 个字节
+type CheckIn struct {
+	rds    *RedisClient
+	prefix string
+}
+
+//NewCheckIn 实例化签到, key 为 prefix + 用户标识 + ":" + 年月, 如 "checkin:1001:202401"
+func NewCheckIn(rds *RedisClient, prefix string) *CheckIn {
+	return &CheckIn{rds: rds, prefix: prefix}
+}
+
+//key 用户在 day 所在月份的 key
+func (c *CheckIn) key(user string, day time.Time) string {
+	return c.prefix + user + ":" + day.Format("200601")
+}
+
+//Sign 签到, 返回是否为当天首次签到
+func (c *CheckIn) Sign(ctx context.Context, user string, day time.Time) (bool, error) {
+	old, err := c.rds.SetBit(ctx, c.key(user, day), int64(day.Day()-1), true)
+	return !old, err
+}
+
+//Signed 当天是否已签到
+func (c *CheckIn) Signed(ctx context.Context, user string, day time.Time) (bool, error) {
+	return c.rds.GetBit(ctx, c.key(user, day), int64(day.Day()-1))
+}
+
+//MonthCount month 所在月份的签到天数
+func (c *CheckIn) MonthCount(ctx context.Context, user string, month time.Time) (int64, error) {
+	return c.rds.BitCount(ctx, c.key(user, month))
+}
+
+//Streak 截至 day 当月连续签到的天数, 当天未签到时从前一天开始计算
+func (c *CheckIn) Streak(ctx context.Context, user string, day time.Time) (int, error) {
+	//一个月的位图最多 4 个字节, 直接读取整个位图后在本地计算, 位图中每个字节的最高位在前
+	bitmap, err := c.rds.Client.Get(ctx, c.key(user, day)).Bytes()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	signed := func(offset int) bool {
+		return offset/8 < len(bitmap) && bitmap[offset/8]>>(7-offset%8)&1 == 1
+	}
+
+	offset := day.Day() - 1
+	if !signed(offset) {
+		offset--
+	}
+	streak := 0
+	for ; offset >= 0 && signed(offset); offset-- {
+		streak++
+	}
+	return streak, nil
+}
+
+// BloomFilter 基于位图的布隆过滤器, 用于判断元素是否"可能存在", 如拦截不存在的 id 以防止缓存穿透。
+// 判断为不存在时一定不存在, 判断为存在时有 fpRate 的概率误判; 元素只能添加不能删除
+type BloomFilter struct {
+	rds    *RedisClient
+	key    string
+	bits   uint64
+	hashes int
+}
+
+//NewBloomFilter 按预计的元素数量 expected 与可接受的误判率 fpRate 计算位图大小与哈希次数,
+//如 100 万个元素、误判率 1% 时约占用 1.2MB, 哈希 7 次。实际元素数量远超 expected 时误判率会迅速上升;
+//fpRate 必须在 (0, 1) 之间, 否则 panic
+func NewBloomFilter(rds *RedisClient, key string, expected uint64, fpRate float64) *BloomFilter {
+	if !(fpRate > 0 && fpRate < 1) {
+		panic(fmt.Sprintf("redis 布隆过滤器 %s 的误判率 %v 必须在 (0, 1) 之间", key, fpRate))
+	}
+	if expected == 0 {
+		expected = 1
+	}
+	bits := math.Max(1, math.Ceil(-float64(expected)*math.Log(fpRate)/(math.Ln2*math.Ln2)))
+	hashes := int(math.Round(bits / float64(expected) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+	return &BloomFilter{rds: rds, key: key, bits: uint64(bits), hashes: hashes}
+}
+
+//offsets 元素对应的各个位, 使用双重哈希 h1 + i*h2 模拟 k 个哈希函数
+func (b *BloomFilter) offsets(element string) []int64 {
+	h := fnv.New64a()
+	h.Write([]byte(element))
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	offsets := make([]int64, b.hashes)
+	for i := range offsets {
+		offsets[i] = int64((h1 + uint64(i)*h2) % b.bits)
+	}
+	return offsets
+}
+
+//Add 添加元素, 使用 pipeline 一次提交
+func (b *BloomFilter) Add(ctx context.Context, elements ...string) error {
+	_, err := b.rds.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, element := range elements {
+			for _, offset := range b.offsets(element) {
+				pipe.SetBit(ctx, b.key, offset, 1)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+//Exists 元素是否可能存在, 返回 false 时一定不存在
+func (b *BloomFilter) Exists(ctx context.Context, element string) (bool, error) {
+	offsets := b.offsets(element)
+	cmds := make([]*redis.IntCmd, len(offsets))
+	_, err := b.rds.Client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, offset := range offsets {
+			cmds[i] = pipe.GetBit(ctx, b.key, offset)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		if cmd.Val() == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,119 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+//Nil key 不存在, 与 go-redis 的 redis.Nil 相同, 如 LPop 在列表为空时返回该错误
+const Nil = redis.Nil
+
+//以下方法直接返回 go-redis 的错误, 不记录日志, 由调用方决定如何处理
+
+//LPush 从列表头部插入, 返回插入后列表的长度
+func (rds *RedisClient) LPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	return rds.Client.LPush(ctx, key, values...).Result()
+}
+
+//RPush 从列表尾部插入, 返回插入后列表的长度
+func (rds *RedisClient) RPush(ctx context.Context, key string, values ...interface{}) (int64, error) {
+	return rds.Client.RPush(ctx, key, values...).Result()
+}
+
+//LPop 从列表头部弹出, 列表为空时返回 Nil
+func (rds *RedisClient) LPop(ctx context.Context, key string) (string, error) {
+	return rds.Client.LPop(ctx, key).Result()
+}
+
+//RPop 从列表尾部弹出, 列表为空时返回 Nil
+func (rds *RedisClient) RPop(ctx context.Context, key string) (string, error) {
+	return rds.Client.RPop(ctx, key).Result()
+}
+
+//BLPop 阻塞地从多个列表的头部弹出, 返回 [key, value], 超时返回 Nil, timeout 为 0 时一直阻塞
+func (rds *RedisClient) BLPop(ctx context.Context, timeout time.Duration, keys ...string) ([]string, error) {
+	return rds.Client.BLPop(ctx, timeout, keys...).Result()
+}
+
+//LRange 读取列表 [start, stop] 之间的元素, 下标从 0 开始, -1 表示最后一个
+func (rds *RedisClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return rds.Client.LRange(ctx, key, start, stop).Result()
+}
+
+//LLen 列表长度
+func (rds *RedisClient) LLen(ctx context.Context, key string) (int64, error) {
+	return rds.Client.LLen(ctx, key).Result()
+}
+
+//LTrim 只保留列表 [start, stop] 之间的元素, 常与 LPush 配合保存最近的 N 条记录
+func (rds *RedisClient) LTrim(ctx context.Context, key string, start, stop int64) error {
+	return rds.Client.LTrim(ctx, key, start, stop).Err()
+}
+
+//SAdd 向集合添加成员, 返回新增的数量
+func (rds *RedisClient) SAdd(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return rds.Client.SAdd(ctx, key, members...).Result()
+}
+
+//SRem 从集合删除成员, 返回删除的数量
+func (rds *RedisClient) SRem(ctx context.Context, key string, members ...interface{}) (int64, error) {
+	return rds.Client.SRem(ctx, key, members...).Result()
+}
+
+//SIsMember 是否为集合的成员
+func (rds *RedisClient) SIsMember(ctx context.Context, key string, member interface{}) (bool, error) {
+	return rds.Client.SIsMember(ctx, key, member).Result()
+}
+
+//SMembers 集合的全部成员, 成员较多时使用 SScan
+func (rds *RedisClient) SMembers(ctx context.Context, key string) ([]string, error) {
+	return rds.Client.SMembers(ctx, key).Result()
+}
+
+//SScan 分批遍历集合, cursor 从 0 开始, 返回的 cursor 为 0 时遍历结束
+func (rds *RedisClient) SScan(ctx context.Context, key string, cursor uint64, match string, count int64) ([]string, uint64, error) {
+	return rds.Client.SScan(ctx, key, cursor, match, count).Result()
+}
+
+//SCard 集合的成员数量
+func (rds *RedisClient) SCard(ctx context.Context, key string) (int64, error) {
+	return rds.Client.SCard(ctx, key).Result()
+}
+
+//PFAdd 向 HyperLogLog 添加元素, 用于 UV 等去重计数, 每个 key 固定占用约 12KB, 误差约 0.81%
+func (rds *RedisClient) PFAdd(ctx context.Context, key string, elements ...interface{}) error {
+	return rds.Client.PFAdd(ctx, key, elements...).Err()
+}
+
+//PFCount 去重后的元素数量(估算值), 传入多个 key 时返回合并后的数量
+func (rds *RedisClient) PFCount(ctx context.Context, keys ...string) (int64, error) {
+	return rds.Client.PFCount(ctx, keys...).Result()
+}
+
+//PFMerge 将多个 HyperLogLog 合并到 dest, 如按天统计的 UV 合并为周 UV
+func (rds *RedisClient) PFMerge(ctx context.Context, dest string, keys ...string) error {
+	return rds.Client.PFMerge(ctx, dest, keys...).Err()
+}
+
+//SetBit 设置位图中第 offset 位的值, 返回原来的值
+func (rds *RedisClient) SetBit(ctx context.Context, key string, offset int64, value bool) (bool, error) {
+	bit := 0
+	if value {
+		bit = 1
+	}
+	old, err := rds.Client.SetBit(ctx, key, offset, bit).Result()
+	return old == 1, err
+}
+
+//GetBit 读取位图中第 offset 位的值
+func (rds *RedisClient) GetBit(ctx context.Context, key string, offset int64) (bool, error) {
+	bit, err := rds.Client.GetBit(ctx, key, offset).Result()
+	return bit == 1, err
+}
+
+//BitCount 位图中值为 1 的位数
+func (rds *RedisClient) BitCount(ctx context.Context, key string) (int64, error) {
+	return rds.Client.BitCount(ctx, key, nil).Result()
+}
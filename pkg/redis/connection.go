@@ -0,0 +1,168 @@
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"gin-api/pkg/config"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	"github.com/spf13/cast"
+)
+
+//部署模式
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+var (
+	//connections 已建立的命名连接
+	connections = make(map[string]*RedisClient)
+	connMux     sync.Mutex
+)
+
+//Connection 返回配置 redis.connections 中名为 name 的连接, 同名的连接只建立一次, 用法如下:
+//	redis.Connection("queue").Client.XAdd(...)
+//配置有误时 panic
+func Connection(name string) *RedisClient {
+	connMux.Lock()
+	defer connMux.Unlock()
+	if rds, ok := connections[name]; ok {
+		return rds
+	}
+
+	mode, opts, err := ConnectionOptions(name)
+	if err != nil {
+		panic(fmt.Sprintf("redis 连接 %s 配置有误: %v", name, err))
+	}
+	rds, err := NewUniversalClient(mode, opts)
+	if err != nil {
+		panic(fmt.Sprintf("redis 连接 %s 配置有误: %v", name, err))
+	}
+	connections[name] = rds
+	return rds
+}
+
+//NewUniversalClient 按部署模式实例化 RedisClient, mode 为空时视为单节点
+func NewUniversalClient(mode string, opts *redis.UniversalOptions) (*RedisClient, error) {
+	var client redis.UniversalClient
+	switch mode {
+	case "", ModeStandalone:
+		client = redis.NewClient(opts.Simple())
+	case ModeSentinel:
+		if opts.MasterName == "" {
+			return nil, errors.New("哨兵模式需要设置 master_name")
+		}
+		client = redis.NewFailoverClient(opts.Failover())
+	case ModeCluster:
+		if opts.DB != 0 {
+			return nil, errors.New("集群模式只能使用 0 号库")
+		}
+		client = redis.NewClusterClient(opts.Cluster())
+	default:
+		return nil, errors.New("不支持的部署模式: " + mode)
+	}
+
+	rds := &RedisClient{Client: client, Ctx: context.Background()}
+	rds.Ping()
+	return rds, nil
+}
+
+//ConnectionOptions 读取命名连接的配置, redis.connections.<name> 中的配置项覆盖 redis 下的同名配置
+func ConnectionOptions(name string) (string, *redis.UniversalOptions, error) {
+	return parseSettings(config.GetStringMap("redis"), config.GetStringMap("redis.connections."+name))
+}
+
+//parseSettings 合并 redis 配置 base 与命名连接的配置 overrides, 返回部署模式与连接选项
+func parseSettings(base map[string]interface{}, overrides map[string]interface{}) (string, *redis.UniversalOptions, error) {
+	settings := mergeSettings(base, overrides)
+	delete(settings, "connections")
+
+	mode := cast.ToString(settings["mode"])
+	opts := &redis.UniversalOptions{
+		Username:         cast.ToString(settings["username"]),
+		Password:         cast.ToString(settings["password"]),
+		SentinelPassword: cast.ToString(settings["sentinel_password"]),
+		MasterName:       cast.ToString(settings["master_name"]),
+		DB:               cast.ToInt(settings["database"]),
+	}
+	//集群只有 0 号库, 忽略 database 配置(业务、限流、队列默认使用的 1 号库)
+	if mode == ModeCluster {
+		opts.DB = 0
+	}
+
+	if url := cast.ToString(settings["url"]); url != "" && (mode == "" || mode == ModeStandalone) {
+		parsed, err := redis.ParseURL(url)
+		if err != nil {
+			return "", nil, err
+		}
+		opts.Addrs = []string{parsed.Addr}
+		opts.Username, opts.Password = parsed.Username, parsed.Password
+		opts.TLSConfig = parsed.TLSConfig
+		//命名连接单独设置了 database 时(如缓存使用单独的库)以命名连接为准
+		if overrides["database"] == nil {
+			opts.DB = parsed.DB
+		}
+	} else if addrs := cast.ToString(settings["addrs"]); addrs != "" {
+		for _, addr := range strings.Split(addrs, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				opts.Addrs = append(opts.Addrs, addr)
+			}
+		}
+	} else {
+		opts.Addrs = []string{net.JoinHostPort(cast.ToString(settings["host"]), cast.ToString(settings["port"]))}
+	}
+
+	tlsSettings := cast.ToStringMap(settings["tls"])
+	if cast.ToBool(tlsSettings["enable"]) && opts.TLSConfig == nil {
+		serverName := cast.ToString(tlsSettings["server_name"])
+		if serverName == "" {
+			serverName, _, _ = net.SplitHostPort(opts.Addrs[0])
+		}
+		opts.TLSConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			ServerName:         serverName,
+			InsecureSkipVerify: cast.ToBool(tlsSettings["insecure_skip_verify"]),
+		}
+	}
+
+	pool := cast.ToStringMap(settings["pool"])
+	millis := func(key string) time.Duration {
+		return time.Duration(cast.ToInt(pool[key])) * time.Millisecond
+	}
+	opts.PoolSize = cast.ToInt(pool["size"])
+	opts.MinIdleConns = cast.ToInt(pool["min_idle"])
+	opts.MaxRetries = cast.ToInt(pool["max_retries"])
+	opts.DialTimeout = millis("dial_timeout")
+	opts.ReadTimeout = millis("read_timeout")
+	opts.WriteTimeout = millis("write_timeout")
+	opts.PoolTimeout = millis("pool_timeout")
+	opts.IdleTimeout = millis("idle_timeout")
+
+	return mode, opts, nil
+}
+
+//mergeSettings 返回 base 的副本并用 overrides 覆盖其中的同名配置, 值为 nil 或空字符串时不覆盖, 嵌套的配置逐项合并
+func mergeSettings(base map[string]interface{}, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		if value == nil || value == "" {
+			continue
+		}
+		if nested, ok := value.(map[string]interface{}); ok {
+			value = mergeSettings(cast.ToStringMap(merged[key]), nested)
+		}
+		merged[key] = value
+	}
+	return merged
+}
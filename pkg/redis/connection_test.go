@@ -0,0 +1,32 @@
+package redis
+
+import "testing"
+
+func TestParseSettingsDatabase(t *testing.T) {
+	base := map[string]interface{}{
+		"mode":     ModeStandalone,
+		"host":     "127.0.0.1",
+		"port":     "6379",
+		"database": 1,
+	}
+
+	_, opts, err := parseSettings(base, map[string]interface{}{"database": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.DB != 2 || opts.Addrs[0] != "127.0.0.1:6379" {
+		t.Fatalf("单节点: DB=%d Addrs=%v", opts.DB, opts.Addrs)
+	}
+
+	//集群模式忽略默认的 1 号库与命名连接中的 database
+	base["mode"], base["addrs"] = ModeCluster, "10.0.0.1:6379, 10.0.0.2:6379"
+	for _, overrides := range []map[string]interface{}{{}, {"database": 2}} {
+		mode, opts, err := parseSettings(base, overrides)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != ModeCluster || opts.DB != 0 || len(opts.Addrs) != 2 {
+			t.Fatalf("集群 %v: mode=%s DB=%d Addrs=%v", overrides, mode, opts.DB, opts.Addrs)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package redis
+
+import (
+	"context"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// Leaderboard 基于有序集合的排行榜, 分数越高排名越靠前, 排名从 1 开始
+type Leaderboard struct {
+	rds *RedisClient
+	key string
+}
+
+// RankEntry 排行榜中的一项
+type RankEntry struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+	Rank   int64   `json:"rank"`
+}
+
+//NewLeaderboard 实例化排行榜, 用法如下:
+//	board := redis.NewLeaderboard(redis.DefaultClient(), "rank:game:2024")
+//	board.Incr(ctx, "user:1", 10)
+//	entries, err := board.Page(ctx, 1, 20)
+func NewLeaderboard(rds *RedisClient, key string) *Leaderboard {
+	return &Leaderboard{rds: rds, key: key}
+}
+
+//Set 设置成员的分数
+func (l *Leaderboard) Set(ctx context.Context, member string, score float64) error {
+	return l.rds.Client.ZAdd(ctx, l.key, &redis.Z{Score: score, Member: member}).Err()
+}
+
+//Incr 增加成员的分数, 返回增加后的分数, 成员不存在时从 0 开始
+func (l *Leaderboard) Incr(ctx context.Context, member string, delta float64) (float64, error) {
+	return l.rds.Client.ZIncrBy(ctx, l.key, delta, member).Result()
+}
+
+//Remove 移除成员
+func (l *Leaderboard) Remove(ctx context.Context, members ...string) error {
+	values := make([]interface{}, len(members))
+	for i, member := range members {
+		values[i] = member
+	}
+	return l.rds.Client.ZRem(ctx, l.key, values...).Err()
+}
+
+//Score 成员的分数, 成员不存在时返回 Nil
+func (l *Leaderboard) Score(ctx context.Context, member string) (float64, error) {
+	return l.rds.Client.ZScore(ctx, l.key, member).Result()
+}
+
+//Rank 成员的排名, 成员不存在时返回 Nil
+func (l *Leaderboard) Rank(ctx context.Context, member string) (int64, error) {
+	rank, err := l.rds.Client.ZRevRank(ctx, l.key, member).Result()
+	if err != nil {
+		return 0, err
+	}
+	return rank + 1, nil
+}
+
+//Count 成员总数
+func (l *Leaderboard) Count(ctx context.Context) (int64, error) {
+	return l.rds.Client.ZCard(ctx, l.key).Result()
+}
+
+//Top 排名前 n 的成员, n 不大于 0 时返回空
+func (l *Leaderboard) Top(ctx context.Context, n int64) ([]RankEntry, error) {
+	if n <= 0 {
+		return []RankEntry{}, nil
+	}
+	return l.rangeByRank(ctx, 0, n-1)
+}
+
+//Page 分页读取排行榜, page 从 1 开始, size 不大于 0 时返回空
+func (l *Leaderboard) Page(ctx context.Context, page, size int64) ([]RankEntry, error) {
+	if size <= 0 {
+		return []RankEntry{}, nil
+	}
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * size
+	return l.rangeByRank(ctx, start, start+size-1)
+}
+
+//Around 成员及其前后各 n 名, 成员不存在时返回 Nil
+func (l *Leaderboard) Around(ctx context.Context, member string, n int64) ([]RankEntry, error) {
+	rank, err := l.rds.Client.ZRevRank(ctx, l.key, member).Result()
+	if err != nil {
+		return nil, err
+	}
+	start := rank - n
+	if start < 0 {
+		start = 0
+	}
+	return l.rangeByRank(ctx, start, rank+n)
+}
+
+//rangeByRank 按分数从高到低读取 [start, stop] 之间的成员, 下标从 0 开始
+func (l *Leaderboard) rangeByRank(ctx context.Context, start, stop int64) ([]RankEntry, error) {
+	items, err := l.rds.Client.ZRevRangeWithScores(ctx, l.key, start, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]RankEntry, len(items))
+	for i, item := range items {
+		member, _ := item.Member.(string)
+		entries[i] = RankEntry{Member: member, Score: item.Score, Rank: start + int64(i) + 1}
+	}
+	return entries, nil
+}
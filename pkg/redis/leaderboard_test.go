@@ -0,0 +1,86 @@
+package redis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	redis "github.com/go-redis/redis/v8"
+)
+
+//newTestClient 基于 miniredis 创建客户端
+func newTestClient(t *testing.T) *RedisClient {
+	s := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return &RedisClient{Client: client, Ctx: context.Background()}
+}
+
+func TestLeaderboardRange(t *testing.T) {
+	ctx := context.Background()
+	board := NewLeaderboard(newTestClient(t), "rank:test")
+	for i, member := range []string{"a", "b", "c", "d"} {
+		if err := board.Set(ctx, member, float64(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	top, err := board.Top(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 2 || top[0].Member != "d" || top[0].Rank != 1 || top[1].Member != "c" {
+		t.Fatalf("Top(2) = %+v", top)
+	}
+	page, err := board.Page(ctx, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page) != 1 || page[0].Member != "a" || page[0].Rank != 4 {
+		t.Fatalf("Page(2, 3) = %+v", page)
+	}
+
+	//n 或 size 不大于 0 时不应返回整个排行榜
+	for name, read := range map[string]func() ([]RankEntry, error){
+		"Top(0)":      func() ([]RankEntry, error) { return board.Top(ctx, 0) },
+		"Top(-1)":     func() ([]RankEntry, error) { return board.Top(ctx, -1) },
+		"Page(1, 0)":  func() ([]RankEntry, error) { return board.Page(ctx, 1, 0) },
+		"Page(2, -1)": func() ([]RankEntry, error) { return board.Page(ctx, 2, -1) },
+	} {
+		entries, err := read()
+		if err != nil || entries == nil || len(entries) != 0 {
+			t.Fatalf("%s = %+v, %v, 期望空列表", name, entries, err)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	ctx := context.Background()
+	filter := NewBloomFilter(newTestClient(t), "bloom:test", 1000, 0.01)
+	if err := filter.Add(ctx, "1", "2", "3"); err != nil {
+		t.Fatal(err)
+	}
+	for _, element := range []string{"1", "2", "3"} {
+		if ok, err := filter.Exists(ctx, element); err != nil || !ok {
+			t.Fatalf("Exists(%q) = %v, %v", element, ok, err)
+		}
+	}
+
+	//误判率接近 1 时位图至少 1 位
+	if filter := NewBloomFilter(nil, "bloom:tiny", 1, 0.999999); filter.bits < 1 || filter.hashes < 1 {
+		t.Fatalf("bits=%d hashes=%d", filter.bits, filter.hashes)
+	}
+}
+
+func TestBloomFilterInvalidRate(t *testing.T) {
+	for _, fpRate := range []float64{0, 1, -0.1, 1.5} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("误判率 %v 应当 panic", fpRate)
+				}
+			}()
+			NewBloomFilter(nil, "bloom:invalid", 1000, fpRate)
+		}()
+	}
+}
@@ -0,0 +1,122 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+//Pipeline 将 fn 中的命令一次发送给 redis, 减少网络往返, 命令之间不保证原子性。
+//fn 中命令的结果在 Pipeline 返回后才可读取, 任意一条命令出错时返回第一个错误(redis.Nil 除外)
+func (rds *RedisClient) Pipeline(ctx context.Context, fn func(pipe redis.Pipeliner) error) ([]redis.Cmder, error) {
+	cmds, err := rds.Client.Pipelined(ctx, fn)
+	if err == redis.Nil {
+		err = nil
+	}
+	return cmds, err
+}
+
+//Transaction 与 Pipeline 相同, 但命令包裹在 MULTI/EXEC 中原子地执行
+func (rds *RedisClient) Transaction(ctx context.Context, fn func(pipe redis.Pipeliner) error) ([]redis.Cmder, error) {
+	cmds, err := rds.Client.TxPipelined(ctx, fn)
+	if err == redis.Nil {
+		err = nil
+	}
+	return cmds, err
+}
+
+//Watch 乐观锁事务: fn 中先读取 keys, 再通过 tx.TxPipelined 写入, 期间 keys 被其他客户端修改时返回 redis.TxFailedErr,
+//调用方可以重试, 用法如下:
+//	err := rds.Watch(ctx, func(tx *goredis.Tx) error {
+//		n, err := tx.Get(ctx, "stock").Int()
+//		if err != nil {
+//			return err
+//		}
+//		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+//			return pipe.Set(ctx, "stock", n-1, 0).Err()
+//		})
+//		return err
+//	}, "stock")
+func (rds *RedisClient) Watch(ctx context.Context, fn func(tx *redis.Tx) error, keys ...string) error {
+	return rds.Client.Watch(ctx, fn, keys...)
+}
+
+//Publish 发布消息, 返回收到消息的订阅者数量
+func (rds *RedisClient) Publish(ctx context.Context, channel string, message interface{}) (int64, error) {
+	return rds.Client.Publish(ctx, channel, message).Result()
+}
+
+//Subscribe 订阅频道并对每条消息调用 handler, 阻塞直到 ctx 结束, 连接断开时由 go-redis 自动重连并重新订阅。
+//channels 中包含通配符(* ? [])时使用 PSUBSCRIBE
+func (rds *RedisClient) Subscribe(ctx context.Context, handler func(channel string, payload string), channels ...string) error {
+	var pubsub *redis.PubSub
+	if hasPattern(channels) {
+		pubsub = rds.Client.PSubscribe(ctx, channels...)
+	} else {
+		pubsub = rds.Client.Subscribe(ctx, channels...)
+	}
+	defer pubsub.Close()
+
+	//等待订阅确认, 以便及时发现连接错误
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg, ok := <-messages:
+			if !ok {
+				return errors.New("订阅已关闭")
+			}
+			handler(msg.Channel, msg.Payload)
+		}
+	}
+}
+
+//hasPattern 频道名中是否包含通配符
+func hasPattern(channels []string) bool {
+	for _, channel := range channels {
+		for _, c := range channel {
+			if c == '*' || c == '?' || c == '[' {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//scripts 已注册的 Lua 脚本
+var scripts sync.Map
+
+//RegisterScript 注册 Lua 脚本, 之后通过 RunScript 按名称执行, 一般在包的 init 或变量初始化时注册
+func RegisterScript(name string, src string) *redis.Script {
+	script := redis.NewScript(src)
+	scripts.Store(name, script)
+	return script
+}
+
+//RunScript 执行已注册的脚本, 优先使用 EVALSHA, redis 中没有缓存该脚本时自动改用 EVAL
+func (rds *RedisClient) RunScript(ctx context.Context, name string, keys []string, args ...interface{}) *redis.Cmd {
+	script, ok := scripts.Load(name)
+	if !ok {
+		cmd := redis.NewCmd(ctx)
+		cmd.SetErr(errors.New("脚本未注册: " + name))
+		return cmd
+	}
+	return script.(*redis.Script).Run(ctx, rds.Client, keys, args...)
+}
+
+//LoadScripts 将已注册的全部脚本预先加载到 redis, 避免首次执行时 EVALSHA 失败再 EVAL
+func (rds *RedisClient) LoadScripts(ctx context.Context) error {
+	var err error
+	scripts.Range(func(name, script interface{}) bool {
+		err = script.(*redis.Script).Load(ctx, rds.Client).Err()
+		return err == nil
+	})
+	return err
+}
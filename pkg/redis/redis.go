@@ -4,68 +4,46 @@ package redis
 import (
 	"context"
 	"fmt"
-	"gin-api/pkg/config"
 	"gin-api/pkg/logger"
 	redis "github.com/go-redis/redis/v8"
 	"github.com/spf13/cast"
-
-	"sync"
 	"time"
 )
 
-// RedisClient redisClient 服务
+// RedisClient redisClient 服务, Client 可能是单节点、哨兵或集群客户端
 type RedisClient struct {
-	Client *redis.Client
+	Client redis.UniversalClient
 	Ctx    context.Context
 }
 
-// once 确保默认的 redisClient 对象只实例一次
-var once sync.Once
-
-var defaultClient *RedisClient
-
-//DefaultClient 基于默认配置初始化 RedisClient
+//DefaultClient 返回默认的命名连接, 即 Connection("default")
 func DefaultClient() *RedisClient {
-	once.Do(func() {
-		address := fmt.Sprintf("%v:%v", config.GetString("redis.host"), config.GetString("redis.port"))
-		username := config.GetString("redis.username")
-		password := config.GetString("redis.password")
-		dbIndex := config.GetInt("redis.database")
-		defaultClient = NewClient(address, username, password, dbIndex)
-	})
-	return defaultClient
+	return Connection("default")
 }
 
-//NewClient 基于自定义配置初始化 RedisClient
-func NewClient(address string, password string, username string, dbIndex int) *RedisClient {
-	// 使用 redis 库里的 connect 初始化连接
-	client := redis.NewClient(&redis.Options{
-		Addr:     address,
+//NewClient 基于自定义配置初始化单节点的 RedisClient
+func NewClient(address string, username string, password string, dbIndex int) *RedisClient {
+	rds, _ := NewUniversalClient(ModeStandalone, &redis.UniversalOptions{
+		Addrs:    []string{address},
 		Username: username,
 		Password: password,
 		DB:       dbIndex,
 	})
-
-	//context
-	ctx := context.Background()
-
-	//初始化 RedisClient 对象
-	rds := &RedisClient{Client: client, Ctx: ctx}
-
-	// 测试连接
-	err := rds.Ping()
-	logger.LogIf("redis-Ping", err)
-
 	return rds
 }
 
 //Lock 获取锁
+//
+//Deprecated: 锁的值固定为 1, ReleaseLock 可能误删其他实例的锁, 请使用 pkg/lock
 func (rds *RedisClient) Lock(key string, expire time.Duration) bool {
 	lockValue := 1
 	//Note: 这里不能使用 .Err() 来判断是否加锁成功
 	ok, err := rds.Client.SetNX(rds.Ctx, key, lockValue, expire).Result()
-	if !ok {
+	if err != nil {
 		logger.Log("Lock", err.Error())
+		return false
+	}
+	if !ok {
 		//获取锁失败后，检查是不是死锁
 		if rds.Client.TTL(rds.Ctx, key).Val() == time.Duration(-1) {
 			rds.Client.Expire(rds.Ctx, key, expire)
@@ -76,6 +54,8 @@ func (rds *RedisClient) Lock(key string, expire time.Duration) bool {
 }
 
 //ReleaseLock 释放锁
+//
+//Deprecated: 不校验锁的持有者, 请使用 pkg/lock
 func (rds *RedisClient) ReleaseLock(key string) bool {
 	err := rds.Client.Del(rds.Ctx, key).Err()
 	if err != nil {
@@ -94,8 +74,8 @@ func (rds *RedisClient) Ping() error {
 	return err
 }
 
-//Redis 返回原生客户端 redis.Client
-func (rds *RedisClient) Redis() *redis.Client {
+//Redis 返回原生客户端 redis.UniversalClient
+func (rds *RedisClient) Redis() redis.UniversalClient {
 	return rds.Client
 }
 
@@ -204,7 +184,11 @@ func (rds RedisClient) Increment(parameters ...interface{}) bool {
 		}
 	case 2:
 		key := parameters[0].(string)
-		value := parameters[0].(int64)
+		value, ok := parameters[1].(int64)
+		if !ok {
+			logger.Log("Increment", "增量必须为 int64")
+			return false
+		}
 		if err := rds.Client.IncrBy(rds.Ctx, key, value).Err(); err != nil {
 			logger.Log("Increment", err.Error())
 			return false
@@ -228,7 +212,11 @@ func (rds RedisClient) Decrement(parameters ...interface{}) bool {
 		}
 	case 2:
 		key := parameters[0].(string)
-		value := parameters[0].(int64)
+		value, ok := parameters[1].(int64)
+		if !ok {
+			logger.Log("Decrement", "减量必须为 int64")
+			return false
+		}
 		if err := rds.Client.DecrBy(rds.Ctx, key, value).Err(); err != nil {
 			logger.Log("Decrement", err.Error())
 			return false
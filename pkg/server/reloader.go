@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"gin-api/pkg/logger"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+//CertReloader 持有当前使用的证书, 文件在磁盘上变更后自动重新加载, 无需重启进程
+type CertReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+	mux       sync.RWMutex
+}
+
+//NewCertReloader 实例化并立即加载一次证书, clientCAFile 为空表示不校验客户端证书
+func NewCertReloader(certFile, keyFile, clientCAFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+		modTimes:     make(map[string]time.Time),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+//Reload 重新加载证书以及客户端 CA, 加载失败时继续使用旧证书
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("加载证书失败: %v", err)
+	}
+
+	var pool *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := ioutil.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("加载客户端 CA 失败: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("客户端 CA 中没有有效的证书")
+		}
+	}
+
+	r.mux.Lock()
+	r.cert = &cert
+	r.clientCAs = pool
+	for _, file := range r.files() {
+		if info, err := os.Stat(file); err == nil {
+			r.modTimes[file] = info.ModTime()
+		}
+	}
+	r.mux.Unlock()
+	return nil
+}
+
+//GetCertificate 实现 tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.cert, nil
+}
+
+//ClientCAs 返回当前的客户端 CA
+func (r *CertReloader) ClientCAs() *x509.CertPool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	return r.clientCAs
+}
+
+//Watch 定期检查证书文件的修改时间, 变更后重新加载, ctx 取消后退出
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				logger.Log("CertReloader", err.Error())
+				continue
+			}
+			logger.Log("CertReloader", "证书已重新加载: "+r.certFile)
+		}
+	}
+}
+
+//changed 判断证书文件是否有变更
+func (r *CertReloader) changed() bool {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CertReloader) files() []string {
+	files := []string{r.certFile, r.keyFile}
+	if r.clientCAFile != "" {
+		files = append(files, r.clientCAFile)
+	}
+	return files
+}
@@ -0,0 +1,139 @@
+// Package server 负责启动 http 服务, 支持 TLS、HTTP/2、h2c、双向 TLS 以及证书热加载
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"gin-api/pkg/logger"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+//客户端证书的校验方式
+const (
+	ClientAuthNone          = ""
+	ClientAuthVerifyIfGiven = "verify_if_given"
+	ClientAuthRequire       = "require"
+)
+
+//Options 定义了服务的启动参数
+type Options struct {
+	//Addr 监听地址, 如 ":443"
+	Addr string
+
+	//CertFile/KeyFile 不为空时启用 TLS(同时启用 HTTP/2)
+	CertFile string
+	KeyFile  string
+
+	//ClientCAFile 不为空时启用双向 TLS, 使用该 CA 校验客户端证书
+	ClientCAFile string
+	//ClientAuth 客户端证书的校验方式, 可选 verify_if_given, require
+	ClientAuth string
+
+	//RedirectAddr 不为空时额外监听该地址, 将 http 请求 301 跳转到 https
+	RedirectAddr string
+
+	//H2C 未启用 TLS 时是否支持明文 HTTP/2
+	H2C bool
+
+	//ReloadInterval 检查证书文件变更的间隔
+	ReloadInterval time.Duration
+}
+
+//Run 启动服务, 阻塞直到服务退出
+func Run(handler http.Handler, opt Options) error {
+	srv := &http.Server{
+		Addr:              opt.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if opt.CertFile == "" {
+		if opt.H2C {
+			srv.Handler = h2c.NewHandler(handler, &http2.Server{})
+		}
+		return srv.ListenAndServe()
+	}
+
+	tlsConfig, err := newTLSConfig(opt)
+	if err != nil {
+		return err
+	}
+	srv.TLSConfig = tlsConfig
+	if err := http2.ConfigureServer(srv, &http2.Server{}); err != nil {
+		return err
+	}
+
+	if opt.RedirectAddr != "" {
+		go func() {
+			err := http.ListenAndServe(opt.RedirectAddr, redirectHandler(opt.Addr))
+			logger.LogIf("RedirectServer", err)
+		}()
+	}
+
+	return srv.ListenAndServeTLS("", "")
+}
+
+//newTLSConfig 构造 tls 配置, 证书和客户端 CA 均通过 CertReloader 动态获取
+func newTLSConfig(opt Options) (*tls.Config, error) {
+	reloader, err := NewCertReloader(opt.CertFile, opt.KeyFile, opt.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	interval := opt.ReloadInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	go reloader.Watch(context.Background(), interval)
+
+	clientAuth := tls.NoClientCert
+	if opt.ClientCAFile != "" {
+		switch opt.ClientAuth {
+		case ClientAuthRequire:
+			clientAuth = tls.RequireAndVerifyClientCert
+		case ClientAuthVerifyIfGiven, ClientAuthNone:
+			clientAuth = tls.VerifyClientCertIfGiven
+		default:
+			return nil, fmt.Errorf("无效的客户端证书校验方式: %s", opt.ClientAuth)
+		}
+	}
+
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     clientAuth,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if clientAuth == tls.NoClientCert {
+		return base, nil
+	}
+
+	//每次握手时使用最新的客户端 CA
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := base.Clone()
+		cfg.GetConfigForClient = nil
+		cfg.ClientCAs = reloader.ClientCAs()
+		return cfg, nil
+	}
+	return base, nil
+}
+
+//redirectHandler 将 http 请求跳转到 https
+func redirectHandler(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,119 @@
+package signature
+
+import (
+	"gin-api/pkg/redis"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//ClientStore 客户端凭证仓库, 返回 appKey 当前有效的全部 secret。
+//密钥轮换期间新旧 secret 同时返回, 任意一个校验通过即可。
+type ClientStore interface {
+	Secrets(appKey string) ([]string, error)
+}
+
+//StoreFunc 将普通函数适配为 ClientStore
+type StoreFunc func(appKey string) ([]string, error)
+
+func (f StoreFunc) Secrets(appKey string) ([]string, error) {
+	return f(appKey)
+}
+
+//MapStore 基于内存 map 的凭证仓库, 一般由配置文件构造
+type MapStore map[string][]string
+
+func (m MapStore) Secrets(appKey string) ([]string, error) {
+	return m[appKey], nil
+}
+
+//NonceStore 记录已使用过的 nonce, 首次记录成功返回 true, 已存在返回 false
+type NonceStore interface {
+	Remember(appKey, nonce string, ttl time.Duration) (bool, error)
+}
+
+//RedisNonceStore 基于 redis SETNX 的 nonce 仓库
+type RedisNonceStore struct {
+	redis     *redis.RedisClient
+	keyPrefix string
+}
+
+//NewRedisNonceStore 实例化 nonce 仓库
+func NewRedisNonceStore(rds *redis.RedisClient, keyPrefix string) *RedisNonceStore {
+	return &RedisNonceStore{redis: rds, keyPrefix: keyPrefix}
+}
+
+func (r *RedisNonceStore) Remember(appKey, nonce string, ttl time.Duration) (bool, error) {
+	return r.redis.Client.SetNX(r.redis.Ctx, r.keyPrefix+appKey+":"+nonce, 1, ttl).Result()
+}
+
+//Verifier 服务端签名校验器
+type Verifier struct {
+	Clients ClientStore
+	Nonces  NonceStore
+	//Window 允许的客户端与服务端时间误差, 超出则视为过期请求
+	Window time.Duration
+}
+
+//Verify 校验请求签名, 成功返回请求方的 appKey
+func (v *Verifier) Verify(req *http.Request) (string, error) {
+	appKey := req.Header.Get(HeaderAppKey)
+	timestamp := req.Header.Get(HeaderTimestamp)
+	nonce := req.Header.Get(HeaderNonce)
+	sign := req.Header.Get(HeaderSignature)
+	if appKey == "" || timestamp == "" || nonce == "" || sign == "" {
+		return "", ErrMissingHeader
+	}
+
+	//时间窗口校验
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrExpired
+	}
+	diff := time.Since(time.Unix(ts, 0))
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > v.Window {
+		return "", ErrExpired
+	}
+
+	secrets, err := v.Clients.Secrets(appKey)
+	if err != nil {
+		return "", err
+	}
+	if len(secrets) == 0 {
+		return "", ErrUnknownClient
+	}
+
+	body, err := readBody(req)
+	if err != nil {
+		return "", err
+	}
+	canonical := CanonicalString(req.Method, req.URL.EscapedPath(), req.URL.RawQuery, body, timestamp, nonce)
+
+	matched := false
+	for _, secret := range secrets {
+		if secret != "" && Equal(Sign(secret, canonical), sign) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return "", ErrInvalid
+	}
+
+	//签名通过后才记录 nonce, 避免伪造请求消耗合法客户端的 nonce;
+	//nonce 的保存时间覆盖整个时间窗口(前后各 Window), 窗口外的重放会被时间校验拦截
+	if v.Nonces != nil {
+		ok, err := v.Nonces.Remember(appKey, strings.ToLower(nonce), 2*v.Window)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return "", ErrReplay
+		}
+	}
+	return appKey, nil
+}
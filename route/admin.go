@@ -1,11 +1,14 @@
 package route
 
 import (
+	"gin-api/application/middleware"
 	"github.com/gin-gonic/gin"
 )
 
 func RegisterAdminRouter(r *gin.Engine) *gin.Engine {
 	admin := r.Group("/admin")
+
+	admin.Use(middleware.IpFilter("admin"))
 	{
 		admin.Any("/foo", func(c *gin.Context) {
 			c.String(200, "bar")
@@ -2,8 +2,10 @@ package route
 
 import (
 	"gin-api/application/errcode"
+	"gin-api/application/http/controller"
 	"gin-api/application/middleware"
 	"gin-api/pkg/jwt"
+	"gin-api/pkg/limiter"
 	"gin-api/pkg/response"
 	"github.com/gin-gonic/gin"
 	"time"
@@ -12,7 +14,7 @@ import (
 func RegisterApiRouter(r *gin.Engine) *gin.Engine {
 	api := r.Group("/api")
 
-	api.Use(middleware.LimitRouteAndIp("500-H"))
+	api.Use(middleware.LimitRouteAndIp(limiter.Named("api")))
 	{
 		api.Any("/foo", func(ctx *gin.Context) {
 			response.Json(ctx, errcode.Success, "", nil)
@@ -27,7 +29,7 @@ func RegisterApiRouter(r *gin.Engine) *gin.Engine {
 		})
 
 		//token 相关
-		tokenGroup := api.Group("/token").Use(middleware.LimitRouteAndIp("2-M"))
+		tokenGroup := api.Group("/token").Use(middleware.LimitRouteAndIp(limiter.Named("token")))
 		{
 			//生成token
 			tokenGroup.Any("/get", func(ctx *gin.Context) {
@@ -49,8 +51,15 @@ func RegisterApiRouter(r *gin.Engine) *gin.Engine {
 			})
 		}
 
+		//第三方登录
+		oauthGroup := api.Group("/oauth/:provider").Use(middleware.LimitRouteAndIp(limiter.Named("oauth")))
+		{
+			oauthGroup.GET("/redirect", controller.OauthRedirect)
+			oauthGroup.GET("/callback", controller.OauthCallback)
+		}
+
 		//带有版本号的接口
-		v1 := api.Group("/v1").Use(middleware.LimitRoute("2-M"))
+		v1 := api.Group("/v1").Use(middleware.LimitRoute(limiter.Named("v1")))
 		{
 			v1.Any("/info", middleware.JwtAuth(), func(ctx *gin.Context) {
 				response.Json(ctx, errcode.Success, "success", gin.H{"version": "v1"})